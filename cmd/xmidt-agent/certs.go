@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xmidt-org/arrange/arrangetls"
+	"github.com/xmidt-org/xmidt-agent/internal/certs"
+	"github.com/xmidt-org/xmidt-agent/internal/certs/event"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrClientCertificatesConfig = errors.New("client certificates configuration error")
+)
+
+type clientCertsIn struct {
+	fx.In
+	ClientCertificates ClientCertificates
+	Creds              XmidtCredentials
+	Websocket          Websocket
+	LC                 fx.Lifecycle
+	Logger             *zap.Logger
+}
+
+// clientCerts holds the certificate reloaders of the http clients that are
+// configured with client certificates.  Either reloader may be nil.
+type clientCerts struct {
+	ws    *certs.Reloader
+	creds *certs.Reloader
+}
+
+// Reload reloads the client certificates of all the http clients.
+func (c *clientCerts) Reload() error {
+	var errs []error
+	for _, r := range []*certs.Reloader{c.ws, c.creds} {
+		if r != nil {
+			errs = append(errs, r.Reload())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func provideClientCerts(in clientCertsIn) (*clientCerts, error) {
+	var (
+		c   clientCerts
+		err error
+	)
+
+	c.ws, err = newCertReloader(in, "websocket", in.Websocket.HTTPClient.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	c.creds, err = newCertReloader(in, "credentials", in.Creds.HTTPClient.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// newCertReloader creates a reloader for the client certificates in the TLS
+// configuration, or returns nil if there are none.
+func newCertReloader(in clientCertsIn, client string, tc *arrangetls.Config) (*certs.Reloader, error) {
+	if tc == nil || len(tc.Certificates) == 0 {
+		return nil, nil
+	}
+
+	logger := in.Logger.Named("certs").With(zap.String("client", client))
+	r, err := certs.New(
+		certs.Files(tc.Certificates...),
+		certs.CheckInterval(in.ClientCertificates.CheckInterval),
+		certs.AddReloadListener(event.ReloadListenerFunc(
+			func(e event.Reload) {
+				if e.Err != nil {
+					logger.Warn("failed to reload client certificates", zap.Error(e.Err))
+					return
+				}
+				logger.Info("loaded client certificates",
+					zap.Strings("files", e.Files),
+					zap.Time("not_after", e.NotAfter),
+				)
+			})),
+	)
+	if err != nil {
+		return nil, errors.Join(ErrClientCertificatesConfig, err)
+	}
+
+	in.LC.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			r.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			r.Stop()
+			return nil
+		},
+	})
+
+	return r, nil
+}
@@ -20,8 +20,11 @@ import (
 	"github.com/xmidt-org/retry"
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus"
 	"github.com/xmidt-org/xmidt-agent/internal/configuration"
 	"github.com/xmidt-org/xmidt-agent/internal/net"
+	"github.com/xmidt-org/xmidt-agent/internal/proxy"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket"
 	"github.com/xmidt-org/xmidt-agent/internal/wrphandlers/qos"
 	"gopkg.in/dealancer/validate.v2"
 )
@@ -31,33 +34,74 @@ var defaultConfigFile []byte
 
 // Config is the configuration for the xmidt-agent.
 type Config struct {
-	Pubsub           Pubsub
-	Websocket        Websocket
-	LibParodus       LibParodus
-	Identity         Identity
-	OperationalState OperationalState
-	XmidtCredentials XmidtCredentials
-	XmidtService     XmidtService
-	Logger           sallust.Config
-	Storage          Storage
-	MockTr181        MockTr181
-	QOS              QOS
-	Externals        []configuration.External
-	XmidtAgentCrud   XmidtAgentCrud
-	Metadata         Metadata
-	NetworkService   NetworkService
-	FilesystemViewer FilesystemViewer
+	Pubsub             Pubsub
+	Websocket          Websocket
+	LibParodus         LibParodus
+	Identity           Identity
+	OperationalState   OperationalState
+	XmidtCredentials   XmidtCredentials
+	XmidtService       XmidtService
+	Logger             sallust.Config
+	Storage            Storage
+	MockTr181          MockTr181
+	QOS                QOS
+	Externals          []configuration.External
+	XmidtAgentCrud     XmidtAgentCrud
+	Metadata           Metadata
+	NetworkService     NetworkService
+	FilesystemViewer   FilesystemViewer
+	ClientCertificates ClientCertificates
+}
+
+// ClientCertificates is the configuration for reloading the client
+// certificates of the websocket and credentials http clients.
+type ClientCertificates struct {
+	// CheckInterval is how often the certificate and key files are checked for
+	// changes.  Zero disables checking, so the certificates are only reloaded
+	// with an UPDATE of the xmidt-agent crud "certificates" path.
+	CheckInterval time.Duration
 }
 
 type LibParodus struct {
-	// ParodusServiceURL is the service url used by libparodus
+	// ParodusServiceURL is the service url used by libparodus.  Both tcp://
+	// and ipc:// (Unix domain socket) urls are supported.
 	ParodusServiceURL string
 	// KeepAliveInterval is the keep alive interval for libparodus.
 	KeepAliveInterval time.Duration
+	// ServiceTimeout is how long a registered client may go without sending
+	// anything before it is unregistered.  Zero disables the timeout.
+	ServiceTimeout time.Duration
 	// ReceiveTimeout is the Receive timeout for libparodus.
 	ReceiveTimeout time.Duration
 	// SendTimeout is the send timeout for libparodus.
 	SendTimeout time.Duration
+	// SendQueueSize is the number of messages that may be queued for each
+	// client while waiting to be sent.  Zero sends messages synchronously.
+	SendQueueSize int
+	// SendRetries is the number of additional attempts made to deliver a
+	// queued message before it is dropped.
+	SendRetries int
+	// SendRetryDelay is the time to wait between delivery attempts.
+	SendRetryDelay time.Duration
+	// DropPolicy determines which message is dropped when a client's send
+	// queue is full: "oldest" (default) or "newest".
+	DropPolicy libparodus.DropPolicy
+	// SocketPermissions are the file permissions applied to the Unix domain
+	// socket when an ipc:// url is used.
+	SocketPermissions fs.FileMode
+	// AllowedUIDs is the list of peer user ids allowed to register services
+	// or publish messages.  Requires an ipc:// url.  Empty allows any peer.
+	AllowedUIDs []int
+	// AllowedGIDs is the list of peer group ids allowed to register services
+	// or publish messages.  Requires an ipc:// url.  Empty allows any peer.
+	AllowedGIDs []int
+	// Services is the allow-list of services that may register, along with
+	// the callback urls and peer user ids permitted for each.  Empty allows
+	// any service to register.
+	Services []libparodus.ServicePolicy
+	// AllowServiceReplacement allows a registered service to be taken over by
+	// a client with a different url or peer.
+	AllowServiceReplacement bool
 }
 
 type QOS struct {
@@ -90,6 +134,21 @@ type Websocket struct {
 	URLPath string
 	// BackUpURL is the back up XMiDT service endpoint in case `XmidtCredentials.URL` fails.
 	BackUpURL string
+	// (optional) Endpoints are additional XMiDT service endpoints used when the primary
+	// endpoint is unhealthy.  Each url is a base url that URLPath is appended to.  Lower
+	// priorities are preferred and endpoints with the same priority share connections by
+	// weight.
+	Endpoints []websocket.Endpoint
+	// (optional) FailureThreshold is the number of consecutive connection failures before an
+	// endpoint is avoided for FailureCooldown.  If this is not set, the default is 3.
+	FailureThreshold int
+	// (optional) FailureCooldown is how long an endpoint is avoided once it reaches the
+	// FailureThreshold.  If this is not set, the default is 1 minute.
+	FailureCooldown time.Duration
+	// (optional) ReturnToPreferredInterval is how often a connection to a fallback endpoint
+	// checks if a preferred endpoint is healthy again.  If this is not set, the default is
+	// 10 minutes.
+	ReturnToPreferredInterval time.Duration
 	// AdditionalHeaders are any additional headers for the WS connection.
 	AdditionalHeaders http.Header
 	// FetchURLTimeout is the timeout for the fetching the WS url. If this is not set, the default is 30 seconds.
@@ -100,12 +159,38 @@ type Websocket struct {
 	PingWriteTimeout time.Duration
 	// SendTimeout is the send timeout for the WS connection.
 	SendTimeout time.Duration
+	// (optional) SendQueueSize is the number of messages that may be waiting to be written
+	// to the WS connection.  If this is not set, the default is 64.
+	SendQueueSize int
+	// (optional) FlushOnConnect is the number of messages held while the WS connection is
+	// down and written once it is made.  If this is not set, the default is 0 and sends
+	// fail while disconnected.
+	FlushOnConnect int
 	// HTTPClient is the configuration for the HTTP client.
 	HTTPClient arrangehttp.ClientConfig
 	// KeepAliveInterval is the keep alive interval for the WS connection.
 	KeepAliveInterval time.Duration
 	// MaxMessageBytes is the largest allowable message to send or receive.
 	MaxMessageBytes int64
+	// (optional) ReconnectRules replace the default rules for how to reconnect based on the
+	// close code from the server or the response to a connection attempt.
+	ReconnectRules []websocket.ReconnectRule
+	// (optional) RTTInterval is how often the server is pinged to measure the round trip
+	// time of the WS connection.  If this is not set, the round trip time is not measured.
+	RTTInterval time.Duration
+	// (optional) StatsInterval is how often the WS connection stats are logged.  If this is
+	// not set, the stats are not logged.
+	StatsInterval time.Duration
+	// (optional) Compression is the permessage-deflate mode requested from the server:
+	// disabled, context_takeover or no_context_takeover.  If this is not set, the default
+	// is disabled.
+	Compression websocket.CompressionMode
+	// (optional) CompressionThreshold is the smallest message in bytes that is compressed.
+	// If this is not set, the library default is used.
+	CompressionThreshold int
+	// (optional) Proxy is the HTTP CONNECT or SOCKS5 proxy used for the WS connection.  If
+	// this is not set, the proxy is taken from the environment.
+	Proxy proxy.Config
 	// (optional) DisableV4 determines whether or not to allow IPv4 for the WS connection.
 	// If this is not set, the default is false (IPv4 is enabled).
 	// Either V4 or V6 can be disabled, but not both.
@@ -114,6 +199,21 @@ type Websocket struct {
 	// If this is not set, the default is false (IPv6 is enabled).
 	// Either V4 or V6 can be disabled, but not both.
 	DisableV6 bool
+	// (optional) FallbackDelay is how long to wait for a connection over the preferred
+	// IP mode before racing the other mode, when both are enabled.  If this is not set,
+	// the default is 250ms.  A negative value disables racing and the modes are
+	// alternated between connection attempts instead.
+	FallbackDelay time.Duration
+	// (optional) BindInterface pins the WS connection to the highest priority running
+	// interface in NetworkService.AllowedInterfaces, failing over to the next one when
+	// it goes down.  If this is not set, the default is false (any interface is used).
+	BindInterface bool
+	// (optional) BindToDevice binds the WS connection to the interface with SO_BINDTODEVICE
+	// (linux only, requires CAP_NET_RAW) instead of using the interface's source address.
+	BindToDevice bool
+	// (optional) InterfaceCheckInterval is how often the interface of the WS connection is
+	// checked to see if it is still running.  If this is not set, the default is 10 seconds.
+	InterfaceCheckInterval time.Duration
 	// RetryPolicy sets the retry policy factory used for delaying between retry attempts for reconnection.
 	RetryPolicy retry.Config
 	// Once sets whether or not to only attempt to connect once.
@@ -150,16 +250,38 @@ type OperationalState struct {
 	// BootTime is the time the device was last booted.
 	BootTime time.Time
 
-	// network interface to use for connection from agent to webpa cloud
+	// network interface to use for connection from agent to webpa cloud.  This is
+	// replaced by the interface actually used when Websocket.BindInterface is set.
 	WebpaInterfaceUsed string
 }
 
 // XmidtCredentials contains the information needed to retrieve the credentials
 // from the XMiDT credential server.
 type XmidtCredentials struct {
+	// Source is where the credentials come from:
+	//	- "issuer" fetches them from the URL (the default)
+	//	- "oauth2" uses the OAuth2 client credentials grant
+	//	- "file" reads them from the TokenFile kept up to date by another process
+	//	- "static" uses the Token
+	//	- "mtls" uses the client certificate only
+	Source string
+
 	// URL is the URL of the XMiDT credential server.
 	URL string
 
+	// ClientCredentials is the configuration for the "oauth2" source.
+	ClientCredentials OAuth2Credentials
+
+	// TokenFile is the file read by the "file" source.
+	TokenFile string
+
+	// TokenFileCheckInterval is how often the TokenFile is read.  If this is
+	// not set, the default is 1 minute.
+	TokenFileCheckInterval time.Duration
+
+	// Token is the token used by the "static" source.
+	Token string
+
 	// HTTPClient is the configuration for the HTTP client used to retrieve the
 	// credentials.
 	HTTPClient arrangehttp.ClientConfig
@@ -181,6 +303,70 @@ type XmidtCredentials struct {
 
 	// WaitUntilFetched is the time the xmidt-agent blocks on startup until an attempt to fetch the credentials has been made.
 	WaitUntilFetched time.Duration
+
+	// Proxy is the HTTP CONNECT or SOCKS5 proxy used to retrieve the
+	// credentials.  If this is not set, the proxy is taken from the
+	// environment.
+	Proxy proxy.Config
+
+	// IssuerAlgorithms is the list of algorithms allowed when verifying the
+	// credentials with the issuer keys.  Valid values are the same as the
+	// JwtTxtRedirector.AllowedAlgorithms.
+	IssuerAlgorithms []string
+
+	// IssuerPEMs is the list of PEM-encoded public keys of the credential
+	// issuer.  If any issuer keys are provided, the credentials must be a JWT
+	// signed by one of them.
+	IssuerPEMs []string
+
+	// IssuerPEMFiles is the list of files containing PEM-encoded public keys
+	// of the credential issuer.
+	IssuerPEMFiles []string
+
+	// Encryption is the configuration for encrypting the credentials file at
+	// rest.  If no keys are configured the file is not encrypted.
+	Encryption FileEncryption
+
+	// RetryPolicy is the backoff used between failed attempts to fetch the
+	// credentials.  A Retry-After from the server is honored if it is longer.
+	// If the interval is not set, an exponential backoff with jitter capped
+	// at 5 minutes is used.
+	RetryPolicy retry.Config
+}
+
+// OAuth2Credentials is the configuration for obtaining the credentials with the
+// OAuth2 client credentials grant.  The HTTPClient of the XmidtCredentials is
+// used for the request.
+type OAuth2Credentials struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+
+	// ClientID is the client identifier.
+	ClientID string
+
+	// ClientSecret is the client secret.
+	ClientSecret string
+
+	// Scopes are the optional scopes to request.
+	Scopes []string
+}
+
+// FileEncryption is the configuration for encrypting a file at rest with
+// AES-GCM.  The first key is used to encrypt and all of the keys are used to
+// decrypt, so a key is rotated by adding the new key in front of the old one.
+// The keys are used in the order: key files, keyring keys, device identity.
+type FileEncryption struct {
+	// KeyFiles is the list of files containing a 16, 24 or 32 byte key as
+	// raw bytes, hex or base64.
+	KeyFiles []string
+
+	// KeyringKeys is the list of descriptions of "user" keys in the kernel
+	// keyring.
+	KeyringKeys []string
+
+	// DeviceIdentity derives a key from the device identity.  The identity
+	// is not a secret, so this only protects against casual disclosure.
+	DeviceIdentity bool
 }
 
 // XmidtService contains the configuration for the XMiDT service endpoint.
@@ -216,6 +402,48 @@ type JwtTxtRedirector struct {
 
 	// PEMFiles is the list of files containing PEM-encoded public keys to use
 	PEMFiles []string
+
+	// BaseURLs is the list of additional base URLs to look for the TXT record
+	// under, tried in order after the XmidtService URL.
+	BaseURLs []string
+
+	// Resolvers is the ordered list of resolvers to use for each base URL.
+	// "system" is the system resolver and an https URL is a DNS-over-HTTPS
+	// server, for example "https://cloudflare-dns.com/dns-query".  The
+	// default is the system resolver.
+	Resolvers []string
+
+	// FileName is the name and path of the file to store the most recent
+	// valid JWT in, so the redirect survives a restart.  There will be another
+	// file with the same name and a ".sha256" extension that contains the
+	// SHA256 hash of the file.  If a KeySetURL is used, the fetched key sets
+	// are stored in a file with the same name and a ".jwks" extension.  If
+	// empty the JWT is not stored.
+	FileName string
+
+	// FilePermissions is the permissions to use when creating the file.
+	FilePermissions fs.FileMode
+
+	// RefetchPercent is the percentage of the remaining lifetime of the JWT to
+	// wait before looking it up again in the background.
+	RefetchPercent float64
+
+	// RetryInterval is the time to wait before looking the JWT up again after
+	// a failure.
+	RetryInterval time.Duration
+
+	// KeySetFiles is the list of files containing JSON Web Key Sets to use
+	// for verification.  The key with the kid of the JWT is used, and keys
+	// with "nbf" or "exp" members are only used during that window.
+	KeySetFiles []string
+
+	// KeySetURL is the url to fetch a signed JSON Web Key Set from.  The key
+	// set must be a JWT signed by one of the configured keys or a previously
+	// fetched key.  If empty the key set is not fetched.
+	KeySetURL string
+
+	// KeySetRefreshInterval is the time between fetches of the key set.
+	KeySetRefreshInterval time.Duration
 }
 
 type XmidtAgentCrud struct {
@@ -250,16 +478,35 @@ type NetworkService struct {
 	AllowedInterfaces map[string]net.AllowedInterface
 }
 
+// FilesystemViewer is the configuration for the filesystem viewer, which
+// returns directory listings and files for signed requests.
 type FilesystemViewer struct {
+	// Enabled turns the filesystem viewer on.
 	Enabled bool
 
+	// ServiceName is the service name the viewer handles requests for.
+	ServiceName string
+
+	// Root is the directory the viewer reads from.  Paths in requests are
+	// relative to this directory.  There is no default; it is required when
+	// the viewer is enabled.
+	Root string
+
+	// MaxFileSize is the most bytes of a file that are returned, even if the
+	// request asks for more.  0 means there is no limit.
 	MaxFileSize int
 
-	// PEMs is the list of PEM-encoded public keys to use for verification.
+	// PEMs is the list of PEM-encoded root CA certificates that the signers
+	// of requests must chain to.
 	PEMs []string
 
-	// PEMFiles is the list of files containing PEM-encoded public keys to use
+	// PEMFiles is the list of files containing PEM-encoded root CA
+	// certificates.
 	PEMFiles []string
+
+	// Policies is the list of certificate policies that the signers of
+	// requests are required to have.
+	Policies []string
 }
 
 // Collect and process the configuration files and env vars and
@@ -332,5 +579,15 @@ func provideConfig(cli *CLI) (*goschtalt.Config, error) {
 		os.Exit(0)
 	}
 
+	if cli.JWTXT.selected {
+		// The jwtxt command checks the jwtxt record with the configuration,
+		// then the program is exited.
+		if err = cli.JWTXT.run(gs, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	return gs, nil
 }
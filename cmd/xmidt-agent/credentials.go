@@ -5,11 +5,19 @@ package main
 
 import (
 	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/xmidt-org/xmidt-agent/internal/credentials"
 	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
 	"github.com/xmidt-org/xmidt-agent/internal/fs"
+	xafsos "github.com/xmidt-org/xmidt-agent/internal/fs/os"
+	"github.com/xmidt-org/xmidt-agent/internal/metadata"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
@@ -18,14 +26,25 @@ const (
 	xmidtProtocol = "protocol"
 )
 
+// The credentials sources.
+const (
+	credentialsSourceIssuer = "issuer"
+	credentialsSourceOAuth2 = "oauth2"
+	credentialsSourceFile   = "file"
+	credentialsSourceStatic = "static"
+	credentialsSourceMTLS   = "mtls"
+)
+
 type credsIn struct {
 	fx.In
-	Creds   XmidtCredentials
-	ID      Identity
-	Ops     OperationalState
-	Durable fs.FS `name:"durable_fs" optional:"true"`
-	LC      fx.Lifecycle
-	Logger  *zap.Logger
+	Creds    XmidtCredentials
+	ID       Identity
+	Ops      OperationalState
+	Metadata *metadata.MetadataProvider
+	Certs    *clientCerts
+	Durable  fs.FS `name:"durable_fs" optional:"true"`
+	LC       fx.Lifecycle
+	Logger   *zap.Logger
 }
 
 type credsOut struct {
@@ -38,7 +57,7 @@ func (in credsIn) Options() ([]credentials.Option, error) {
 	logger := in.Logger.Named("credentials")
 
 	// If the URL is empty, then there is no credentials service to use.
-	if in.Creds.URL == "" {
+	if in.Creds.URL == "" && in.Creds.Source == "" {
 		logger.Warn("no credentials service configured")
 		return nil, nil
 	}
@@ -48,6 +67,23 @@ func (in credsIn) Options() ([]credentials.Option, error) {
 		return nil, err
 	}
 
+	// Pick up renewed client certificates without a restart.
+	if in.Certs != nil && in.Certs.creds != nil {
+		if t, ok := client.Transport.(*http.Transport); ok {
+			t.TLSClientConfig = in.Certs.creds.ConfigureTLS(t.TLSClientConfig)
+		}
+	}
+
+	if in.Creds.Proxy.URL != "" {
+		proxyFunc, err := in.Creds.Proxy.ProxyFunc()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := client.Transport.(*http.Transport); ok {
+			t.Proxy = proxyFunc
+		}
+	}
+
 	opts := []credentials.Option{
 		credentials.URL(in.Creds.URL),
 		credentials.HTTPClient(client),
@@ -58,6 +94,7 @@ func (in credsIn) Options() ([]credentials.Option, error) {
 		credentials.FirmwareVersion(in.ID.FirmwareVersion),
 		credentials.PartnerID(func() string { return in.ID.PartnerID }),
 		credentials.LastRebootReason(in.Ops.LastRebootReason),
+		credentials.LastReconnectReason(in.Metadata.GetLastReconnectReason),
 		credentials.XmidtProtocol(xmidtProtocol),
 		credentials.BootRetryWait(time.Second),
 		credentials.RefetchPercent(in.Creds.RefetchPercent),
@@ -73,18 +110,113 @@ func (in credsIn) Options() ([]credentials.Option, error) {
 					zap.Time("expiration", e.Expiration),
 					zap.Error(e.Err),
 				)
+				if e.StoreErr != nil {
+					logger.Error("failed to rewrite the cached credentials",
+						zap.Error(e.StoreErr))
+				}
 			})),
 	}
 
-	if in.Durable != nil {
+	source, err := in.Creds.source(client)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		opts = append(opts, credentials.UseSource(source))
+	}
+
+	if in.Creds.RetryPolicy.Interval > 0 {
+		opts = append(opts, credentials.RetryPolicy(in.Creds.RetryPolicy))
+	}
+
+	if len(in.Creds.IssuerPEMs) > 0 || len(in.Creds.IssuerPEMFiles) > 0 {
+		pems, err := in.Creds.issuerPEMs()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			credentials.IssuerKeys(pems...),
+			credentials.IssuerAlgorithms(in.Creds.IssuerAlgorithms...),
+		)
+	}
+
+	// Only the credentials fetched over the network are worth caching.
+	if in.Durable != nil && in.Creds.networked() {
 		opts = append(opts,
 			credentials.LocalStorage(in.Durable, in.Creds.FileName, in.Creds.FilePermissions),
 		)
+
+		cipher, err := in.Creds.Encryption.cipher(in.ID)
+		if err != nil {
+			return nil, err
+		}
+		if cipher != nil {
+			opts = append(opts, credentials.EncryptStorage(cipher))
+		}
 	}
 
 	return opts, nil
 }
 
+// source returns the configured credentials source, or nil for the issuer.
+func (c XmidtCredentials) source(client *http.Client) (credentials.Source, error) {
+	switch c.Source {
+	case "", credentialsSourceIssuer:
+		return nil, nil
+	case credentialsSourceOAuth2:
+		return credentials.OAuth2{
+			TokenURL:     c.ClientCredentials.TokenURL,
+			ClientID:     c.ClientCredentials.ClientID,
+			ClientSecret: c.ClientCredentials.ClientSecret,
+			Scopes:       c.ClientCredentials.Scopes,
+			Client:       client,
+		}, nil
+	case credentialsSourceFile:
+		f, err := xafsos.New(filepath.Dir(c.TokenFile))
+		if err != nil {
+			return nil, err
+		}
+		return credentials.FileToken(f, filepath.Base(c.TokenFile), c.TokenFileCheckInterval), nil
+	case credentialsSourceStatic:
+		return credentials.StaticToken(c.Token), nil
+	case credentialsSourceMTLS:
+		return credentials.MTLSOnly(), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown credentials source '%s'", credentials.ErrInvalidInput, c.Source)
+}
+
+// networked returns true if the credentials are fetched over the network.
+func (c XmidtCredentials) networked() bool {
+	switch c.Source {
+	case "", credentialsSourceIssuer, credentialsSourceOAuth2:
+		return true
+	}
+	return false
+}
+
+// issuerPEMs returns the configured issuer keys.
+func (c XmidtCredentials) issuerPEMs() ([][]byte, error) {
+	pems := make([][]byte, 0, len(c.IssuerPEMs)+len(c.IssuerPEMFiles))
+	for _, item := range c.IssuerPEMs {
+		block, rest := pem.Decode([]byte(item))
+		if block == nil || strings.TrimSpace(string(rest)) != "" {
+			return nil, credentials.ErrInvalidInput
+		}
+		pems = append(pems, pem.EncodeToMemory(block))
+	}
+
+	for _, pemFile := range c.IssuerPEMFiles {
+		data, err := os.ReadFile(pemFile)
+		if err != nil {
+			return nil, err
+		}
+		pems = append(pems, data)
+	}
+
+	return pems, nil
+}
+
 func provideCredentials(in credsIn) (credsOut, error) {
 	opts, err := in.Options()
 	if err != nil || opts == nil {
@@ -111,3 +243,43 @@ func provideCredentials(in credsIn) (credsOut, error) {
 		WaitUntilFetched: in.Creds.WaitUntilFetched,
 	}, err
 }
+
+// cipher returns the cipher for the configured keys, or nil if there are no
+// keys configured.
+func (e FileEncryption) cipher(id Identity) (*fs.Cipher, error) {
+	var keys [][]byte
+	for _, file := range e.KeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := fs.ParseKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, desc := range e.KeyringKeys {
+		key, err := fs.KeyFromKeyring(desc)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if e.DeviceIdentity {
+		key, err := fs.DeriveKey(string(id.DeviceID), id.SerialNumber,
+			id.HardwareManufacturer, id.HardwareModel)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return fs.NewCipher(keys...)
+}
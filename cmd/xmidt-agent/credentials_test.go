@@ -8,6 +8,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xmidt-org/xmidt-agent/internal/credentials"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest/observer"
 )
@@ -78,6 +79,86 @@ func Test_credsIn_Options(t *testing.T) {
 				credentials.MacAddress("mac:112233445566"),
 			},
 		},
+		{
+			description: "Invalid issuer PEM",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					URL:              "http://example.com",
+					IssuerAlgorithms: []string{"ES256"},
+					IssuerPEMs:       []string{"invalid"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			description: "Missing issuer PEM file",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					URL:              "http://example.com",
+					IssuerAlgorithms: []string{"ES256"},
+					IssuerPEMFiles:   []string{"does-not-exist.pem"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			description: "mTLS only source without a URL",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					Source: "mtls",
+				},
+			},
+		},
+		{
+			description: "OAuth2 source",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					Source: "oauth2",
+					ClientCredentials: OAuth2Credentials{
+						TokenURL: "http://example.com/token",
+						ClientID: "client",
+					},
+				},
+				Durable: mem.New(),
+			},
+		},
+		{
+			description: "Unknown source",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					Source: "unknown",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			description: "Encrypted storage",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					URL: "http://example.com",
+					Encryption: FileEncryption{
+						DeviceIdentity: true,
+					},
+				},
+				ID: Identity{
+					DeviceID: "mac:112233445566",
+				},
+				Durable: mem.New(),
+			},
+		},
+		{
+			description: "Missing encryption key file",
+			in: credsIn{
+				Creds: XmidtCredentials{
+					URL: "http://example.com",
+					Encryption: FileEncryption{
+						KeyFiles: []string{"does-not-exist.key"},
+					},
+				},
+				Durable: mem.New(),
+			},
+			wantErr: true,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.description, func(t *testing.T) {
@@ -4,11 +4,16 @@
 package main
 
 import (
+	"context"
 	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
 	"go.uber.org/fx"
@@ -20,6 +25,8 @@ type instructionsIn struct {
 	Service XmidtService
 	ID      Identity
 	Logger  *zap.Logger
+	Durable fs.FS `name:"durable_fs" optional:"true"`
+	LC      fx.Lifecycle
 }
 type instructionsOut struct {
 	fx.Out
@@ -29,24 +36,30 @@ type instructionsOut struct {
 }
 
 func provideInstructions(in instructionsIn) (instructionsOut, error) {
-	// If no PEMs are provided then the jwtxt can't be used because it won't
-	// have any keys to use.
+	// If no PEMs or key sets are provided then the jwtxt can't be used
+	// because it won't have any keys to use.
 	if in.Service.URL == "" ||
-		(in.Service.JwtTxtRedirector.PEMFiles == nil && in.Service.JwtTxtRedirector.PEMs == nil) {
+		(in.Service.JwtTxtRedirector.PEMFiles == nil && in.Service.JwtTxtRedirector.PEMs == nil &&
+			in.Service.JwtTxtRedirector.KeySetFiles == nil) {
 		return instructionsOut{}, nil
 	}
 
 	logger := in.Logger.Named("jwtxt")
 
-	opts := []jwtxt.Option{
-		jwtxt.BaseURL(in.Service.URL),
-		jwtxt.DeviceID(string(in.ID.DeviceID)),
-		jwtxt.Algorithms(in.Service.JwtTxtRedirector.AllowedAlgorithms...),
-		jwtxt.Timeout(in.Service.JwtTxtRedirector.Timeout),
+	opts, err := jwtxtOptions(in.Service, in.ID)
+	if err != nil {
+		return instructionsOut{}, err
+	}
+
+	opts = append(opts,
+		jwtxt.RefetchPercent(in.Service.JwtTxtRedirector.RefetchPercent),
+		jwtxt.RetryInterval(in.Service.JwtTxtRedirector.RetryInterval),
 		jwtxt.WithFetchListener(event.FetchListenerFunc(
 			func(fe event.Fetch) {
 				logger.Debug("fetch",
+					zap.String("origin", fe.Origin),
 					zap.String("fqdn", fe.FQDN),
+					zap.String("resolver", fe.Resolver),
 					zap.String("server", fe.Server),
 					zap.Bool("found", fe.Found),
 					zap.Bool("timeout", fe.Timeout),
@@ -60,20 +73,81 @@ func provideInstructions(in instructionsIn) (instructionsOut, error) {
 					zap.Error(fe.Err),
 				)
 			})),
+		jwtxt.WithKeySetFetchListener(event.KeySetFetchListenerFunc(
+			func(e event.KeySetFetch) {
+				logger.Debug("key set fetch",
+					zap.String("origin", e.Origin),
+					zap.String("url", e.URL),
+					zap.Time("at", e.At),
+					zap.Duration("duration", e.Duration),
+					zap.Int("status_code", e.StatusCode),
+					zap.Int("keys", e.Keys),
+					zap.Time("issued_at", e.IssuedAt),
+					zap.Time("expiration", e.Expiration),
+					zap.Error(e.Err),
+				)
+			})),
+	)
+
+	if in.Durable != nil && in.Service.JwtTxtRedirector.FileName != "" {
+		opts = append(opts,
+			jwtxt.LocalStorage(in.Durable,
+				in.Service.JwtTxtRedirector.FileName,
+				in.Service.JwtTxtRedirector.FilePermissions),
+		)
 	}
 
-	if len(in.Service.JwtTxtRedirector.PEMs) > 0 {
-		pems := make([][]byte, 0, len(in.Service.JwtTxtRedirector.PEMs))
-		for _, item := range in.Service.JwtTxtRedirector.PEMs {
+	jwtxt, err := jwtxt.New(opts...)
+	if err != nil {
+		return instructionsOut{}, err
+	}
+
+	in.LC.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			jwtxt.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			jwtxt.Stop()
+			return nil
+		},
+	})
+
+	return instructionsOut{
+		JWTXT:     jwtxt,
+		DeviceID:  in.ID.DeviceID,
+		PartnerID: in.ID.PartnerID}, nil
+}
+
+// jwtxtOptions returns the options for where to find the JWT TXT record and
+// how to verify it.
+func jwtxtOptions(svc XmidtService, id Identity) ([]jwtxt.Option, error) {
+	resolvers, err := svc.JwtTxtRedirector.resolvers()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []jwtxt.Option{
+		jwtxt.BaseURL(svc.URL),
+		jwtxt.BaseURL(svc.JwtTxtRedirector.BaseURLs...),
+		jwtxt.UseResolver(resolvers...),
+		jwtxt.DeviceID(string(id.DeviceID)),
+		jwtxt.Algorithms(svc.JwtTxtRedirector.AllowedAlgorithms...),
+		jwtxt.Timeout(svc.JwtTxtRedirector.Timeout),
+	}
+
+	if len(svc.JwtTxtRedirector.PEMs) > 0 {
+		pems := make([][]byte, 0, len(svc.JwtTxtRedirector.PEMs))
+		for _, item := range svc.JwtTxtRedirector.PEMs {
 			block, rest := pem.Decode([]byte(item))
 
 			if block == nil || strings.TrimSpace(string(rest)) != "" {
-				return instructionsOut{}, jwtxt.ErrInvalidInput
+				return nil, jwtxt.ErrInvalidInput
 			}
 
 			buf := pem.EncodeToMemory(block)
 			if buf == nil {
-				return instructionsOut{}, jwtxt.ErrInvalidInput
+				return nil, jwtxt.ErrInvalidInput
 			}
 
 			pems = append(pems, buf)
@@ -81,20 +155,53 @@ func provideInstructions(in instructionsIn) (instructionsOut, error) {
 		opts = append(opts, jwtxt.WithPEMs(pems...))
 	}
 
-	if len(in.Service.JwtTxtRedirector.PEMFiles) > 0 {
-		for _, pemFile := range in.Service.JwtTxtRedirector.PEMFiles {
+	if len(svc.JwtTxtRedirector.PEMFiles) > 0 {
+		for _, pemFile := range svc.JwtTxtRedirector.PEMFiles {
 			data, err := os.ReadFile(pemFile)
 			if err != nil {
-				return instructionsOut{}, err
+				return nil, err
 			}
 			opts = append(opts, jwtxt.WithPEMs(data))
 		}
 	}
 
-	jwtxt, err := jwtxt.New(opts...)
+	for _, keySetFile := range svc.JwtTxtRedirector.KeySetFiles {
+		data, err := os.ReadFile(keySetFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, jwtxt.WithJWKS(data))
+	}
 
-	return instructionsOut{
-		JWTXT:     jwtxt,
-		DeviceID:  in.ID.DeviceID,
-		PartnerID: in.ID.PartnerID}, err
+	if svc.JwtTxtRedirector.KeySetURL != "" {
+		opts = append(opts,
+			jwtxt.JWKSURL(svc.JwtTxtRedirector.KeySetURL,
+				svc.JwtTxtRedirector.KeySetRefreshInterval))
+	}
+
+	return opts, nil
+}
+
+// resolvers returns the configured resolvers, or the system resolver if none
+// are configured.
+func (j JwtTxtRedirector) resolvers() ([]jwtxt.Resolver, error) {
+	if len(j.Resolvers) == 0 {
+		return []jwtxt.Resolver{net.DefaultResolver}, nil
+	}
+
+	resolvers := make([]jwtxt.Resolver, 0, len(j.Resolvers))
+	for _, r := range j.Resolvers {
+		if r == "system" {
+			resolvers = append(resolvers, net.DefaultResolver)
+			continue
+		}
+
+		u, err := url.Parse(r)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("%w: invalid resolver '%s'", jwtxt.ErrInvalidInput, r)
+		}
+		resolvers = append(resolvers, jwtxt.DoHResolver{URL: r})
+	}
+
+	return resolvers, nil
 }
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt"
+)
+
+func Test_JwtTxtRedirector_resolvers(t *testing.T) {
+	tests := []struct {
+		description string
+		resolvers   []string
+		want        []jwtxt.Resolver
+		wantErr     bool
+	}{
+		{
+			description: "default",
+			want:        []jwtxt.Resolver{net.DefaultResolver},
+		}, {
+			description: "system then dns over https",
+			resolvers:   []string{"system", "https://dns.example.org/dns-query"},
+			want: []jwtxt.Resolver{
+				net.DefaultResolver,
+				jwtxt.DoHResolver{URL: "https://dns.example.org/dns-query"},
+			},
+		}, {
+			description: "plain http is not allowed",
+			resolvers:   []string{"http://dns.example.org/dns-query"},
+			wantErr:     true,
+		}, {
+			description: "unknown resolver",
+			resolvers:   []string{"isp"},
+			wantErr:     true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			got, err := JwtTxtRedirector{Resolvers: tc.resolvers}.resolvers()
+			if tc.wantErr {
+				assert.ErrorIs(err, jwtxt.ErrInvalidInput)
+				assert.Nil(got)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tc.want, got)
+		})
+	}
+}
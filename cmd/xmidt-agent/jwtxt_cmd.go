@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/goschtalt/goschtalt"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt"
+)
+
+var errNoValidRecord = errors.New("no valid jwtxt record")
+
+// JWTXTCmd is the jwtxt command, which validates and decodes the JWT TXT
+// redirect record using the configuration so redirection problems can be
+// diagnosed.
+type JWTXTCmd struct {
+	Lines []string `arg:"" optional:"" help:"The lines of the TXT record.  If no lines are given the record is looked up."`
+	Input string   `optional:"" short:"i" help:"Read the lines of the TXT record from the file, one per line, or from stdin if '-'."`
+
+	// selected is set when the command is used.
+	selected bool
+}
+
+// AfterApply is called by kong when the command is used.
+func (j *JWTXTCmd) AfterApply() error {
+	j.selected = true
+	return nil
+}
+
+// run checks the record based on the configuration and writes what was found
+// to w.
+func (j JWTXTCmd) run(gs *goschtalt.Config, stdin io.Reader, w io.Writer) error {
+	svc, err := goschtalt.Unmarshal[XmidtService](gs, "xmidt_service")
+	if err != nil {
+		return err
+	}
+
+	id, err := goschtalt.Unmarshal[Identity](gs, "identity")
+	if err != nil {
+		return err
+	}
+
+	return j.check(svc, id, stdin, w)
+}
+
+func (j JWTXTCmd) check(svc XmidtService, id Identity, stdin io.Reader, w io.Writer) error {
+	cfg := svc.JwtTxtRedirector
+	if svc.URL == "" {
+		return errors.New("xmidt_service.url is not configured")
+	}
+	if len(cfg.PEMs) == 0 && len(cfg.PEMFiles) == 0 && len(cfg.KeySetFiles) == 0 {
+		return errors.New("xmidt_service.jwt_txt_redirector has no keys configured")
+	}
+
+	opts, err := jwtxtOptions(svc, id)
+	if err != nil {
+		return err
+	}
+
+	ins, err := jwtxt.New(opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if cfg.KeySetURL != "" {
+		if err = ins.FetchKeySet(ctx); err != nil {
+			fmt.Fprintf(w, "Key set %s could not be fetched: %v\n\n", cfg.KeySetURL, err)
+		}
+	}
+
+	lines, err := j.lines(stdin)
+	if err != nil {
+		return err
+	}
+
+	var recs []jwtxt.Record
+	if len(lines) > 0 {
+		rec, _ := ins.Decode(lines)
+		recs = append(recs, rec)
+	} else {
+		recs, _ = ins.Lookup(ctx)
+	}
+
+	now := time.Now()
+	for i, rec := range recs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printRecord(w, rec, cfg.AllowedAlgorithms, now)
+	}
+
+	if len(recs) == 0 || recs[len(recs)-1].Err != nil {
+		return errNoValidRecord
+	}
+	return nil
+}
+
+// lines returns the lines of the TXT record from the arguments or the input.
+// The lines may be copied from the output of dig, where each line is quoted
+// and long lines are split into several quoted strings.
+func (j JWTXTCmd) lines(stdin io.Reader) ([]string, error) {
+	raw := j.Lines
+
+	if j.Input != "" {
+		r := stdin
+		if j.Input != "-" {
+			f, err := os.Open(j.Input)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			raw = append(raw, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := make([]string, 0, len(raw))
+	for _, line := range raw {
+		if line = txtLine(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// txtLine returns the text of a line, joining the quoted strings if the line
+// is quoted.
+func txtLine(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, `"`) {
+		return line
+	}
+
+	var buf strings.Builder
+	for _, part := range strings.Split(line, `"`)[1:] {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		buf.WriteString(part)
+	}
+	return buf.String()
+}
+
+func printRecord(w io.Writer, rec jwtxt.Record, algs []string, now time.Time) {
+	if rec.FQDN != "" {
+		fmt.Fprintf(w, "Record:      %s (resolver: %s)\n", rec.FQDN, rec.Resolver)
+	}
+	if rec.Lines != nil {
+		fmt.Fprintf(w, "Lines:       %d\n", len(rec.Lines))
+	}
+	if rec.Header != nil {
+		header, _ := json.Marshal(rec.Header)
+		fmt.Fprintf(w, "Header:      %s\n", header)
+	}
+	if rec.Payload != nil {
+		fmt.Fprintf(w, "Claims:      %s\n", rec.Payload)
+	}
+	if !rec.Expiration.IsZero() {
+		left := rec.Expiration.Sub(now).Round(time.Second)
+		if left > 0 {
+			fmt.Fprintf(w, "Expires:     %s (in %s)\n", rec.Expiration.UTC().Format(time.RFC3339), left)
+		} else {
+			fmt.Fprintf(w, "Expired:     %s (%s ago)\n", rec.Expiration.UTC().Format(time.RFC3339), -left)
+		}
+	}
+
+	if rec.Verified {
+		r := rec.Redirect
+		if r.Endpoint != "" {
+			fmt.Fprintf(w, "Endpoint:    %s\n", r.Endpoint)
+		}
+		for _, ep := range r.Endpoints {
+			fmt.Fprintf(w, "Endpoints:   %s (priority: %d, weight: %d)\n", ep.URL, ep.Priority, ep.Weight)
+		}
+		if r.URLPath != "" {
+			fmt.Fprintf(w, "URL Path:    %s\n", r.URLPath)
+		}
+		if r.IPFamily != "" {
+			fmt.Fprintf(w, "IP Family:   %s\n", r.IPFamily)
+		}
+		if r.Retry != nil {
+			fmt.Fprintf(w, "Retry:       interval: %s, multiplier: %g, jitter: %g, max interval: %s\n",
+				r.Retry.Interval, r.Retry.Multiplier, r.Retry.Jitter, r.Retry.MaxInterval)
+		}
+		fmt.Fprintln(w, "Result:      valid")
+		return
+	}
+
+	fmt.Fprintf(w, "Result:      invalid: %v\n", rec.Err)
+	fmt.Fprintf(w, "Explanation: %s\n", explainJWTXT(rec, algs))
+}
+
+// explainJWTXT describes why the record is not valid and what to check.
+func explainJWTXT(rec jwtxt.Record, algs []string) string {
+	err := rec.Err
+
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) {
+		switch {
+		case dnsError.IsNotFound:
+			return "There is no TXT record for this name.  Check the device id and the base urls."
+		case dnsError.IsTimeout:
+			return "The DNS query timed out.  Large TXT records are sometimes dropped; " +
+				"try a DNS-over-HTTPS resolver."
+		}
+		return "The DNS query failed.  Check the network and the resolvers."
+	}
+
+	alg, _ := rec.Header["alg"].(string)
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "The lines don't reassemble into a JWT.  Each line must start with its two digit " +
+			"index and a colon (00: or 01:), and no line may be missing."
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "The JWT has expired.  The record must be signed again with a later expiration, " +
+			"or the clock of the device is wrong."
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "The JWT is not valid yet.  Check the clock of the device."
+	case alg != "" && !slices.Contains(algs, alg):
+		return fmt.Sprintf("The JWT is signed with '%s', which is not in the allowed_algorithms.", alg)
+	case errors.Is(err, jwtxt.ErrNoKeysMatch):
+		kid, _ := rec.Header["kid"].(string)
+		return fmt.Sprintf("No configured key has the kid '%s' and algorithm '%s' at this time.  "+
+			"Check the key sets and the nbf and exp of the keys.", kid, alg)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "The signature doesn't match the configured keys.  The record may be signed " +
+			"by a key that isn't configured, or it was changed after it was signed."
+	case errors.Is(err, jwtxt.ErrInvalidJWT):
+		return "The JWT is signed correctly, but the claims can't be used."
+	}
+
+	return "The record could not be used."
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func Test_JWTXTCmd_check(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	lines := func(key *ecdsa.PrivateKey, exp time.Time) []string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"endpoint": "https://fabric.example.org",
+			"exp":      exp.Unix(),
+		}).SignedString(key)
+		require.NoError(t, err)
+
+		var lines []string
+		for i := 0; len(token) > 0; i++ {
+			n := min(len(token), 100)
+			lines = append(lines, fmt.Sprintf("%02d:%s", i, token[:n]))
+			token = token[n:]
+		}
+		return lines
+	}
+
+	valid := lines(key, time.Now().Add(time.Hour))
+
+	svc := XmidtService{
+		URL: "https://example.org",
+		JwtTxtRedirector: JwtTxtRedirector{
+			AllowedAlgorithms: []string{"ES256"},
+			PEMs:              []string{pub},
+		},
+	}
+
+	tests := []struct {
+		description string
+		cmd         JWTXTCmd
+		stdin       string
+		svc         *XmidtService
+		contains    []string
+		wantErr     bool
+	}{
+		{
+			description: "valid",
+			cmd:         JWTXTCmd{Lines: valid},
+			contains: []string{
+				"Endpoint:    https://fabric.example.org",
+				"Result:      valid",
+			},
+		}, {
+			description: "valid from stdin as quoted by dig",
+			cmd:         JWTXTCmd{Input: "-"},
+			stdin: func() string {
+				// dig splits long strings into several quoted strings.
+				var buf strings.Builder
+				for _, line := range valid {
+					half := len(line) / 2
+					fmt.Fprintf(&buf, "%q %q\n", line[:half], line[half:])
+				}
+				return buf.String()
+			}(),
+			contains: []string{
+				"Result:      valid",
+			},
+		}, {
+			description: "expired",
+			cmd:         JWTXTCmd{Lines: lines(key, time.Now().Add(-time.Hour))},
+			contains: []string{
+				"Expired:",
+				"Result:      invalid",
+				"The JWT has expired.",
+			},
+			wantErr: true,
+		}, {
+			description: "missing a line",
+			cmd:         JWTXTCmd{Lines: valid[1:]},
+			contains: []string{
+				"The lines don't reassemble into a JWT.",
+			},
+			wantErr: true,
+		}, {
+			description: "signed by another key",
+			cmd:         JWTXTCmd{Lines: lines(other, time.Now().Add(time.Hour))},
+			contains: []string{
+				`"alg":"ES256"`,
+				"The signature doesn't match the configured keys.",
+			},
+			wantErr: true,
+		}, {
+			description: "algorithm not allowed",
+			cmd:         JWTXTCmd{Lines: valid},
+			svc: &XmidtService{
+				URL: "https://example.org",
+				JwtTxtRedirector: JwtTxtRedirector{
+					AllowedAlgorithms: []string{"EdDSA"},
+					PEMs:              []string{pub},
+				},
+			},
+			contains: []string{
+				"The JWT is signed with 'ES256', which is not in the allowed_algorithms.",
+			},
+			wantErr: true,
+		}, {
+			description: "no keys",
+			svc:         &XmidtService{URL: "https://example.org"},
+			wantErr:     true,
+		}, {
+			description: "no url",
+			svc:         &XmidtService{},
+			wantErr:     true,
+		}, {
+			description: "missing input file",
+			cmd:         JWTXTCmd{Input: "missing.txt"},
+			wantErr:     true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			s := svc
+			if tc.svc != nil {
+				s = *tc.svc
+			}
+
+			var out bytes.Buffer
+			err := tc.cmd.check(s,
+				Identity{DeviceID: wrp.DeviceID("mac:112233445566")},
+				strings.NewReader(tc.stdin), &out)
+
+			for _, want := range tc.contains {
+				assert.Contains(out.String(), want)
+			}
+
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func Test_txtLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "00:abc", want: "00:abc"},
+		{line: "  00:abc \t", want: "00:abc"},
+		{line: `"00:abc"`, want: "00:abc"},
+		{line: `"00:ab" "c"`, want: "00:abc"},
+		{line: "", want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			assert.Equal(t, tc.want, txtLine(tc.line))
+		})
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"errors"
 
 	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 )
 
 type libParodusIn struct {
@@ -18,13 +20,49 @@ type libParodusIn struct {
 	LibParodus LibParodus
 
 	PubSub *pubsub.PubSub
+	Logger *zap.Logger
 }
 
 func provideLibParodus(in libParodusIn) (*libparodus.Adapter, error) {
+	logger := in.Logger.Named("libparodus")
+
 	libParodusDefaults := []libparodus.Option{
 		libparodus.KeepaliveInterval(in.LibParodus.KeepAliveInterval),
+		libparodus.ServiceTimeout(in.LibParodus.ServiceTimeout),
 		libparodus.ReceiveTimeout(in.LibParodus.ReceiveTimeout),
 		libparodus.SendTimeout(in.LibParodus.SendTimeout),
+		libparodus.SocketPermissions(in.LibParodus.SocketPermissions),
+		libparodus.AllowedUIDs(in.LibParodus.AllowedUIDs...),
+		libparodus.AllowedGIDs(in.LibParodus.AllowedGIDs...),
+		libparodus.SendQueueSize(in.LibParodus.SendQueueSize),
+		libparodus.SendRetries(in.LibParodus.SendRetries, in.LibParodus.SendRetryDelay),
+		libparodus.WithDropPolicy(in.LibParodus.DropPolicy),
+		libparodus.ServicePolicies(in.LibParodus.Services...),
+		libparodus.AllowServiceReplacement(in.LibParodus.AllowServiceReplacement),
+		libparodus.AddDropListener(event.DropListenerFunc(
+			func(e event.Drop) {
+				logger.Warn("message dropped",
+					zap.String("service", e.Service),
+					zap.Time("at", e.At),
+					zap.Int("attempts", e.Attempts),
+					zap.String("transaction_uuid", e.Message.TransactionUUID),
+					zap.Error(e.Err),
+				)
+			})),
+		libparodus.AddRegistrationListener(event.RegistrationListenerFunc(
+			func(e event.Registration) {
+				fields := []zap.Field{
+					zap.String("service", e.Service),
+					zap.String("url", e.URL),
+					zap.Int("uid", e.UID),
+					zap.Bool("replaced", e.Replaced),
+				}
+				if e.Err != nil {
+					logger.Warn("service registration rejected", append(fields, zap.Error(e.Err))...)
+					return
+				}
+				logger.Info("service registered", fields...)
+			})),
 	}
 	libParodus, err := libparodus.New(in.LibParodus.ParodusServiceURL, in.PubSub, libParodusDefaults...)
 	if err != nil {
@@ -42,6 +42,9 @@ type CLI struct {
 	Default string   `optional:""           help:"Output the default configuration file as the specified file."`
 	Graph   string   `optional:"" short:"g" help:"Output the dependency graph to the specified file."`
 	Files   []string `optional:"" short:"f" help:"Specific configuration files or directories."`
+
+	Run   struct{} `cmd:"" default:"1" hidden:"" help:"Run the agent."`
+	JWTXT JWTXTCmd `cmd:"" name:"jwtxt" help:"Validate and decode the jwtxt redirect record, then exit."`
 }
 
 type LifeCycleIn struct {
@@ -98,6 +101,7 @@ func provideAppOptions(args []string) fx.Option {
 			provideInstructions,
 			provideWS,
 			provideLibParodus,
+			provideClientCerts,
 
 			goschtalt.UnmarshalFunc[sallust.Config]("logger", goschtalt.Optional()),
 			goschtalt.UnmarshalFunc[Identity]("identity"),
@@ -112,6 +116,9 @@ func provideAppOptions(args []string) fx.Option {
 			goschtalt.UnmarshalFunc[NetworkService]("network_service"),
 			goschtalt.UnmarshalFunc[QOS]("qos"),
 			goschtalt.UnmarshalFunc[LibParodus]("lib_parodus"),
+			goschtalt.UnmarshalFunc[XmidtAgentCrud]("xmidt_agent_crud"),
+			goschtalt.UnmarshalFunc[ClientCertificates]("client_certificates"),
+			goschtalt.UnmarshalFunc[FilesystemViewer]("filesystem_viewer"),
 
 			provideNetworkService,
 			provideMetadataProvider,
@@ -30,6 +30,15 @@ func Test_provideCLI(t *testing.T) {
 			description: "dev mode",
 			args:        cliArgs{"-d"},
 			want:        CLI{Dev: true},
+		}, {
+			description: "jwtxt command",
+			args:        cliArgs{"jwtxt", "00:abc", "01:def"},
+			want: CLI{
+				JWTXT: JWTXTCmd{
+					Lines:    []string{"00:abc", "01:def"},
+					selected: true,
+				},
+			},
 		}, {
 			description: "invalid argument",
 			args:        cliArgs{"-w"},
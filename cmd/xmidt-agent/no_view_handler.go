@@ -1,11 +0,0 @@
-//go:build !viewer
-// +build !viewer
-
-// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
-// SPDX-License-Identifier: Apache-2.0
-
-package main
-
-func provideViewerHandler() error {
-	return nil
-}
@@ -1,19 +1,22 @@
-//go:build viewer
-// +build viewer
-
 // SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
 // SPDX-License-Identifier: Apache-2.0
 
 package main
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"os"
 
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"github.com/xmidt-org/xmidt-agent/internal/wrphandlers/viewer"
 	"go.uber.org/fx"
 )
 
+var errInvalidViewerPEM = errors.New("invalid filesystem viewer pem")
+
 type viewerIn struct {
 	fx.In
 
@@ -28,17 +31,92 @@ type viewerOut struct {
 }
 
 func provideViewerHandler(in viewerIn) (viewerOut, error) {
-	viewerHandler, err := viewer.New(in.PubSub, nil)
+	if !in.FilesystemViewer.Enabled {
+		return viewerOut{}, nil
+	}
+
+	// There is no default root, so the whole filesystem is never exposed by
+	// accident.
+	if in.FilesystemViewer.Root == "" {
+		return viewerOut{}, fmt.Errorf("%w: the filesystem viewer root is required", ErrWRPHandlerConfig)
+	}
+
+	trusted, err := in.FilesystemViewer.trustedRoots()
 	if err != nil {
 		return viewerOut{}, errors.Join(ErrWRPHandlerConfig, err)
 	}
 
-	viewer, err := in.PubSub.SubscribeService("/viewer", viewerHandler)
+	viewerHandler, err := viewer.New(in.PubSub,
+		viewer.Root(os.DirFS(in.FilesystemViewer.Root)),
+		viewer.Trust(trusted...),
+		viewer.Policies(in.FilesystemViewer.Policies...),
+		viewer.MaxFileSize(in.FilesystemViewer.MaxFileSize),
+	)
+	if err != nil {
+		return viewerOut{}, errors.Join(ErrWRPHandlerConfig, err)
+	}
+
+	cancel, err := in.PubSub.SubscribeService(in.FilesystemViewer.ServiceName, viewerHandler)
 	if err != nil {
 		return viewerOut{}, errors.Join(ErrWRPHandlerConfig, err)
 	}
 
 	return viewerOut{
-		Cancel: viewer,
+		Cancel: cancel,
 	}, nil
 }
+
+// trustedRoots returns the configured root CA certificates.
+func (v FilesystemViewer) trustedRoots() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, item := range v.PEMs {
+		c, err := parseCertificates([]byte(item))
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c...)
+	}
+
+	for _, pemFile := range v.PEMFiles {
+		data, err := os.ReadFile(pemFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, pemFile)
+		}
+		certs = append(certs, c...)
+	}
+
+	return certs, nil
+}
+
+// parseCertificates returns the certificates in the PEM data, which must
+// contain at least one certificate and nothing else.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%w: unexpected %s", errInvalidViewerPEM, block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Join(errInvalidViewerPEM, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: no certificates", errInvalidViewerPEM)
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
+)
+
+func Test_provideViewerHandler(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "viewer root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte(ca), 0600))
+
+	tests := []struct {
+		description string
+		cfg         FilesystemViewer
+		enabled     bool
+		wantErr     bool
+	}{
+		{
+			description: "disabled",
+			cfg: FilesystemViewer{
+				ServiceName: "viewer",
+			},
+		}, {
+			description: "enabled with pems",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				Root:        dir,
+				MaxFileSize: 100,
+				PEMs:        []string{ca},
+				Policies:    []string{"1.2.3.4"},
+			},
+			enabled: true,
+		}, {
+			description: "enabled with pem files",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				Root:        dir,
+				PEMFiles:    []string{caFile},
+			},
+			enabled: true,
+		}, {
+			description: "no trusted roots",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				Root:        dir,
+			},
+			wantErr: true,
+		}, {
+			description: "no root",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				PEMs:        []string{ca},
+			},
+			wantErr: true,
+		}, {
+			description: "invalid pem",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				Root:        dir,
+				PEMs:        []string{"invalid"},
+			},
+			wantErr: true,
+		}, {
+			description: "missing pem file",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "viewer",
+				Root:        dir,
+				PEMFiles:    []string{filepath.Join(dir, "missing.pem")},
+			},
+			wantErr: true,
+		}, {
+			description: "invalid service name",
+			cfg: FilesystemViewer{
+				Enabled:     true,
+				ServiceName: "/viewer",
+				Root:        dir,
+				PEMs:        []string{ca},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ps, err := pubsub.New(wrp.DeviceID("mac:112233445566"))
+			require.NoError(err)
+
+			got, err := provideViewerHandler(viewerIn{
+				FilesystemViewer: tc.cfg,
+				PubSub:           ps,
+			})
+			if tc.wantErr {
+				assert.ErrorIs(err, ErrWRPHandlerConfig)
+				assert.Nil(got.Cancel)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tc.enabled, got.Cancel != nil)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials"
 	"github.com/xmidt-org/xmidt-agent/internal/loglevel"
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"github.com/xmidt-org/xmidt-agent/internal/websocket"
@@ -132,25 +134,46 @@ type crudIn struct {
 	XmidtAgentCrud  XmidtAgentCrud
 	Identity        Identity
 	Egress          websocket.Egress
-	LogLevelService *loglevel.LogLevelService
+	LogLevelService loglevel.LogLevel
 	PubSub          *pubsub.PubSub
+	LibParodus      *libparodus.Adapter
+	Certs           *clientCerts
+	Cred            *credentials.Credentials
 }
 
-func provideCrudHandler(in crudIn) (*xmidt_agent_crud.Handler, error) {
-	h, err := xmidt_agent_crud.New(in.Egress, string(in.Identity.DeviceID), in.LogLevelService)
+type crudOut struct {
+	fx.Out
+
+	Handler *xmidt_agent_crud.Handler
+	Cancel  func() `group:"cancels"`
+}
+
+func provideCrudHandler(in crudIn) (crudOut, error) {
+	opts := []xmidt_agent_crud.Option{
+		xmidt_agent_crud.WithServices(in.LibParodus),
+		xmidt_agent_crud.WithCertificates(in.Certs),
+	}
+
+	// in.Cred is nil when no credentials are desired.
+	if in.Cred != nil {
+		opts = append(opts, xmidt_agent_crud.WithCredentials(in.Cred))
+	}
+
+	h, err := xmidt_agent_crud.New(in.Egress, string(in.Identity.DeviceID), in.LogLevelService, opts...)
 	if err != nil {
 		err = errors.Join(ErrWRPHandlerConfig, err)
-		return nil, err
+		return crudOut{}, err
 	}
 
-	// what do we do with the return value?  Does this have to be passed to pubSub somehow? Seems
-	// fishy if it does
-	_, err = in.PubSub.SubscribeService(in.XmidtAgentCrud.ServiceName, h)
+	cancel, err := in.PubSub.SubscribeService(in.XmidtAgentCrud.ServiceName, h)
 	if err != nil {
-		return nil, errors.Join(ErrWRPHandlerConfig, err)
+		return crudOut{}, errors.Join(ErrWRPHandlerConfig, err)
 	}
 
-	return h, err
+	return crudOut{
+		Handler: h,
+		Cancel:  cancel,
+	}, nil
 }
 
 type pubsubIn struct {
@@ -13,6 +13,7 @@ import (
 	"github.com/xmidt-org/xmidt-agent/internal/credentials"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt"
 	"github.com/xmidt-org/xmidt-agent/internal/metadata"
+	"github.com/xmidt-org/xmidt-agent/internal/net"
 	"github.com/xmidt-org/xmidt-agent/internal/websocket"
 	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
 	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
@@ -33,6 +34,9 @@ type wsIn struct {
 	Cred      *credentials.Credentials
 	Metadata  *metadata.MetadataProvider
 	Websocket Websocket
+	Certs     *clientCerts
+
+	NetworkService net.NetworkServicer
 }
 
 type wsOut struct {
@@ -60,7 +64,13 @@ func provideWS(in wsIn) (wsOut, error) {
 	var opts []websocket.Option
 	// Allow operations where no credentials are desired (in.Cred will be nil).
 	if in.Cred != nil {
-		opts = append(opts, websocket.CredentialsDecorator(in.Cred.Decorate))
+		opts = append(opts,
+			websocket.CredentialsDecorator(in.Cred.Decorate),
+			websocket.CredentialsRefresher(func(ctx context.Context) {
+				in.Cred.MarkInvalid(ctx)
+				in.Cred.WaitUntilValid(ctx)
+			}),
+		)
 	}
 
 	// Configuration options
@@ -73,25 +83,123 @@ func provideWS(in wsIn) (wsOut, error) {
 		websocket.InactivityTimeout(in.Websocket.InactivityTimeout),
 		websocket.PingWriteTimeout(in.Websocket.PingWriteTimeout),
 		websocket.SendTimeout(in.Websocket.SendTimeout),
+		websocket.FlushOnConnect(in.Websocket.FlushOnConnect),
 		websocket.KeepAliveInterval(in.Websocket.KeepAliveInterval),
 		websocket.HTTPClientWithForceSets(in.Websocket.HTTPClient),
 		websocket.MaxMessageBytes(in.Websocket.MaxMessageBytes),
+		websocket.Compression(in.Websocket.Compression, in.Websocket.CompressionThreshold),
+		websocket.RTTInterval(in.Websocket.RTTInterval),
+		websocket.StatsInterval(in.Websocket.StatsInterval),
 		websocket.ConveyDecorator(in.Metadata.Decorate),
+		websocket.ReconnectReason(in.Metadata.SetLastReconnectReason),
 		websocket.AdditionalHeaders(in.Websocket.AdditionalHeaders),
 		websocket.NowFunc(time.Now),
 		websocket.WithIPv6(!in.Websocket.DisableV6),
 		websocket.WithIPv4(!in.Websocket.DisableV4),
+		websocket.FallbackDelay(in.Websocket.FallbackDelay),
+		websocket.InterfaceCheckInterval(in.Websocket.InterfaceCheckInterval),
 		websocket.Once(in.Websocket.Once),
 		websocket.RetryPolicy(in.Websocket.RetryPolicy),
 	)
 
+	// The signed jwtxt record may steer the connection.
+	if in.JWTXT != nil {
+		opts = append(opts,
+			websocket.FetchRedirect(fetchRedirect(in.Websocket.URLPath, in.JWTXT.Redirect)))
+	}
+
+	if len(in.Websocket.ReconnectRules) > 0 {
+		opts = append(opts, websocket.ReconnectRules(in.Websocket.ReconnectRules...))
+	}
+
+	if in.Websocket.Proxy.URL != "" {
+		proxyFunc, err := in.Websocket.Proxy.ProxyFunc()
+		if err != nil {
+			return wsOut{}, errors.Join(ErrWebsocketConfig, err)
+		}
+		opts = append(opts, websocket.Proxy(proxyFunc))
+	}
+
+	if in.Websocket.SendQueueSize != 0 {
+		opts = append(opts, websocket.SendQueueSize(in.Websocket.SendQueueSize))
+	}
+
+	if len(in.Websocket.Endpoints) > 0 {
+		endpoints := make([]websocket.Endpoint, 0, len(in.Websocket.Endpoints))
+		for _, ep := range in.Websocket.Endpoints {
+			u, err := url.JoinPath(ep.URL, in.Websocket.URLPath)
+			if err != nil {
+				return wsOut{}, errors.Join(ErrWebsocketConfig, err)
+			}
+			ep.URL = u
+			endpoints = append(endpoints, ep)
+		}
+		opts = append(opts, websocket.Endpoints(endpoints...))
+	}
+
+	// Unset values keep the websocket defaults.
+	if in.Websocket.FailureThreshold != 0 || in.Websocket.FailureCooldown != 0 {
+		opts = append(opts,
+			websocket.CircuitBreaker(in.Websocket.FailureThreshold, in.Websocket.FailureCooldown))
+	}
+
+	if in.Websocket.ReturnToPreferredInterval != 0 {
+		opts = append(opts,
+			websocket.ReturnToPreferredInterval(in.Websocket.ReturnToPreferredInterval))
+	}
+
+	// Pick up renewed client certificates without a restart.
+	if in.Certs != nil && in.Certs.ws != nil {
+		opts = append(opts, websocket.ClientCertificates(in.Certs.ws.GetClientCertificate))
+	}
+
+	// Pin the connection to the network_service allowed interfaces.
+	if in.Websocket.BindInterface {
+		opts = append(opts,
+			websocket.Interfaces(in.NetworkService),
+			websocket.BindToDevice(in.Websocket.BindToDevice),
+			websocket.InterfaceUsed(in.Metadata.SetInterfaceUsed),
+		)
+	}
+
 	// Listener options
 	var (
-		msg, con, discon, heartbeat event.CancelFunc
-		cancels                     []func()
+		msg, con, discon, heartbeat, stats, failure event.CancelFunc
+		cancels                                     []func()
 	)
+	logger := in.Logger.Named("websocket")
+	opts = append(opts,
+		websocket.AddSendFailureListener(
+			event.SendFailureListenerFunc(
+				func(e event.SendFailure) {
+					logger.Warn("failed to send message",
+						zap.String("destination", e.Message.Destination),
+						zap.String("transaction_uuid", e.Message.TransactionUUID),
+						zap.Error(e.Err),
+					)
+				}), &failure),
+	)
+	if in.Websocket.StatsInterval > 0 {
+		opts = append(opts,
+			websocket.AddStatsListener(
+				event.StatsListenerFunc(
+					func(s event.Stats) {
+						logger.Info("connection stats",
+							zap.Bool("connected", s.Connected),
+							zap.Duration("uptime", s.Uptime),
+							zap.Int("reconnects", s.Reconnects),
+							zap.Uint64("bytes_sent", s.BytesSent),
+							zap.Uint64("bytes_received", s.BytesReceived),
+							zap.Uint64("messages_sent", s.MessagesSent),
+							zap.Uint64("messages_received", s.MessagesReceived),
+							zap.Duration("rtt", s.RTT),
+							zap.Duration("smoothed_rtt", s.SmoothedRTT),
+							zap.Uint64("ping_failures", s.PingFailures),
+						)
+					}), &stats),
+		)
+	}
 	if in.CLI.Dev {
-		logger := in.Logger.Named("websocket")
 		opts = append(opts,
 			websocket.AddMessageListener(
 				event.MsgListenerFunc(
@@ -120,10 +228,16 @@ func provideWS(in wsIn) (wsOut, error) {
 		err = errors.Join(ErrWebsocketConfig, err)
 	}
 
+	cancels = append(cancels, failure)
+
 	if in.CLI.Dev {
 		cancels = append(cancels, msg, con, discon, heartbeat)
 	}
 
+	if in.Websocket.StatsInterval > 0 {
+		cancels = append(cancels, stats)
+	}
+
 	return wsOut{
 		WS:      ws,
 		Egress:  ws,
@@ -149,3 +263,43 @@ func fetchURL(path, backUpURL string, f func(context.Context) (string, error)) f
 		return url.JoinPath(baseURL, path)
 	}
 }
+
+// fetchRedirect converts the jwtxt redirect into the websocket redirect.  The
+// url path from the redirect, if any, replaces the configured path.
+func fetchRedirect(path string, f func(context.Context) (jwtxt.Redirect, error)) func(context.Context) (websocket.Redirect, error) {
+	return func(ctx context.Context) (websocket.Redirect, error) {
+		r, err := f(ctx)
+		if err != nil {
+			return websocket.Redirect{}, err
+		}
+
+		urlPath := path
+		if r.URLPath != "" {
+			urlPath = r.URLPath
+		}
+
+		eps := r.Endpoints
+		if len(eps) == 0 && r.Endpoint != "" && r.URLPath != "" {
+			// The FetchURL endpoint uses the configured path, so replace it.
+			eps = []jwtxt.Endpoint{{URL: r.Endpoint}}
+		}
+
+		var out websocket.Redirect
+		for _, ep := range eps {
+			u, err := url.JoinPath(ep.URL, urlPath)
+			if err != nil {
+				return websocket.Redirect{}, err
+			}
+			out.Endpoints = append(out.Endpoints, websocket.Endpoint{
+				URL:      u,
+				Priority: ep.Priority,
+				Weight:   ep.Weight,
+			})
+		}
+
+		out.IPFamily = r.IPFamily
+		out.RetryPolicy = r.Retry
+
+		return out, nil
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/retry"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket"
+)
+
+func Test_fetchRedirect(t *testing.T) {
+	errUnknown := errors.New("unknown")
+	policy := &retry.Config{Interval: time.Second}
+
+	tests := []struct {
+		description string
+		redirect    jwtxt.Redirect
+		err         error
+		want        websocket.Redirect
+	}{
+		{
+			description: "only an endpoint",
+			redirect: jwtxt.Redirect{
+				Endpoint: "https://fabric.example.org",
+			},
+		}, {
+			description: "endpoint with a url path",
+			redirect: jwtxt.Redirect{
+				Endpoint: "https://fabric.example.org",
+				URLPath:  "/api/v3/device",
+			},
+			want: websocket.Redirect{
+				Endpoints: []websocket.Endpoint{
+					{URL: "https://fabric.example.org/api/v3/device"},
+				},
+			},
+		}, {
+			description: "endpoints and connection parameters",
+			redirect: jwtxt.Redirect{
+				Endpoint: "https://fabric.example.org",
+				Endpoints: []jwtxt.Endpoint{
+					{URL: "https://a.example.org", Priority: 1, Weight: 3},
+					{URL: "https://b.example.org", Priority: 2},
+				},
+				IPFamily: jwtxt.IPv6,
+				Retry:    policy,
+			},
+			want: websocket.Redirect{
+				Endpoints: []websocket.Endpoint{
+					{URL: "https://a.example.org/api/v2/device", Priority: 1, Weight: 3},
+					{URL: "https://b.example.org/api/v2/device", Priority: 2},
+				},
+				IPFamily:    "ipv6",
+				RetryPolicy: policy,
+			},
+		}, {
+			description: "error",
+			err:         errUnknown,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			f := fetchRedirect("/api/v2/device",
+				func(context.Context) (jwtxt.Redirect, error) {
+					return tc.redirect, tc.err
+				})
+
+			got, err := f(context.Background())
+			if tc.err != nil {
+				assert.ErrorIs(err, tc.err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tc.want, got)
+		})
+	}
+}
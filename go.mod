@@ -22,6 +22,9 @@ require (
 	go.nanomsg.org/mangos/v3 v3.4.2
 	go.uber.org/fx v1.23.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/crypto v0.29.0
+	golang.org/x/net v0.26.0
+	golang.org/x/sys v0.27.0
 	gopkg.in/dealancer/validate.v2 v2.1.0
 )
 
@@ -50,11 +53,8 @@ require (
 	github.com/xmidt-org/httpaux v0.4.1 // indirect
 	go.uber.org/dig v1.18.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/crypto v0.29.0 // indirect
 	golang.org/x/mod v0.18.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/tools v0.22.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -6,13 +6,17 @@ package libparodus
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
 	"go.nanomsg.org/mangos/v3"
@@ -128,10 +132,17 @@ func TestEnd2End(t *testing.T) {
 	require.NoError(err)
 	require.NotNil(ps)
 
+	var dropped atomic.Int64
 	a, err := New(lpURL, ps,
 		ReceiveTimeout(100*time.Millisecond),
 		SendTimeout(100*time.Millisecond),
 		KeepaliveInterval(100*time.Millisecond),
+		SendQueueSize(10),
+		SendRetries(2, 10*time.Millisecond),
+		WithDropPolicy(DropNewest),
+		AddDropListener(event.DropListenerFunc(func(event.Drop) {
+			dropped.Add(1)
+		})),
 	)
 	require.NoError(err)
 	require.NotNil(a)
@@ -244,6 +255,23 @@ func TestEnd2End(t *testing.T) {
 	})
 	assert.NoError(err)
 
+	// The message from test is handled by the connection of the test service.
+	mTest.WaitFor(ctx, wrp.Message{
+		Type: wrp.SimpleRequestResponseMessageType,
+	})
+
+	// Both services are registered and alive.
+	services := a.Services()
+	require.Len(services, 2)
+	assert.Equal("other", services[0].Name)
+	assert.Equal(lpOtherUrl, services[0].URL)
+	assert.True(services[0].Alive)
+	assert.Equal("test", services[1].Name)
+	assert.Equal(lpTestUrl, services[1].URL)
+	assert.True(services[1].Alive)
+	assert.False(services[1].RegisteredAt.IsZero())
+	assert.False(services[1].LastActivity.Before(services[1].RegisteredAt))
+
 	// Ensure the test service was sent a keepalive message.
 	mTest.WaitFor(ctx, wrp.Message{
 		Type: wrp.ServiceAliveMessageType,
@@ -264,6 +292,9 @@ func TestEnd2End(t *testing.T) {
 		Type: wrp.Invalid0MessageType,
 	}))
 
+	// Everything was delivered.
+	assert.Zero(dropped.Load())
+
 	// Now send a message to the 'other' service & get back that it isn't handled.
 	err = ps.HandleWrp(wrp.Message{
 		Type:        wrp.SimpleEventMessageType,
@@ -272,3 +303,230 @@ func TestEnd2End(t *testing.T) {
 	})
 	assert.ErrorIs(err, wrpkit.ErrNotHandled)
 }
+
+func TestEnd2EndIPC(t *testing.T) {
+	authAccepted := wrp.Message{
+		Type: wrp.AuthorizationMessageType,
+		Status: func() *int64 {
+			var s int64 = 200
+			return &s
+		}(),
+	}
+
+	tests := []struct {
+		description string
+		opts        []Option
+		registered  bool
+	}{
+		{
+			description: "no allow-lists",
+			registered:  true,
+		}, {
+			description: "allowed uid and gid",
+			opts: []Option{
+				AllowedUIDs(os.Getuid()),
+				AllowedGIDs(os.Getgid()),
+			},
+			registered: true,
+		}, {
+			description: "uid not allowed",
+			opts: []Option{
+				AllowedUIDs(os.Getuid() + 1),
+			},
+		}, {
+			description: "gid not allowed",
+			opts: []Option{
+				AllowedGIDs(os.Getgid() + 1),
+			},
+		}, {
+			description: "service uid allowed",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{
+					Name: "test",
+					UIDs: []int{os.Getuid()},
+				}),
+			},
+			registered: true,
+		}, {
+			description: "service uid not allowed",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{
+					Name: "test",
+					UIDs: []int{os.Getuid() + 1},
+				}),
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			lpURL := "ipc://" + filepath.Join(dir, "parodus.sock")
+			lpTestURL := "ipc://" + filepath.Join(dir, "test.sock")
+
+			self, err := wrp.ParseDeviceID("mac:112233445566")
+			require.NoError(err)
+
+			ps, err := pubsub.New(self, pubsub.WithPublishTimeout(200*time.Millisecond))
+			require.NoError(err)
+
+			opts := append([]Option{
+				ReceiveTimeout(100 * time.Millisecond),
+				SendTimeout(100 * time.Millisecond),
+				KeepaliveInterval(100 * time.Millisecond),
+				SocketPermissions(0660),
+			}, tc.opts...)
+
+			a, err := New(lpURL, ps, opts...)
+			require.NoError(err)
+			require.NotNil(a)
+
+			mTest := mockLibParodus{
+				assert:  assert,
+				require: require,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			mTest.Listen(ctx, lpTestURL)
+
+			require.NoError(a.Start())
+			defer a.Stop()
+
+			err = mTest.Send(lpURL, wrp.Message{
+				Type:        wrp.ServiceRegistrationMessageType,
+				URL:         lpTestURL,
+				ServiceName: "test",
+			})
+			require.NoError(err)
+
+			mTest.WaitFor(ctx, authAccepted)
+			assert.Equal(tc.registered, mTest.HasReceived(authAccepted))
+		})
+	}
+}
+
+func TestNewPeerCredentials(t *testing.T) {
+	self, err := wrp.ParseDeviceID("mac:112233445566")
+	require.NoError(t, err)
+
+	ps, err := pubsub.New(self)
+	require.NoError(t, err)
+
+	_, err = New("tcp://127.0.0.1:9996", ps, AllowedUIDs(0))
+	assert.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = New("tcp://127.0.0.1:9996", ps, AllowedGIDs(0))
+	assert.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = New("tcp://127.0.0.1:9996", ps,
+		ServicePolicies(ServicePolicy{Name: "test", UIDs: []int{0}}))
+	assert.ErrorIs(t, err, ErrInvalidInput)
+
+	a, err := New("ipc:///tmp/parodus.sock", ps, AllowedUIDs(0), AllowedGIDs(0))
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+}
+
+func TestStopDuringReceive(t *testing.T) {
+	require := require.New(t)
+
+	self, err := wrp.ParseDeviceID("mac:112233445566")
+	require.NoError(err)
+
+	ps, err := pubsub.New(self)
+	require.NoError(err)
+
+	a, err := New("ipc://"+filepath.Join(t.TempDir(), "parodus.sock"), ps,
+		ReceiveTimeout(time.Minute),
+	)
+	require.NoError(err)
+	require.NoError(a.Start())
+
+	// Stopping doesn't wait for the pending receive to time out.
+	start := time.Now()
+	a.Stop()
+	require.Less(time.Since(start), 5*time.Second)
+}
+
+func TestServiceTimeout(t *testing.T) {
+	lpURL := "tcp://127.0.0.1:9989"
+	lpQuietURL := "tcp://127.0.0.1:9988"
+	lpChattyURL := "tcp://127.0.0.1:9987"
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	self, err := wrp.ParseDeviceID("mac:112233445566")
+	require.NoError(err)
+
+	ps, err := pubsub.New(self, pubsub.WithPublishTimeout(200*time.Millisecond))
+	require.NoError(err)
+
+	_, err = New(lpURL, ps, ServiceTimeout(-1))
+	assert.ErrorIs(err, ErrInvalidInput)
+
+	_, err = New(lpURL, ps, SendQueueSize(-1))
+	assert.ErrorIs(err, ErrInvalidInput)
+
+	_, err = New(lpURL, ps, SendRetries(-1, 0))
+	assert.ErrorIs(err, ErrInvalidInput)
+
+	_, err = New(lpURL, ps, WithDropPolicy("random"))
+	assert.ErrorIs(err, ErrInvalidInput)
+
+	a, err := New(lpURL, ps,
+		ReceiveTimeout(50*time.Millisecond),
+		SendTimeout(100*time.Millisecond),
+		KeepaliveInterval(50*time.Millisecond),
+		ServiceTimeout(300*time.Millisecond),
+	)
+	require.NoError(err)
+
+	mQuiet := mockLibParodus{
+		assert:  assert,
+		require: require,
+	}
+	mChatty := mockLibParodus{
+		assert:  assert,
+		require: require,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mQuiet.Listen(ctx, lpQuietURL)
+	mChatty.Listen(ctx, lpChattyURL)
+
+	require.NoError(a.Start())
+	defer a.Stop()
+
+	assert.NoError(mQuiet.Send(lpURL, wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		URL:         lpQuietURL,
+		ServiceName: "quiet",
+	}))
+	assert.NoError(mChatty.Send(lpURL, wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		URL:         lpChattyURL,
+		ServiceName: "chatty",
+	}))
+
+	// Keep the chatty service alive while the quiet one times out.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		assert.NoError(mChatty.Send(lpURL, wrp.Message{
+			Type:        wrp.ServiceAliveMessageType,
+			ServiceName: "chatty",
+		}))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	services := a.Services()
+	require.Len(services, 1)
+	assert.Equal("chatty", services[0].Name)
+	assert.True(services[0].Alive)
+}
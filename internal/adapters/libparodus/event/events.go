@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package event
+
+import (
+	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// CancelFunc is the interface that provides a method to cancel a listener.
+type CancelFunc func()
+
+// Drop is the event that is sent when a message bound for a libparodus client
+// could not be delivered and was discarded.
+type Drop struct {
+	// At holds the time when the message was dropped.
+	At time.Time
+
+	// Service is the name of the client the message was bound for.
+	Service string
+
+	// Attempts is the number of delivery attempts made before the message
+	// was dropped.  Zero means the message was never sent.
+	Attempts int
+
+	// Message is the message that was dropped.
+	Message wrp.Message
+
+	// Err is the reason the message was dropped.
+	Err error
+}
+
+// DropListener is the interface that must be implemented by types that want
+// to receive Drop notifications.
+type DropListener interface {
+	OnDrop(Drop)
+}
+
+// DropListenerFunc is a function type that implements DropListener.  It can
+// be used as an adapter for functions that need to implement the DropListener
+// interface.
+type DropListenerFunc func(Drop)
+
+func (f DropListenerFunc) OnDrop(d Drop) {
+	f(d)
+}
+
+// Registration is the event that is sent when a libparodus client attempts to
+// register a service, whether or not the registration was accepted.
+type Registration struct {
+	// At holds the time when the registration was received.
+	At time.Time
+
+	// Service is the service name the client attempted to register.
+	Service string
+
+	// URL is the callback url the client provided.
+	URL string
+
+	// UID is the user id of the client, or -1 if the peer credentials are
+	// not available.
+	UID int
+
+	// Replaced is true if the registration replaced an existing one.
+	Replaced bool
+
+	// Err is the reason the registration was rejected, or nil if it was
+	// accepted.
+	Err error
+}
+
+// RegistrationListener is the interface that must be implemented by types
+// that want to receive Registration notifications.
+type RegistrationListener interface {
+	OnRegistration(Registration)
+}
+
+// RegistrationListenerFunc is a function type that implements
+// RegistrationListener.  It can be used as an adapter for functions that need
+// to implement the RegistrationListener interface.
+type RegistrationListenerFunc func(Registration)
+
+func (f RegistrationListenerFunc) OnRegistration(r Registration) {
+	f(r)
+}
@@ -5,10 +5,13 @@ package libparodus
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
 	"go.nanomsg.org/mangos/v3"
@@ -40,12 +43,45 @@ var (
 		wrp.Msgpack)
 )
 
+// sendPolicy describes how messages are delivered to an external service.
+type sendPolicy struct {
+	// queueSize is the number of messages that may be waiting to be sent.
+	// Zero means messages are sent synchronously by HandleWrp.
+	queueSize int
+
+	// retries is the number of additional attempts made to send a message.
+	retries int
+
+	// retryDelay is the time to wait between attempts.
+	retryDelay time.Duration
+
+	// dropPolicy determines which message is dropped when the queue is full.
+	dropPolicy DropPolicy
+}
+
 // external is a struct representing a subscription to an external service.
 type external struct {
 	name              string
+	url               string
+	uid               int
 	heartbeatInterval time.Duration
+	serviceTimeout    time.Duration
+	registeredAt      time.Time
+	policy            sendPolicy
+	queue             chan wrp.Message
+	dropped           func(event.Drop)
 	terminate         func()
 
+	// qlock serializes queueing with the final drain, and stopped records
+	// that the sender has exited so later messages are dropped rather than
+	// queued with nobody left to send them.
+	qlock   sync.Mutex
+	stopped bool
+
+	// lastActivity is the time (in unix nanoseconds) the external service was
+	// last heard from.
+	lastActivity atomic.Int64
+
 	// Everything below is private to the sub
 	lock sync.Mutex
 	sock protocol.Socket
@@ -57,13 +93,25 @@ var _ wrpkit.Handler = (*external)(nil)
 // 'New()' function, this function also connects to the external service.
 func newExternal(ctx context.Context,
 	name, url string,
-	heartbeatInterval, sendTimeout time.Duration,
+	heartbeatInterval, sendTimeout, serviceTimeout time.Duration,
+	policy sendPolicy,
 	ps *pubsub.PubSub,
-	terminate func()) (*external, error) {
+	dropped func(event.Drop),
+	terminate func(*external)) (*external, error) {
 
 	ex := external{
 		name:              name,
+		url:               url,
 		heartbeatInterval: heartbeatInterval,
+		serviceTimeout:    serviceTimeout,
+		registeredAt:      time.Now(),
+		policy:            policy,
+		dropped:           dropped,
+	}
+	ex.touch()
+
+	if policy.queueSize > 0 {
+		ex.queue = make(chan wrp.Message, policy.queueSize)
 	}
 
 	ex.lock.Lock()
@@ -95,7 +143,7 @@ func newExternal(ctx context.Context,
 	err = sock.Dial(url)
 	if err != nil {
 		_ = sock.Close()
-		terminate()
+		terminate(&ex)
 		return nil, err
 	}
 
@@ -107,66 +155,230 @@ func newExternal(ctx context.Context,
 	if err != nil {
 		cancel()
 		_ = sock.Close()
-		terminate()
+		terminate(&ex)
 		return nil, err
 	}
 
+	var once sync.Once
 	ex.terminate = func() {
-		psCancel()
-		cancel()
-		_ = sock.Close()
-		terminate()
+		once.Do(func() {
+			psCancel()
+			cancel()
+			_ = sock.Close()
+			terminate(&ex)
+		})
 	}
 
 	go ex.keepalive(ctx)
 
+	if ex.queue != nil {
+		go ex.sender(ctx)
+	}
+
 	return &ex, nil
 }
 
-// HandleWrp sends a WRP message to the external service.
+// HandleWrp sends a WRP message to the external service.  If a send queue is
+// configured the message is queued and delivered asynchronously; otherwise it
+// is sent immediately.  Messages queued after the external service has been
+// unregistered are dropped and ErrNoService is returned.
 func (s *external) HandleWrp(msg wrp.Message) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	if s.queue == nil {
+		return s.send(msg)
+	}
 
-	if s.sock == nil {
-		return wrpkit.ErrNotHandled
+	s.qlock.Lock()
+	defer s.qlock.Unlock()
+
+	if s.stopped {
+		s.drop(msg, 0, ErrNoService)
+		return ErrNoService
+	}
+
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+	}
+
+	if s.policy.dropPolicy == DropNewest {
+		s.drop(msg, 0, ErrQueueFull)
+		return ErrQueueFull
+	}
+
+	// Make room by dropping the oldest message.  The sender may have made
+	// room in the meantime, so don't block if there is nothing to drop.
+	select {
+	case oldest := <-s.queue:
+		s.drop(oldest, 0, ErrQueueFull)
+	default:
 	}
 
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		s.drop(msg, 0, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// send encodes and sends a message to the external service.
+func (s *external) send(msg wrp.Message) error {
 	var buf []byte
 	if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg); err != nil {
 		return err
 	}
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sock == nil {
+		return wrpkit.ErrNotHandled
+	}
+
 	return s.sock.Send(buf)
 }
 
+// sender delivers queued messages to the external service, retrying failed
+// sends according to the send policy.  Do not call this except from
+// newExternal().
+func (s *external) sender(ctx context.Context) {
+	for {
+		var msg wrp.Message
+		select {
+		case <-ctx.Done():
+			s.drain()
+			return
+		case msg = <-s.queue:
+		}
+
+		var err error
+		attempts := 0
+		for attempts <= s.policy.retries {
+			if attempts > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(s.policy.retryDelay):
+				}
+			}
+			if ctx.Err() != nil {
+				err = ErrNoService
+				break
+			}
+
+			attempts++
+			if err = s.send(msg); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			s.drop(msg, attempts, errors.Join(ErrRetriesExhausted, err))
+		}
+	}
+}
+
+// drain drops any messages left in the queue once the external service has
+// been unregistered, and marks the sender as stopped so HandleWrp drops any
+// later messages itself.
+func (s *external) drain() {
+	s.qlock.Lock()
+	defer s.qlock.Unlock()
+
+	s.stopped = true
+	for {
+		select {
+		case msg := <-s.queue:
+			s.drop(msg, 0, ErrNoService)
+		default:
+			return
+		}
+	}
+}
+
+// drop reports a message that could not be delivered.
+func (s *external) drop(msg wrp.Message, attempts int, err error) {
+	if s.dropped == nil {
+		return
+	}
+
+	s.dropped(event.Drop{
+		At:       time.Now(),
+		Service:  s.name,
+		Attempts: attempts,
+		Message:  msg,
+		Err:      err,
+	})
+}
+
 // keepalive sends a keepalive message to the external service.  At some point
 // the external service will stop sending heartbeats, and the subscription will
-// be canceled.  Do not call this except from newExternal().
+// be canceled.  If a service timeout is configured, a service that has not
+// been heard from within the timeout is also canceled.  Do not call this except
+// from newExternal().
 func (s *external) keepalive(ctx context.Context) {
+	defer s.cancel()
+
 	s.lock.Lock()
 	err := s.sock.Send(authAcceptedMsg)
 	s.lock.Unlock()
-	if err == nil {
-		for {
-			select {
-			case <-ctx.Done(): //context canceled
-				break
-			case <-time.After(s.heartbeatInterval):
-			}
+	if err != nil {
+		return
+	}
 
-			s.lock.Lock()
-			err := s.sock.Send(serviceAliveMsg)
-			s.lock.Unlock()
+	for {
+		select {
+		case <-ctx.Done(): //context canceled
+			return
+		case <-time.After(s.heartbeatInterval):
+		}
 
-			if err != nil {
-				// The heartbeat failed.  Cancel the subscription & exit.
-				break
-			}
+		if s.expired(time.Now()) {
+			// The external service has gone silent.
+			return
+		}
+
+		s.lock.Lock()
+		err := s.sock.Send(serviceAliveMsg)
+		s.lock.Unlock()
+
+		if err != nil {
+			// The heartbeat failed.  Cancel the subscription & exit.
+			return
 		}
 	}
+}
 
-	s.cancel()
+// touch records that the external service was just heard from.
+func (s *external) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// lastSeen returns the time the external service was last heard from.
+func (s *external) lastSeen() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
+// expired returns true if a service timeout is configured and the external
+// service has not been heard from within it.
+func (s *external) expired(now time.Time) bool {
+	if s.serviceTimeout <= 0 {
+		return false
+	}
+
+	return now.Sub(s.lastSeen()) > s.serviceTimeout
+}
+
+// status returns the current status of the external service.
+func (s *external) status(now time.Time) Service {
+	return Service{
+		Name:         s.name,
+		URL:          s.url,
+		RegisteredAt: s.registeredAt,
+		LastActivity: s.lastSeen(),
+		Alive:        !s.expired(now),
+	}
 }
 
 // cancel cancels the subscription to the external service.
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package libparodus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
+	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
+)
+
+type dropRecorder struct {
+	lock  sync.Mutex
+	drops []event.Drop
+}
+
+func (d *dropRecorder) OnDrop(e event.Drop) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.drops = append(d.drops, e)
+}
+
+func (d *dropRecorder) get() []event.Drop {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]event.Drop(nil), d.drops...)
+}
+
+func TestExternalQueue(t *testing.T) {
+	first := wrp.Message{Type: wrp.SimpleEventMessageType, TransactionUUID: "1"}
+	second := wrp.Message{Type: wrp.SimpleEventMessageType, TransactionUUID: "2"}
+
+	tests := []struct {
+		description string
+		policy      DropPolicy
+		expectErr   error
+		dropped     wrp.Message
+		queued      wrp.Message
+	}{
+		{
+			description: "drop the oldest",
+			policy:      DropOldest,
+			dropped:     first,
+			queued:      second,
+		}, {
+			description: "drop the newest",
+			policy:      DropNewest,
+			expectErr:   ErrQueueFull,
+			dropped:     second,
+			queued:      first,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var rec dropRecorder
+			ex := external{
+				name: "test",
+				policy: sendPolicy{
+					queueSize:  1,
+					dropPolicy: tc.policy,
+				},
+				queue:   make(chan wrp.Message, 1),
+				dropped: rec.OnDrop,
+			}
+
+			assert.NoError(ex.HandleWrp(first))
+			assert.ErrorIs(ex.HandleWrp(second), tc.expectErr)
+
+			drops := rec.get()
+			require.Len(drops, 1)
+			assert.Equal("test", drops[0].Service)
+			assert.Equal(tc.dropped, drops[0].Message)
+			assert.Zero(drops[0].Attempts)
+			assert.ErrorIs(drops[0].Err, ErrQueueFull)
+
+			require.Len(ex.queue, 1)
+			assert.Equal(tc.queued, <-ex.queue)
+		})
+	}
+}
+
+func TestExternalSenderRetries(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var rec dropRecorder
+	ex := external{
+		name: "test",
+		policy: sendPolicy{
+			queueSize:  2,
+			retries:    2,
+			retryDelay: time.Millisecond,
+		},
+		queue:   make(chan wrp.Message, 2),
+		dropped: rec.OnDrop,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ex.sender(ctx)
+		close(done)
+	}()
+
+	// There is no socket, so every attempt fails.
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType}
+	assert.NoError(ex.HandleWrp(msg))
+
+	assert.Eventually(func() bool {
+		return len(rec.get()) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	drops := rec.get()
+	require.Len(drops, 1)
+	assert.Equal(3, drops[0].Attempts)
+	assert.Equal(msg, drops[0].Message)
+	assert.ErrorIs(drops[0].Err, ErrRetriesExhausted)
+	assert.ErrorIs(drops[0].Err, wrpkit.ErrNotHandled)
+}
+
+func TestExternalSendAfterStop(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var rec dropRecorder
+	ex := external{
+		name: "test",
+		policy: sendPolicy{
+			queueSize: 2,
+		},
+		queue:   make(chan wrp.Message, 2),
+		dropped: rec.OnDrop,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ex.sender(ctx)
+		close(done)
+	}()
+
+	cancel()
+	<-done
+
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType}
+	assert.ErrorIs(ex.HandleWrp(msg), ErrNoService)
+	assert.Empty(ex.queue)
+
+	drops := rec.get()
+	require.Len(drops, 1)
+	assert.Equal("test", drops[0].Service)
+	assert.Equal(msg, drops[0].Message)
+	assert.Zero(drops[0].Attempts)
+	assert.ErrorIs(drops[0].Err, ErrNoService)
+}
@@ -6,13 +6,19 @@ package libparodus
 import (
 	"context"
 	"errors"
+	"io/fs"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/xmidt-org/eventor"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
 	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
 	"go.nanomsg.org/mangos/v3"
 	"go.nanomsg.org/mangos/v3/protocol/pull"
+	"go.nanomsg.org/mangos/v3/transport/ipc"
 
 	// register transports
 	_ "go.nanomsg.org/mangos/v3/transport/all"
@@ -21,6 +27,31 @@ import (
 var (
 	ErrInvalidInput = errors.New("invalid input")
 	ErrNoService    = errors.New("service not found")
+	ErrUnauthorized = errors.New("peer not authorized")
+
+	ErrRegistrationDenied = errors.New("registration denied")
+	ErrServiceConflict    = errors.New("service already registered")
+
+	ErrQueueFull        = errors.New("send queue full")
+	ErrRetriesExhausted = errors.New("send retries exhausted")
+)
+
+// DropPolicy determines which message is dropped when a client's send queue
+// is full.
+type DropPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new
+	// one.  This is the default.
+	DropOldest DropPolicy = "oldest"
+
+	// DropNewest discards the new message, leaving the queue unchanged.
+	DropNewest DropPolicy = "newest"
+)
+
+const (
+	// ipcScheme is the URL scheme used for Unix domain socket transports.
+	ipcScheme = "ipc://"
 )
 
 // This package provides backwards compatibility for the libparodus library.
@@ -37,9 +68,52 @@ type Adapter struct {
 
 	parodusServiceURL string
 	keepaliveInterval time.Duration
+	serviceTimeout    time.Duration
 	recvTimeout       time.Duration
 	sendTimeout       time.Duration
+	sendPolicy        sendPolicy
 	pubsub            *pubsub.PubSub
+
+	// dropListeners are notified when a message for a client is dropped.
+	dropListeners eventor.Eventor[event.DropListener]
+
+	// registrationListeners are notified of every registration attempt.
+	registrationListeners eventor.Eventor[event.RegistrationListener]
+
+	// servicePolicies is the allow-list of services that may register.  An
+	// empty allow-list allows any service to register.
+	servicePolicies map[string]ServicePolicy
+
+	// allowReplace allows any live registration to be replaced by a client
+	// with a different url or peer.
+	allowReplace bool
+
+	// socketPerms are the permissions applied to the Unix domain socket when
+	// the service URL uses the ipc transport.  Zero leaves the default.
+	socketPerms fs.FileMode
+
+	// allowedUIDs and allowedGIDs are the peer credentials permitted to
+	// register services or publish messages.  Empty lists allow any peer.
+	allowedUIDs []int
+	allowedGIDs []int
+}
+
+// Service describes a registered libparodus client.
+type Service struct {
+	// Name is the service name the client registered with.
+	Name string `json:"name"`
+
+	// URL is the url the client registered to receive messages on.
+	URL string `json:"url"`
+
+	// RegisteredAt is when the client registered.
+	RegisteredAt time.Time `json:"registered_at"`
+
+	// LastActivity is when the client was last heard from.
+	LastActivity time.Time `json:"last_activity"`
+
+	// Alive is false if the client has gone silent past the service timeout.
+	Alive bool `json:"alive"`
 }
 
 // Option is the interface implemented by types that can be used to
@@ -61,13 +135,17 @@ func New(url string, pubsub *pubsub.PubSub, opts ...Option) (*Adapter, error) {
 	required := []Option{
 		validatePubSub(),
 		validateParodusServiceURL(),
+		validatePeerCredentials(),
 	}
 
 	a := Adapter{
 		parodusServiceURL: url,
-		pubsub:            pubsub,
-		listening:         make(chan error),
-		subServices:       make(map[string]*external),
+		sendPolicy: sendPolicy{
+			dropPolicy: DropOldest,
+		},
+		pubsub:      pubsub,
+		listening:   make(chan error),
+		subServices: make(map[string]*external),
 	}
 
 	opts = append(opts, required...)
@@ -158,12 +236,25 @@ func (a *Adapter) receive(ctx context.Context) {
 	}
 
 	// If we can't listen, we can't do anything; exit.
-	err = sock.Listen(a.parodusServiceURL)
+	err = a.listen(sock)
 	if err != nil {
+		_ = sock.Close()
 		a.listening <- err
 		return
 	}
 
+	// Close the socket when stopped so a pending receive returns right away
+	// instead of after the receive timeout.
+	stop := context.AfterFunc(ctx, func() {
+		_ = sock.Close()
+	})
+	defer func() {
+		// Only close the socket if it wasn't closed when stopped.
+		if stop() {
+			_ = sock.Close()
+		}
+	}()
+
 	// Everything is set up and ready to go.  Tell Start() that we're listening.
 	a.listening <- nil
 
@@ -172,29 +263,39 @@ func (a *Adapter) receive(ctx context.Context) {
 			return
 		}
 
-		bytes, err := sock.Recv()
+		m, err := sock.RecvMsg()
 		if errors.Is(err, mangos.ErrRecvTimeout) {
 			// Ignore read timeouts, but they are important so the routine will
 			// eventually exit.
 			continue
 		}
+		if err != nil {
+			continue
+		}
+
+		// Drop anything from a peer that is not allowed to talk to us.
+		if err = a.authorize(m.Pipe); err != nil {
+			m.Free()
+			continue
+		}
+		pipe := m.Pipe
 
 		var msg wrp.Message
-		err = wrp.NewDecoderBytes(bytes, wrp.Msgpack).Decode(&msg)
+		err = wrp.NewDecoderBytes(m.Body, wrp.Msgpack).Decode(&msg)
+		m.Free()
 		if err != nil {
 			continue
 		}
 
 		switch msg.Type {
 		case wrp.ServiceRegistrationMessageType:
-			a.register(ctx, msg)
+			_ = a.register(ctx, msg, peerUID(pipe))
+		case wrp.ServiceAliveMessageType:
+			// The client is telling us it is still alive; nothing else needs
+			// to be done with the message.
+			a.alive(msg)
 		case wrp.Invalid0MessageType,
-			wrp.Invalid1MessageType,
-			wrp.ServiceAliveMessageType:
-			// Ignore these messages; they should really not be sent to the
-			// adapter.  The reason being is the client of this adapter can
-			// determine if a service is alive by the presence of the keepalive
-			// messages this adapter sends.
+			wrp.Invalid1MessageType:
 			// Simply drop the invalid ones.
 			continue
 		default:
@@ -203,18 +304,149 @@ func (a *Adapter) receive(ctx context.Context) {
 	}
 }
 
-func (a *Adapter) register(ctx context.Context, msg wrp.Message) error {
+// Services returns the status of the registered libparodus clients, sorted
+// by service name.
+func (a *Adapter) Services() []Service {
+	now := time.Now()
+
+	a.lock.Lock()
+	list := make([]Service, 0, len(a.subServices))
+	for _, ext := range a.subServices {
+		list = append(list, ext.status(now))
+	}
+	a.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// listen starts listening on the service URL.  If the URL is for a Unix domain
+// socket, the socket permissions are applied before the listener starts.
+func (a *Adapter) listen(sock mangos.Socket) error {
+	l, err := sock.NewListener(a.parodusServiceURL, nil)
+	if err != nil {
+		return err
+	}
+
+	if isIPC(a.parodusServiceURL) && a.socketPerms != 0 {
+		err = l.SetOption(ipc.OptionIpcSocketPermissions, uint32(a.socketPerms.Perm()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.Listen()
+}
+
+// authorize checks the peer credentials of the pipe a message arrived on
+// against the configured allow-lists.  Peer credentials are only available
+// for Unix domain sockets, so any allow-list rejects peers without them.
+func (a *Adapter) authorize(p mangos.Pipe) error {
+	if len(a.allowedUIDs) == 0 && len(a.allowedGIDs) == 0 {
+		return nil
+	}
+
+	if p == nil {
+		return ErrUnauthorized
+	}
+
+	if len(a.allowedUIDs) > 0 && !peerIn(p, mangos.OptionPeerUID, a.allowedUIDs) {
+		return ErrUnauthorized
+	}
+
+	if len(a.allowedGIDs) > 0 && !peerIn(p, mangos.OptionPeerGID, a.allowedGIDs) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// peerIn returns true if the named peer credential option of the pipe is
+// present and is one of the allowed values.
+func peerIn(p mangos.Pipe, option string, allowed []int) bool {
+	id, ok := peerID(p, option)
+	if !ok {
+		return false
+	}
+
+	for _, want := range allowed {
+		if id == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerID returns the named peer credential option of the pipe, if present.
+func peerID(p mangos.Pipe, option string) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	v, err := p.GetOption(option)
+	if err != nil {
+		return 0, false
+	}
+
+	id, ok := v.(int)
+	return id, ok
+}
+
+// peerUID returns the user id of the peer, or unknownUID if it is not
+// available.
+func peerUID(p mangos.Pipe) int {
+	if uid, ok := peerID(p, mangos.OptionPeerUID); ok {
+		return uid
+	}
+	return unknownUID
+}
+
+func isIPC(url string) bool {
+	return strings.HasPrefix(url, ipcScheme)
+}
+
+// register handles a service registration from the client with the given uid.
+// Registrations that are not permitted by the allow-list, or that conflict
+// with a live registration are rejected.  Every decision is reported to the
+// registration listeners.
+func (a *Adapter) register(ctx context.Context, msg wrp.Message, uid int) error {
 	name := msg.ServiceName
+	now := time.Now()
+
+	a.lock.Lock()
+	prev := a.subServices[name]
+	a.lock.Unlock()
+
+	replace, err := a.admit(name, msg.URL, uid, prev, now)
+	a.dispatchRegistration(event.Registration{
+		At:       now,
+		Service:  name,
+		URL:      msg.URL,
+		UID:      uid,
+		Replaced: replace && err == nil,
+		Err:      err,
+	})
+	if err != nil {
+		return err
+	}
 
 	ext, err := newExternal(ctx, name, msg.URL,
 		a.keepaliveInterval,
 		a.sendTimeout,
+		a.serviceTimeout,
+		a.sendPolicy,
 		a.pubsub,
-		func() {
+		a.dispatchDrop,
+		func(ext *external) {
 			a.lock.Lock()
 			defer a.lock.Unlock()
-			tmp := a.subServices[name]
-			if tmp == nil {
+
+			// Only remove the registration if it has not been replaced.
+			if a.subServices[name] != ext {
 				return
 			}
 
@@ -225,8 +457,8 @@ func (a *Adapter) register(ctx context.Context, msg wrp.Message) error {
 	if err != nil {
 		return err
 	}
+	ext.uid = uid
 
-	prev := a.subServices[name]
 	if prev != nil {
 		prev.cancel()
 	}
@@ -238,16 +470,67 @@ func (a *Adapter) register(ctx context.Context, msg wrp.Message) error {
 	return nil
 }
 
+// AddDropListener adds a listener that is called whenever a message bound for
+// a client is dropped.
+func (a *Adapter) AddDropListener(listener event.DropListener) event.CancelFunc {
+	return event.CancelFunc(a.dropListeners.Add(listener))
+}
+
+func (a *Adapter) dispatchDrop(d event.Drop) {
+	a.dropListeners.Visit(func(l event.DropListener) {
+		l.OnDrop(d)
+	})
+}
+
+// AddRegistrationListener adds a listener that is called for every service
+// registration attempt, whether it was accepted or rejected.
+func (a *Adapter) AddRegistrationListener(listener event.RegistrationListener) event.CancelFunc {
+	return event.CancelFunc(a.registrationListeners.Add(listener))
+}
+
+func (a *Adapter) dispatchRegistration(r event.Registration) {
+	a.registrationListeners.Visit(func(l event.RegistrationListener) {
+		l.OnRegistration(r)
+	})
+}
+
+// alive records activity for the client that sent a service alive message.
+// The client is identified by the service name, or the service of the source
+// if no service name is present.
+func (a *Adapter) alive(msg wrp.Message) {
+	name := msg.ServiceName
+	if name == "" {
+		src, err := wrp.ParseLocator(msg.Source)
+		if err != nil {
+			return
+		}
+		name = src.Service
+	}
+
+	_ = a.sender(name)
+}
+
+// sender returns the registered external for the named service after
+// recording that it was just heard from, or nil if it is not registered.
+func (a *Adapter) sender(name string) *external {
+	a.lock.Lock()
+	sc := a.subServices[name]
+	a.lock.Unlock()
+
+	if sc != nil {
+		sc.touch()
+	}
+
+	return sc
+}
+
 func (a *Adapter) forward(msg wrp.Message) error {
 	src, err := wrp.ParseLocator(msg.Source)
 	if err != nil {
 		return err
 	}
 
-	a.lock.Lock()
-	sc := a.subServices[src.Service]
-	a.lock.Unlock()
-
+	sc := a.sender(src.Service)
 	if sc == nil {
 		return ErrNoService
 	}
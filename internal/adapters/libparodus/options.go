@@ -5,7 +5,11 @@ package libparodus
 
 import (
 	"fmt"
+	"io/fs"
+	"path"
 	"time"
+
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
 )
 
 func KeepaliveInterval(timeout time.Duration) Option {
@@ -15,6 +19,18 @@ func KeepaliveInterval(timeout time.Duration) Option {
 	})
 }
 
+// ServiceTimeout sets how long a registered client may go without sending
+// anything before it is unregistered.  Zero (the default) disables the timeout.
+func ServiceTimeout(timeout time.Duration) Option {
+	return optionFunc(func(s *Adapter) error {
+		if timeout < 0 {
+			return fmt.Errorf("%w: negative service timeout", ErrInvalidInput)
+		}
+		s.serviceTimeout = timeout
+		return nil
+	})
+}
+
 func ReceiveTimeout(timeout time.Duration) Option {
 	return optionFunc(func(s *Adapter) error {
 		s.recvTimeout = timeout
@@ -29,6 +45,144 @@ func SendTimeout(timeout time.Duration) Option {
 	})
 }
 
+// SendQueueSize sets the number of messages that may be queued for each
+// client while waiting to be sent.  Zero (the default) disables queueing and
+// messages are sent synchronously.
+func SendQueueSize(size int) Option {
+	return optionFunc(func(s *Adapter) error {
+		if size < 0 {
+			return fmt.Errorf("%w: negative send queue size", ErrInvalidInput)
+		}
+		s.sendPolicy.queueSize = size
+		return nil
+	})
+}
+
+// SendRetries sets the number of additional attempts made to deliver a queued
+// message, and the delay between attempts.
+func SendRetries(retries int, delay time.Duration) Option {
+	return optionFunc(func(s *Adapter) error {
+		if retries < 0 || delay < 0 {
+			return fmt.Errorf("%w: negative send retries or delay", ErrInvalidInput)
+		}
+		s.sendPolicy.retries = retries
+		s.sendPolicy.retryDelay = delay
+		return nil
+	})
+}
+
+// WithDropPolicy sets which message is dropped when a client's send queue is
+// full.  An empty policy uses the default, DropOldest.
+func WithDropPolicy(policy DropPolicy) Option {
+	return optionFunc(func(s *Adapter) error {
+		switch policy {
+		case "":
+			policy = DropOldest
+		case DropOldest, DropNewest:
+		default:
+			return fmt.Errorf("%w: unknown drop policy '%s'", ErrInvalidInput, policy)
+		}
+		s.sendPolicy.dropPolicy = policy
+		return nil
+	})
+}
+
+// AddDropListener adds a listener that is called whenever a message bound for
+// a client is dropped.  If the optional cancel parameter is provided, it is set
+// to a function that can be used to cancel the listener.
+func AddDropListener(listener event.DropListener, cancel ...*event.CancelFunc) Option {
+	return optionFunc(func(s *Adapter) error {
+		var ignored event.CancelFunc
+		cancel = append(cancel, &ignored)
+		*cancel[0] = s.AddDropListener(listener)
+		return nil
+	})
+}
+
+// AddRegistrationListener adds a listener that is called for every service
+// registration attempt.  If the optional cancel parameter is provided, it is
+// set to a function that can be used to cancel the listener.
+func AddRegistrationListener(listener event.RegistrationListener, cancel ...*event.CancelFunc) Option {
+	return optionFunc(func(s *Adapter) error {
+		var ignored event.CancelFunc
+		cancel = append(cancel, &ignored)
+		*cancel[0] = s.AddRegistrationListener(listener)
+		return nil
+	})
+}
+
+// ServicePolicies sets the allow-list of services that may register.  Once
+// any policy is provided, registrations for services without a policy are
+// rejected.
+func ServicePolicies(policies ...ServicePolicy) Option {
+	return optionFunc(func(s *Adapter) error {
+		if s.servicePolicies == nil {
+			s.servicePolicies = make(map[string]ServicePolicy, len(policies))
+		}
+
+		for _, p := range policies {
+			if p.Name == "" {
+				return fmt.Errorf("%w: service policy name is required", ErrInvalidInput)
+			}
+			if _, found := s.servicePolicies[p.Name]; found {
+				return fmt.Errorf("%w: duplicate service policy '%s'", ErrInvalidInput, p.Name)
+			}
+			for _, pattern := range p.URLs {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("%w: invalid url pattern '%s' for service '%s'",
+						ErrInvalidInput, pattern, p.Name)
+				}
+			}
+			s.servicePolicies[p.Name] = p
+		}
+		return nil
+	})
+}
+
+// AllowServiceReplacement allows a live registration to be replaced by a
+// client registering the same service from a different url or peer.  By
+// default such conflicting registrations are rejected, except that a client
+// with the same known peer uid may re-register from a new url after it
+// restarts.
+func AllowServiceReplacement(allow bool) Option {
+	return optionFunc(func(s *Adapter) error {
+		s.allowReplace = allow
+		return nil
+	})
+}
+
+// SocketPermissions sets the file permissions of the Unix domain socket
+// created when the service URL uses the ipc:// transport.  It has no effect
+// for other transports.
+func SocketPermissions(perm fs.FileMode) Option {
+	return optionFunc(func(s *Adapter) error {
+		s.socketPerms = perm
+		return nil
+	})
+}
+
+// AllowedUIDs limits the processes that may register services or publish
+// messages to those running as one of the listed user ids.  The check uses
+// the peer credentials (SO_PEERCRED) of the Unix domain socket, so it
+// requires the ipc:// transport.
+func AllowedUIDs(uids ...int) Option {
+	return optionFunc(func(s *Adapter) error {
+		s.allowedUIDs = append(s.allowedUIDs, uids...)
+		return nil
+	})
+}
+
+// AllowedGIDs limits the processes that may register services or publish
+// messages to those running as one of the listed group ids.  The check uses
+// the peer credentials (SO_PEERCRED) of the Unix domain socket, so it
+// requires the ipc:// transport.
+func AllowedGIDs(gids ...int) Option {
+	return optionFunc(func(s *Adapter) error {
+		s.allowedGIDs = append(s.allowedGIDs, gids...)
+		return nil
+	})
+}
+
 // -- Only Validators Below ----------------------------------------------------
 
 func validatePubSub() Option {
@@ -50,3 +204,23 @@ func validateParodusServiceURL() Option {
 		return nil
 	})
 }
+
+func validatePeerCredentials() Option {
+	return optionFunc(func(s *Adapter) error {
+		needed := len(s.allowedUIDs) > 0 || len(s.allowedGIDs) > 0
+		for _, p := range s.servicePolicies {
+			needed = needed || len(p.UIDs) > 0
+		}
+
+		if !needed {
+			return nil
+		}
+
+		if !isIPC(s.parodusServiceURL) {
+			return fmt.Errorf("%w: peer credential checks require an %s service url",
+				ErrInvalidInput, ipcScheme)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package libparodus
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// ServicePolicy restricts which clients may register a service name.
+type ServicePolicy struct {
+	// Name is the service name the policy applies to.
+	Name string
+
+	// URLs are the callback url patterns the service may register with.  The
+	// patterns use path.Match syntax, so "tcp://127.0.0.1:*" allows any port
+	// on the loopback address.  An empty list allows any url.
+	URLs []string
+
+	// UIDs are the peer user ids that may register the service.  Peer
+	// credentials are only available with the ipc:// transport.  An empty
+	// list allows any peer.
+	UIDs []int
+
+	// AllowReplace allows a registration to replace a live registration of
+	// the same service from a different url or peer.
+	AllowReplace bool
+}
+
+// unknownUID is used when the peer credentials of a client are not available.
+const unknownUID = -1
+
+// permits returns nil if the policy allows the url and uid to register.
+func (p ServicePolicy) permits(url string, uid int) error {
+	if len(p.URLs) > 0 {
+		var found bool
+		for _, pattern := range p.URLs {
+			if ok, _ := path.Match(pattern, url); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: url '%s' is not allowed for service '%s'",
+				ErrRegistrationDenied, url, p.Name)
+		}
+	}
+
+	if len(p.UIDs) > 0 {
+		var found bool
+		for _, want := range p.UIDs {
+			if uid != unknownUID && uid == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: uid %d is not allowed for service '%s'",
+				ErrRegistrationDenied, uid, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// admit decides if a registration for the named service may proceed.  The
+// prev parameter is the current registration for the service, if any.  The
+// returned bool is true if prev is being replaced.
+func (a *Adapter) admit(name, url string, uid int, prev *external, now time.Time) (bool, error) {
+	if name == "" {
+		return false, fmt.Errorf("%w: service name is required", ErrRegistrationDenied)
+	}
+
+	allowReplace := a.allowReplace
+	if len(a.servicePolicies) > 0 {
+		policy, found := a.servicePolicies[name]
+		if !found {
+			return false, fmt.Errorf("%w: service '%s' is not allowed",
+				ErrRegistrationDenied, name)
+		}
+
+		if err := policy.permits(url, uid); err != nil {
+			return false, err
+		}
+
+		allowReplace = allowReplace || policy.AllowReplace
+	}
+
+	if prev == nil {
+		return false, nil
+	}
+
+	// A client re-registering from the same url and peer, or taking over
+	// from one that has gone silent is not a conflict.
+	if prev.expired(now) || (prev.url == url && prev.uid == uid) {
+		return true, nil
+	}
+
+	// The same known peer registering from a new url is a client that has
+	// restarted, so it may replace its own registration.
+	if uid != unknownUID && prev.uid == uid {
+		return true, nil
+	}
+
+	if !allowReplace {
+		return false, fmt.Errorf("%w: service '%s' is registered by another client",
+			ErrServiceConflict, name)
+	}
+
+	return true, nil
+}
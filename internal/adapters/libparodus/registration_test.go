@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package libparodus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus/event"
+	"github.com/xmidt-org/xmidt-agent/internal/pubsub"
+)
+
+func TestAdmit(t *testing.T) {
+	now := time.Now()
+
+	live := func(url string, uid int) *external {
+		ext := external{url: url, uid: uid}
+		ext.touch()
+		return &ext
+	}
+
+	silent := func(url string, uid int) *external {
+		ext := external{url: url, uid: uid, serviceTimeout: time.Second}
+		ext.lastActivity.Store(now.Add(-time.Minute).UnixNano())
+		return &ext
+	}
+
+	tests := []struct {
+		description string
+		opts        []Option
+		name        string
+		url         string
+		uid         int
+		prev        *external
+		replace     bool
+		expectedErr error
+	}{
+		{
+			description: "new service, no allow-list",
+			name:        "config",
+			url:         "tcp://127.0.0.1:6667",
+			uid:         unknownUID,
+		}, {
+			description: "missing service name",
+			url:         "tcp://127.0.0.1:6667",
+			uid:         unknownUID,
+			expectedErr: ErrRegistrationDenied,
+		}, {
+			description: "re-registration from the same client",
+			name:        "config",
+			url:         "tcp://127.0.0.1:6667",
+			uid:         10,
+			prev:        live("tcp://127.0.0.1:6667", 10),
+			replace:     true,
+		}, {
+			description: "restart of the same peer on a new url",
+			name:        "config",
+			url:         "ipc:///tmp/config-2.sock",
+			uid:         10,
+			prev:        live("ipc:///tmp/config-1.sock", 10),
+			replace:     true,
+		}, {
+			description: "conflicting url",
+			name:        "config",
+			url:         "tcp://127.0.0.1:6668",
+			uid:         unknownUID,
+			prev:        live("tcp://127.0.0.1:6667", unknownUID),
+			expectedErr: ErrServiceConflict,
+		}, {
+			description: "conflicting uid",
+			name:        "config",
+			url:         "tcp://127.0.0.1:6667",
+			uid:         11,
+			prev:        live("tcp://127.0.0.1:6667", 10),
+			expectedErr: ErrServiceConflict,
+		}, {
+			description: "conflict with a silent client",
+			name:        "config",
+			url:         "tcp://127.0.0.1:6668",
+			uid:         unknownUID,
+			prev:        silent("tcp://127.0.0.1:6667", unknownUID),
+			replace:     true,
+		}, {
+			description: "conflict allowed globally",
+			opts:        []Option{AllowServiceReplacement(true)},
+			name:        "config",
+			url:         "tcp://127.0.0.1:6668",
+			uid:         unknownUID,
+			prev:        live("tcp://127.0.0.1:6667", unknownUID),
+			replace:     true,
+		}, {
+			description: "conflict allowed by policy",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{Name: "config", AllowReplace: true}),
+			},
+			name:    "config",
+			url:     "tcp://127.0.0.1:6668",
+			uid:     unknownUID,
+			prev:    live("tcp://127.0.0.1:6667", unknownUID),
+			replace: true,
+		}, {
+			description: "service not in the allow-list",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{Name: "config"}),
+			},
+			name:        "other",
+			url:         "tcp://127.0.0.1:6667",
+			uid:         unknownUID,
+			expectedErr: ErrRegistrationDenied,
+		}, {
+			description: "url matches a pattern",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{
+					Name: "config",
+					URLs: []string{"tcp://127.0.0.1:*"},
+				}),
+			},
+			name: "config",
+			url:  "tcp://127.0.0.1:6667",
+			uid:  unknownUID,
+		}, {
+			description: "url does not match a pattern",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{
+					Name: "config",
+					URLs: []string{"tcp://127.0.0.1:*"},
+				}),
+			},
+			name:        "config",
+			url:         "tcp://10.0.0.1:6667",
+			uid:         unknownUID,
+			expectedErr: ErrRegistrationDenied,
+		}, {
+			description: "uid allowed",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{Name: "config", UIDs: []int{10}}),
+			},
+			name: "config",
+			url:  "ipc:///tmp/config.sock",
+			uid:  10,
+		}, {
+			description: "uid not allowed",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{Name: "config", UIDs: []int{10}}),
+			},
+			name:        "config",
+			url:         "ipc:///tmp/config.sock",
+			uid:         11,
+			expectedErr: ErrRegistrationDenied,
+		}, {
+			description: "uid unknown",
+			opts: []Option{
+				ServicePolicies(ServicePolicy{Name: "config", UIDs: []int{10}}),
+			},
+			name:        "config",
+			url:         "ipc:///tmp/config.sock",
+			uid:         unknownUID,
+			expectedErr: ErrRegistrationDenied,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			self, err := wrp.ParseDeviceID("mac:112233445566")
+			require.NoError(err)
+
+			ps, err := pubsub.New(self)
+			require.NoError(err)
+
+			a, err := New("ipc:///tmp/parodus.sock", ps, tc.opts...)
+			require.NoError(err)
+
+			replace, err := a.admit(tc.name, tc.url, tc.uid, tc.prev, now)
+			assert.ErrorIs(err, tc.expectedErr)
+			assert.Equal(tc.replace, replace)
+		})
+	}
+}
+
+func TestServicePoliciesInvalid(t *testing.T) {
+	self, err := wrp.ParseDeviceID("mac:112233445566")
+	require.NoError(t, err)
+
+	ps, err := pubsub.New(self)
+	require.NoError(t, err)
+
+	tests := []struct {
+		description string
+		policies    []ServicePolicy
+	}{
+		{
+			description: "missing name",
+			policies:    []ServicePolicy{{}},
+		}, {
+			description: "duplicate name",
+			policies:    []ServicePolicy{{Name: "config"}, {Name: "config"}},
+		}, {
+			description: "invalid pattern",
+			policies:    []ServicePolicy{{Name: "config", URLs: []string{"tcp://["}}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := New("tcp://127.0.0.1:9996", ps, ServicePolicies(tc.policies...))
+			assert.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
+
+func TestRegistrationEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	self, err := wrp.ParseDeviceID("mac:112233445566")
+	require.NoError(err)
+
+	ps, err := pubsub.New(self)
+	require.NoError(err)
+
+	var got []event.Registration
+	a, err := New("tcp://127.0.0.1:9996", ps,
+		ServicePolicies(ServicePolicy{Name: "config"}),
+		AddRegistrationListener(event.RegistrationListenerFunc(
+			func(r event.Registration) {
+				got = append(got, r)
+			})),
+	)
+	require.NoError(err)
+
+	err = a.register(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "other",
+		URL:         "tcp://127.0.0.1:9995",
+	}, unknownUID)
+	assert.ErrorIs(err, ErrRegistrationDenied)
+
+	require.Len(got, 1)
+	assert.Equal("other", got[0].Service)
+	assert.Equal("tcp://127.0.0.1:9995", got[0].URL)
+	assert.Equal(unknownUID, got[0].UID)
+	assert.False(got[0].Replaced)
+	assert.ErrorIs(got[0].Err, ErrRegistrationDenied)
+	assert.Empty(a.Services())
+}
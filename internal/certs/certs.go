@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+// Package certs keeps client certificates loaded from files up to date, so
+// renewed certificates are used for new connections without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/arrange/arrangetls"
+	"github.com/xmidt-org/eventor"
+	"github.com/xmidt-org/xmidt-agent/internal/certs/event"
+)
+
+var (
+	ErrInvalidInput   = errors.New("invalid input")
+	ErrNoCertificates = errors.New("no certificates")
+)
+
+// defaultCheckInterval is how often the files are checked for changes.
+const defaultCheckInterval = time.Minute
+
+// Reloader loads client certificates from files and reloads them when the
+// files change or when asked to.  Connections that are already established
+// keep the certificate they were made with.
+type Reloader struct {
+	files         arrangetls.ExternalCertificates
+	checkInterval time.Duration
+	nowFunc       func() time.Time
+	listeners     eventor.Eventor[event.ReloadListener]
+
+	lock   sync.RWMutex
+	certs  []tls.Certificate
+	stamps []stamp
+
+	m        sync.Mutex
+	wg       sync.WaitGroup
+	shutdown context.CancelFunc
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Option is the interface implemented by types that can be used to
+// configure the reloader.
+type Option interface {
+	apply(*Reloader) error
+}
+
+type optionFunc func(*Reloader) error
+
+func (f optionFunc) apply(r *Reloader) error {
+	return f(r)
+}
+
+// New creates a new Reloader and loads the certificates.
+func New(opts ...Option) (*Reloader, error) {
+	r := Reloader{
+		checkInterval: defaultCheckInterval,
+		nowFunc:       time.Now,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(r.files) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Start starts checking the files for changes.
+func (r *Reloader) Start() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.shutdown != nil || r.checkInterval <= 0 {
+		return
+	}
+
+	var ctx context.Context
+	ctx, r.shutdown = context.WithCancel(context.Background())
+
+	r.wg.Add(1)
+	go r.run(ctx)
+}
+
+// Stop stops checking the files for changes.
+func (r *Reloader) Stop() {
+	r.m.Lock()
+	shutdown := r.shutdown
+	r.shutdown = nil
+	r.m.Unlock()
+
+	if shutdown != nil {
+		shutdown()
+	}
+	r.wg.Wait()
+}
+
+func (r *Reloader) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.changed() {
+				_ = r.Reload()
+			}
+		}
+	}
+}
+
+// changed returns true if any of the files have changed since they were
+// last loaded.
+func (r *Reloader) changed() bool {
+	stamps := r.stat()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return !slices.Equal(stamps, r.stamps)
+}
+
+func (r *Reloader) stat() []stamp {
+	stamps := make([]stamp, 0, 2*len(r.files))
+	for _, f := range r.files {
+		for _, name := range []string{f.CertificateFile, f.KeyFile} {
+			var s stamp
+			if fi, err := os.Stat(name); err == nil {
+				s = stamp{modTime: fi.ModTime(), size: fi.Size()}
+			}
+			stamps = append(stamps, s)
+		}
+	}
+	return stamps
+}
+
+// Reload loads the certificates from the files.  If any of them can't be
+// loaded the previous certificates stay in use and the error is returned.
+func (r *Reloader) Reload() error {
+	stamps := r.stat()
+
+	e := event.Reload{
+		At: r.nowFunc(),
+	}
+
+	certs, err := r.files.AppendTo(nil)
+	if err == nil {
+		for i := range certs {
+			if certs[i].Leaf == nil {
+				certs[i].Leaf, err = x509.ParseCertificate(certs[i].Certificate[0])
+				if err != nil {
+					break
+				}
+			}
+			if e.NotAfter.IsZero() || certs[i].Leaf.NotAfter.Before(e.NotAfter) {
+				e.NotAfter = certs[i].Leaf.NotAfter
+			}
+		}
+	}
+
+	if err != nil {
+		e.NotAfter = time.Time{}
+		e.Err = err
+	} else {
+		for _, f := range r.files {
+			e.Files = append(e.Files, f.CertificateFile)
+		}
+
+		r.lock.Lock()
+		r.certs = certs
+		r.stamps = stamps
+		r.lock.Unlock()
+	}
+
+	r.listeners.Visit(func(l event.ReloadListener) {
+		l.OnReload(e)
+	})
+
+	return err
+}
+
+// GetClientCertificate returns the certificate to present to a server.  It
+// is used as the tls.Config GetClientCertificate function.
+func (r *Reloader) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for i := range r.certs {
+		if cri == nil || cri.SupportsCertificate(&r.certs[i]) == nil {
+			return &r.certs[i], nil
+		}
+	}
+
+	if len(r.certs) > 0 {
+		return &r.certs[0], nil
+	}
+
+	// Sending no certificate lets the server decide what to do.
+	return &tls.Certificate{}, nil
+}
+
+// ConfigureTLS returns a copy of the tls.Config that gets its client
+// certificates from the reloader.  A nil config is treated as an empty one.
+func (r *Reloader) ConfigureTLS(tc *tls.Config) *tls.Config {
+	if tc == nil {
+		tc = new(tls.Config)
+	} else {
+		tc = tc.Clone()
+	}
+
+	tc.Certificates = nil
+	tc.GetClientCertificate = r.GetClientCertificate
+	return tc
+}
+
+// AddReloadListener adds a listener that is called after each reload.
+func (r *Reloader) AddReloadListener(listener event.ReloadListener) event.CancelFunc {
+	return event.CancelFunc(r.listeners.Add(listener))
+}
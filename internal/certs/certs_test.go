@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/arrange/arrangetls"
+	"github.com/xmidt-org/xmidt-agent/internal/certs/event"
+)
+
+// writeCert writes a self signed certificate with the common name to the
+// files.
+func writeCert(t *testing.T, files arrangetls.ExternalCertificate, cn string, notAfter time.Time) {
+	t.Helper()
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+
+	require.NoError(os.WriteFile(files.CertificateFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(os.WriteFile(files.KeyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+
+	cert, err := r.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	good := arrangetls.ExternalCertificate{
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+	}
+	writeCert(t, good, "first", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		description string
+		opts        []Option
+		expectedErr error
+	}{
+		{
+			description: "valid",
+			opts:        []Option{Files(good)},
+		}, {
+			description: "no files",
+			expectedErr: ErrNoCertificates,
+		}, {
+			description: "missing key file",
+			opts: []Option{Files(arrangetls.ExternalCertificate{
+				CertificateFile: good.CertificateFile,
+			})},
+			expectedErr: ErrInvalidInput,
+		}, {
+			description: "negative check interval",
+			opts:        []Option{Files(good), CheckInterval(-1)},
+			expectedErr: ErrInvalidInput,
+		}, {
+			description: "nil now func",
+			opts:        []Option{Files(good), NowFunc(nil)},
+			expectedErr: ErrInvalidInput,
+		}, {
+			description: "files that do not exist",
+			opts: []Option{Files(arrangetls.ExternalCertificate{
+				CertificateFile: filepath.Join(dir, "missing.pem"),
+				KeyFile:         filepath.Join(dir, "missing.key"),
+			})},
+			expectedErr: os.ErrNotExist,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			r, err := New(tc.opts...)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Nil(t, r)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, r)
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	files := arrangetls.ExternalCertificate{
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+	}
+	firstExpires := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeCert(t, files, "first", firstExpires)
+
+	var reloads []event.Reload
+	r, err := New(
+		Files(files),
+		CheckInterval(0),
+		AddReloadListener(event.ReloadListenerFunc(func(e event.Reload) {
+			reloads = append(reloads, e)
+		})),
+	)
+	require.NoError(err)
+	assert.Equal("first", commonName(t, r))
+	require.Len(reloads, 1)
+	assert.NoError(reloads[0].Err)
+	assert.Equal([]string{files.CertificateFile}, reloads[0].Files)
+	assert.True(firstExpires.Equal(reloads[0].NotAfter))
+
+	// A key that doesn't match keeps the previous certificate.
+	require.NoError(os.WriteFile(files.KeyFile, []byte("not a key"), 0600))
+	assert.True(r.changed())
+	assert.Error(r.Reload())
+	assert.Equal("first", commonName(t, r))
+	require.Len(reloads, 2)
+	assert.Error(reloads[1].Err)
+
+	writeCert(t, files, "second", time.Now().Add(2*time.Hour))
+	assert.True(r.changed())
+	assert.NoError(r.Reload())
+	assert.False(r.changed())
+	assert.Equal("second", commonName(t, r))
+}
+
+func TestReloadOnChange(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	files := arrangetls.ExternalCertificate{
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+	}
+	writeCert(t, files, "first", time.Now().Add(time.Hour))
+
+	r, err := New(Files(files), CheckInterval(10*time.Millisecond))
+	require.NoError(err)
+
+	r.Start()
+	defer r.Stop()
+
+	writeCert(t, files, "second", time.Now().Add(time.Hour))
+
+	assert.Eventually(t, func() bool {
+		return commonName(t, r) == "second"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfigureTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	files := arrangetls.ExternalCertificate{
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+	}
+	writeCert(t, files, "first", time.Now().Add(time.Hour))
+
+	r, err := New(Files(files))
+	require.NoError(t, err)
+
+	orig := &tls.Config{
+		ServerName:   "example.com",
+		Certificates: []tls.Certificate{{}},
+	}
+	got := r.ConfigureTLS(orig)
+	assert.Equal("example.com", got.ServerName)
+	assert.Empty(got.Certificates)
+	assert.NotNil(got.GetClientCertificate)
+	assert.Len(orig.Certificates, 1, "the original config is not changed")
+
+	got = r.ConfigureTLS(nil)
+	assert.NotNil(got.GetClientCertificate)
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package event
+
+import "time"
+
+// CancelFunc is the interface that provides a method to cancel a listener.
+type CancelFunc func()
+
+// Reload is the event that is sent when the certificates are reloaded, or an
+// attempt to reload them failed.
+type Reload struct {
+	// At holds the time when the reload happened.
+	At time.Time
+
+	// Files are the certificate files that were loaded.
+	Files []string
+
+	// NotAfter is the earliest expiration of the loaded certificates.
+	NotAfter time.Time
+
+	// Err is the error that prevented the reload.  The previously loaded
+	// certificates stay in use.
+	Err error
+}
+
+// ReloadListener is the interface that must be implemented by types that want
+// to receive Reload notifications.
+type ReloadListener interface {
+	OnReload(Reload)
+}
+
+// ReloadListenerFunc is a function type that implements ReloadListener.  It
+// can be used as an adapter for functions that need to implement the
+// ReloadListener interface.
+type ReloadListenerFunc func(Reload)
+
+func (f ReloadListenerFunc) OnReload(r Reload) {
+	f(r)
+}
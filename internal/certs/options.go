@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package certs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xmidt-org/arrange/arrangetls"
+	"github.com/xmidt-org/xmidt-agent/internal/certs/event"
+)
+
+// Files sets the certificate and key files to load.  At least one pair is
+// required.
+func Files(files ...arrangetls.ExternalCertificate) Option {
+	return optionFunc(
+		func(r *Reloader) error {
+			for _, f := range files {
+				if f.CertificateFile == "" || f.KeyFile == "" {
+					return fmt.Errorf("%w: a certificate and key file are required", ErrInvalidInput)
+				}
+			}
+
+			r.files = append(r.files, files...)
+			return nil
+		})
+}
+
+// CheckInterval sets how often the files are checked for changes.  A value of
+// zero disables checking, so the certificates are only reloaded by Reload().
+// The default is 1 minute.
+func CheckInterval(d time.Duration) Option {
+	return optionFunc(
+		func(r *Reloader) error {
+			if d < 0 {
+				return fmt.Errorf("%w: negative CheckInterval", ErrInvalidInput)
+			}
+			r.checkInterval = d
+			return nil
+		})
+}
+
+// NowFunc sets the function used to get the current time.
+func NowFunc(f func() time.Time) Option {
+	return optionFunc(
+		func(r *Reloader) error {
+			if f == nil {
+				return fmt.Errorf("%w: nil NowFunc", ErrInvalidInput)
+			}
+			r.nowFunc = f
+			return nil
+		})
+}
+
+// AddReloadListener adds a listener that is called after each reload,
+// including the initial load.
+func AddReloadListener(listener event.ReloadListener, cancel ...*event.CancelFunc) Option {
+	return optionFunc(
+		func(r *Reloader) error {
+			var ignored event.CancelFunc
+			cancel = append(cancel, &ignored)
+			*cancel[0] = event.CancelFunc(r.listeners.Add(listener))
+			return nil
+		})
+}
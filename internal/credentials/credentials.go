@@ -7,16 +7,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	iofs "io/fs"
 	"net/http"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/ugorji/go/codec"
 	"github.com/xmidt-org/eventor"
+	"github.com/xmidt-org/retry"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
 	"github.com/xmidt-org/xmidt-agent/internal/fs"
@@ -27,14 +26,27 @@ var (
 	ErrNilRequest        = fmt.Errorf("nil request")
 	ErrNoToken           = fmt.Errorf("no token")
 	ErrTokenExpired      = fmt.Errorf("token expired")
+	ErrTokenNotYetValid  = fmt.Errorf("token not yet valid")
+	ErrInvalidToken      = fmt.Errorf("invalid token")
 	ErrFetchNotAttempted = fmt.Errorf("fetch not attempted")
 	ErrFetchFailed       = fmt.Errorf("fetch failed")
+	ErrUnexpectedStatus  = fmt.Errorf("unexpected status code")
 )
 
 const (
 	DefaultRefetchPercent = 90.0
 )
 
+// DefaultRetryPolicy is the retry policy used between failed fetches if no
+// other policy is provided.  It backs off exponentially with jitter up to
+// about 5 minutes between attempts.
+var DefaultRetryPolicy = retry.Config{
+	Interval:    time.Second,
+	Multiplier:  2.0,
+	Jitter:      1.0 / 3.0,
+	MaxInterval: 5 * time.Minute,
+}
+
 /*
 Notes:
   - The network interface is set via the http.Client.
@@ -65,6 +77,8 @@ type Credentials struct {
 	fs                   fs.FS
 	filename             string
 	perm                 iofs.FileMode
+	cipher               *fs.Cipher
+	source               Source
 	client               *http.Client
 	macAddress           wrp.DeviceID
 	serialNumber         string
@@ -74,11 +88,41 @@ type Credentials struct {
 	lastRebootReason     string
 	xmidtProtocol        string
 	bootRetryWait        time.Duration
+	retryPolicyFactory   retry.PolicyFactory
 	lastReconnectReason  func() string // dynamic
 	partnerID            func() string // dynamic
+	issuerKeys           jwt.VerificationKeySet
+	issuerAlgorithms     []string
 
 	// What we are using to decorate the request.
 	token *xmidtInfo
+
+	// What is reported by Status.
+	origin    string
+	lastFetch event.Fetch
+}
+
+// Status is the state of the credentials.  It never includes the token.
+type Status struct {
+	// Origin is where the current token came from, for example "fs" or
+	// "network".  It is empty if there is no token.
+	Origin string `json:"origin"`
+
+	// Valid is true if there is a token that hasn't expired.
+	Valid bool `json:"valid"`
+
+	// ExpiresAt is when the current token expires.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// LastFetch is when the last attempt to obtain a token was made.
+	LastFetch time.Time `json:"last_fetch"`
+
+	// LastStatusCode is the status code of the last attempt, if there was
+	// one.
+	LastStatusCode int `json:"last_status_code,omitempty"`
+
+	// LastError is the error of the last attempt, if there was one.
+	LastError string `json:"last_error,omitempty"`
 }
 
 // Option is the interface implemented by types that can be used to
@@ -90,15 +134,18 @@ type Option interface {
 // New creates a new credentials service object.
 func New(opts ...Option) (*Credentials, error) {
 	required := []Option{
-		urlVador(),
-		macAddressVador(),
-		serialNumberVador(),
-		hardwareModelVador(),
-		hardwareManufacturerVador(),
-		firmwareVersionVador(),
-		lastRebootReasonVador(),
-		xmidtProtocolVador(),
-		bootRetryWaitVador(),
+		issuerVador(
+			urlVador(),
+			macAddressVador(),
+			serialNumberVador(),
+			hardwareModelVador(),
+			hardwareManufacturerVador(),
+			firmwareVersionVador(),
+			lastRebootReasonVador(),
+			xmidtProtocolVador(),
+			bootRetryWaitVador(),
+		),
+		issuerKeysVador(),
 	}
 
 	c := Credentials{
@@ -108,6 +155,7 @@ func New(opts ...Option) (*Credentials, error) {
 		wakeup:              make(chan chan struct{}),
 		nowFunc:             time.Now,
 		refetchPercent:      DefaultRefetchPercent,
+		retryPolicyFactory:  DefaultRetryPolicy,
 		lastReconnectReason: func() string { return "" },
 		partnerID:           func() string { return "" },
 	}
@@ -204,6 +252,50 @@ func (c *Credentials) Credentials() (string, time.Time, error) {
 	return c.token.Token, c.token.ExpiresAt, nil
 }
 
+// Status returns the state of the credentials.
+func (c *Credentials) Status() Status {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	status := Status{
+		LastFetch:      c.lastFetch.At,
+		LastStatusCode: c.lastFetch.StatusCode,
+	}
+	if c.lastFetch.Err != nil {
+		status.LastError = c.lastFetch.Err.Error()
+	}
+
+	if c.token != nil {
+		status.Origin = c.origin
+		status.ExpiresAt = c.token.ExpiresAt
+		status.Valid = c.token.validAt(c.nowFunc()) == nil
+	}
+
+	return status
+}
+
+// notBefore returns when the current token becomes valid.  It is zero if
+// there is no token or the time is not known.
+func (c *Credentials) notBefore() time.Time {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if c.token == nil {
+		return time.Time{}
+	}
+	return c.token.NotBefore
+}
+
+// bearerless returns true if the credentials were obtained from a source that
+// doesn't use a bearer token.
+func (c *Credentials) bearerless() bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	_, mtls := c.source.(mtlsOnly)
+	return mtls && c.token != nil
+}
+
 // Decorate decorates the headers with the credentials.  If the credentials
 // are not valid, an error is returned.
 func (c *Credentials) Decorate(headers http.Header) error {
@@ -229,6 +321,10 @@ func (c *Credentials) decorate(headers http.Header) error {
 	token, expiresAt, e.Err = c.Credentials()
 
 	if e.Err != nil {
+		// Credentials without a bearer token, like mTLS only, add nothing.
+		if errors.Is(e.Err, ErrNoToken) && c.bearerless() {
+			e.Err = nil
+		}
 		return c.dispatch(e)
 	}
 
@@ -238,94 +334,72 @@ func (c *Credentials) decorate(headers http.Header) error {
 		return c.dispatch(e)
 	}
 
+	// The server rejects a token that is not valid yet.
+	if c.nowFunc().Before(c.notBefore()) {
+		e.Err = ErrTokenNotYetValid
+		return c.dispatch(e)
+	}
+
 	headers.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	return c.dispatch(e)
 }
 
-// fetch fetches the credentials from the server.  This should only be called
-// by the run() method.
+// fetch fetches the credentials from the source.  This should only be called
+// by the run() method.  The returned duration is the retry time suggested by
+// the source on failure, or the longest to wait before fetching again on
+// success.
 func (c *Credentials) fetch(ctx context.Context) (*xmidtInfo, time.Duration, error) {
 	fe := event.Fetch{
 		Origin: "network",
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	fe.At = time.Now()
+	got, err := c.source.Fetch(ctx, &fe)
+	fe.Duration = time.Since(fe.At)
 	if err != nil {
-		fe.Err = errors.Join(err, ErrFetchNotAttempted)
-		return nil, 0, c.dispatch(fe)
+		if !errors.Is(err, ErrFetchNotAttempted) {
+			err = errors.Join(err, ErrFetchFailed)
+		}
+		fe.Err = err
+		return nil, fe.RetryIn, c.dispatch(fe)
 	}
 
-	tid, err := uuid.NewRandom()
-	if err != nil {
-		fe.Err = errors.Join(err, ErrFetchNotAttempted)
-		return nil, 0, c.dispatch(fe)
+	token := xmidtInfo{
+		Token:     got.Bearer,
+		ExpiresAt: got.ExpiresAt,
 	}
 
-	fe.UUID = tid
-
-	req.Header.Set("X-Midt-Boot-Retry-Wait", c.bootRetryWait.String())
-	req.Header.Set("X-Midt-Mac-Address", c.macAddress.ID())
-	req.Header.Set("X-Midt-Serial-Number", c.serialNumber)
-	req.Header.Set("X-Midt-Uuid", tid.String())
-	req.Header.Set("X-Midt-Partner-Id", c.partnerID())
-	req.Header.Set("X-Midt-Hardware-Model", c.hardwareModel)
-	req.Header.Set("X-Midt-Hardware-Manufacturer", c.hardwareManufacturer)
-	req.Header.Set("X-Midt-Firmware-Name", c.firmwareVersion)
-	req.Header.Set("X-Midt-Protocol", c.xmidtProtocol)
-	req.Header.Set("X-Midt-Last-Reboot-Reason", c.lastRebootReason)
-	req.Header.Set("X-Midt-Last-Reconnect-Reason", c.lastReconnectReason())
+	c.determineExpiration(&token)
 
-	fe.At = time.Now()
-	resp, err := c.client.Do(req)
-	fe.Duration = time.Since(fe.At)
-	if err != nil {
+	if err = c.applyClaims(&token); err != nil {
 		fe.Err = errors.Join(err, ErrFetchFailed)
 		return nil, 0, c.dispatch(fe)
 	}
-	defer resp.Body.Close()
-
-	fe.StatusCode = resp.StatusCode
-	if resp.StatusCode != http.StatusOK {
-		var retryIn time.Duration
-		if resp.StatusCode == http.StatusTooManyRequests {
-			if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
-				retryIn = time.Duration(after) * time.Second
-			}
-		}
 
-		fe.RetryIn = retryIn
-		fe.Err = errors.Join(err, ErrFetchFailed)
-		return nil, retryIn, c.dispatch(fe)
-	}
-
-	var token xmidtInfo
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	fe.Expiration = token.ExpiresAt
+	if err = token.validAt(c.nowFunc()); err != nil {
 		fe.Err = errors.Join(err, ErrFetchFailed)
 		return nil, 0, c.dispatch(fe)
 	}
-	token.Token = string(body)
 
-	c.determineExpiration(resp, &token)
-
-	fe.Expiration = token.ExpiresAt
-
-	return &token, 0, c.dispatch(fe)
+	return &token, got.RefreshIn, c.dispatch(fe)
 }
 
-func (c *Credentials) determineExpiration(resp *http.Response, token *xmidtInfo) {
+// determineExpiration fills in the expiration time if the source didn't
+// provide one.
+func (c *Credentials) determineExpiration(token *xmidtInfo) {
+	if !token.ExpiresAt.IsZero() {
+		// Even better, we were told when it expires.
+		return
+	}
+
 	// One hundred years is forever.
 	token.ExpiresAt = c.nowFunc().Add(time.Hour * 24 * 365 * 100)
 	if c.assumedLifetime > 0 {
 		// If we have an assumed lifetime, use it.
 		token.ExpiresAt = c.nowFunc().Add(c.assumedLifetime)
 	}
-
-	if expiration, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
-		// Even better, we were told when it expires.
-		token.ExpiresAt = expiration
-	}
 }
 
 // run is the main loop for the credentials service.
@@ -336,7 +410,7 @@ func (c *Credentials) run(ctx context.Context) {
 		fromDisc  bool
 		fetched   bool
 		valid     bool
-		retryIn   time.Duration
+		hint      time.Duration
 	)
 
 	c.wg.Add(1)
@@ -348,9 +422,14 @@ func (c *Credentials) run(ctx context.Context) {
 		skipFetch = true
 	}
 
+	policy := c.retryPolicyFactory.NewPolicy(ctx)
+	defer func() {
+		policy.Cancel()
+	}()
+
 	for {
 		if !skipFetch {
-			token, retryIn, err = c.fetch(ctx)
+			token, hint, err = c.fetch(ctx)
 			if err == nil {
 				fromDisc = false
 			}
@@ -363,12 +442,17 @@ func (c *Credentials) run(ctx context.Context) {
 		// Only skip the fetch once.
 		skipFetch = false
 
-		// Assume we failed, so retry in 1 second or when the server suggested.
-		next := max(time.Second, retryIn)
+		// Assume we failed, so back off or wait as long as the server
+		// suggested, whichever is longer.
+		next := max(c.backoff(ctx, &policy), hint)
 
 		if err == nil && token != nil {
 			expires := token.ExpiresAt
 
+			// Start backing off from the beginning after the next failure.
+			policy.Cancel()
+			policy = c.retryPolicyFactory.NewPolicy(ctx)
+
 			c.m.Lock()
 			c.token = token
 			c.m.Unlock()
@@ -382,10 +466,15 @@ func (c *Credentials) run(ctx context.Context) {
 				_ = c.store(token)
 			}
 
-			until := expires.Sub(c.nowFunc())
-			if 0 < until {
+			until := c.refetchIn(token)
+			if 0 < until && c.nowFunc().Before(expires) {
 				// Add a timer to fetch the token again
-				next = time.Duration(float64(until) * c.refetchPercent / 100.0)
+				next = until
+			}
+
+			// The source may need to be checked before the token expires.
+			if 0 < hint && hint < next {
+				next = hint
 			}
 		}
 
@@ -408,8 +497,22 @@ func (c *Credentials) run(ctx context.Context) {
 	}
 }
 
+// backoff returns the time to wait before the next fetch attempt.  A policy
+// that has given up is replaced since the credentials are always needed.
+func (c *Credentials) backoff(ctx context.Context, policy *retry.Policy) time.Duration {
+	next, ok := (*policy).Next()
+	if !ok {
+		(*policy).Cancel()
+		*policy = c.retryPolicyFactory.NewPolicy(ctx)
+		next, _ = (*policy).Next()
+	}
+
+	// Never retry in a tight loop.
+	return max(next, time.Second)
+}
+
 func (c *Credentials) store(token *xmidtInfo) error {
-	if c.fs == nil {
+	if c.fs == nil || token.Token == "" {
 		return nil
 	}
 
@@ -421,9 +524,14 @@ func (c *Credentials) store(token *xmidtInfo) error {
 		return err
 	}
 
+	write := fs.WriteFileWithSHA256(c.filename, buf, c.perm)
+	if c.cipher != nil {
+		write = fs.WriteFileEncrypted(c.filename, buf, c.perm, c.cipher)
+	}
+
 	return fs.Operate(c.fs,
 		fs.WithPath(c.filename, c.perm),
-		fs.WriteFileWithSHA256(c.filename, buf, c.perm))
+		write)
 }
 
 func (c *Credentials) load() (*xmidtInfo, error) {
@@ -436,11 +544,17 @@ func (c *Credentials) load() (*xmidtInfo, error) {
 	}
 
 	var buf []byte
+	var stale bool
+
+	read := fs.ReadFileWithSHA256(c.filename, &buf)
+	if c.cipher != nil {
+		read = fs.ReadFileEncrypted(c.filename, &buf, c.cipher, &stale)
+	}
 
 	fe.At = time.Now()
 	err := fs.Operate(c.fs,
 		fs.WithPath(c.filename, c.perm),
-		fs.ReadFileWithSHA256(c.filename, &buf))
+		read)
 	fe.Duration = time.Since(fe.At)
 	if err != nil {
 		fe.Err = errors.Join(err, ErrFetchFailed)
@@ -456,7 +570,25 @@ func (c *Credentials) load() (*xmidtInfo, error) {
 		fe.Err = errors.Join(err, ErrFetchFailed)
 		return nil, c.dispatch(fe)
 	}
+
+	// The stored token is checked again in case the issuer keys changed.
+	if err = c.applyClaims(&token); err != nil {
+		fe.Err = errors.Join(err, ErrFetchFailed)
+		return nil, c.dispatch(fe)
+	}
+
 	fe.Expiration = token.ExpiresAt
+	if err = token.validAt(c.nowFunc()); err != nil {
+		fe.Err = errors.Join(err, ErrFetchFailed)
+		return nil, c.dispatch(fe)
+	}
+
+	// Plaintext files and files encrypted with an old key are rewritten
+	// with the current key.
+	if stale {
+		fe.StoreErr = c.store(&token)
+	}
+
 	return &token, c.dispatch(fe)
 }
 
@@ -465,6 +597,13 @@ func (c *Credentials) load() (*xmidtInfo, error) {
 func (c *Credentials) dispatch(evnt any) error {
 	switch evnt := evnt.(type) {
 	case event.Fetch:
+		c.m.Lock()
+		c.lastFetch = evnt
+		if evnt.Err == nil {
+			c.origin = evnt.Origin
+		}
+		c.m.Unlock()
+
 		c.fetchListeners.Visit(func(listener event.FetchListener) {
 			listener.OnFetch(evnt)
 		})
@@ -480,8 +619,22 @@ func (c *Credentials) dispatch(evnt any) error {
 }
 
 // xmidtInfo is the token returned from the server as well as the expiration
-// time.
+// time.  The issued at and not before times are only known if the token is a
+// JWT.
 type xmidtInfo struct {
 	Token     string
 	ExpiresAt time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+}
+
+// validAt returns an error if the token is not valid at the time.
+func (x *xmidtInfo) validAt(now time.Time) error {
+	if now.Before(x.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if !now.Before(x.ExpiresAt) {
+		return ErrTokenExpired
+	}
+	return nil
 }
@@ -5,6 +5,7 @@ package credentials
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -14,8 +15,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/retry"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
 	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
 )
 
@@ -178,6 +181,20 @@ func TestNew(t *testing.T) {
 			check: func(assert *assert.Assertions, c *Credentials) {
 				assert.NotNil(c.partnerID)
 			},
+		}, {
+			description: "retry policy",
+			opts: append(simplest, []Option{
+				RetryPolicy(retry.Config{Interval: time.Minute}),
+			}...),
+			check: func(assert *assert.Assertions, c *Credentials) {
+				assert.Equal(retry.Config{Interval: time.Minute}, c.retryPolicyFactory)
+			},
+		}, {
+			description: "nil retry policy",
+			opts: append(simplest, []Option{
+				RetryPolicy(nil),
+			}...),
+			expectedErr: ErrInvalidInput,
 		}, {
 			description: "invalid last reconnect reason",
 			opts: append(simplest, []Option{
@@ -261,6 +278,13 @@ func TestEndToEnd429(t *testing.T) {
 	defer cancel()
 	c.WaitUntilFetched(deadline)
 	assert.Equal(1, called)
+
+	status := c.Status()
+	assert.False(status.Valid)
+	assert.Empty(status.Origin)
+	assert.Equal(http.StatusTooManyRequests, status.LastStatusCode)
+	assert.NotEmpty(status.LastError)
+	assert.False(status.LastFetch.IsZero())
 }
 
 func TestEndToEndWithExpires(t *testing.T) {
@@ -641,3 +665,243 @@ func TestToAndFromFile(t *testing.T) {
 
 	assert.Equal(1, count)
 }
+
+func TestEncryptedStorage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldKey := []byte("0123456789abcdef")
+	newKey := []byte("fedcba9876543210")
+
+	plain := mem.New(mem.WithDir(".", 0755))
+
+	opts := []Option{
+		URL("http://example.com"),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		LocalStorage(plain, "credentials.msgpack", 0600),
+	}
+
+	token := xmidtInfo{
+		Token:     "secret-token",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	// Store the token in plaintext like an older version would.
+	c, err := New(opts...)
+	require.NoError(err)
+	require.NoError(c.store(&token))
+
+	load := func(keys ...[]byte) (*xmidtInfo, error) {
+		cipher, err := fs.NewCipher(keys...)
+		require.NoError(err)
+
+		c, err := New(append(opts, EncryptStorage(cipher))...)
+		require.NoError(err)
+		return c.load()
+	}
+
+	raw := func() []byte {
+		buf, err := plain.ReadFile("credentials.msgpack")
+		require.NoError(err)
+		return buf
+	}
+
+	// The plaintext file is migrated when it is loaded.
+	got, err := load(oldKey)
+	require.NoError(err)
+	assert.Equal(token.Token, got.Token)
+	assert.NotContains(string(raw()), token.Token)
+
+	// Rotating the key rewrites the file with the new key.
+	got, err = load(newKey, oldKey)
+	require.NoError(err)
+	assert.Equal(token.Token, got.Token)
+
+	got, err = load(newKey)
+	require.NoError(err)
+	assert.Equal(token.Token, got.Token)
+
+	// The old key alone can no longer read the file.
+	got, err = load(oldKey)
+	assert.Nil(got)
+	assert.ErrorIs(err, fs.ErrNoKey)
+}
+
+func TestEncryptedStorageRewriteFails(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	plain := mem.New(mem.WithDir(".", 0755))
+
+	var fetches []event.Fetch
+	opts := []Option{
+		URL("http://example.com"),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		LocalStorage(plain, "credentials.msgpack", 0600),
+		AddFetchListener(event.FetchListenerFunc(
+			func(e event.Fetch) {
+				fetches = append(fetches, e)
+			})),
+	}
+
+	token := xmidtInfo{
+		Token:     "secret-token",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	c, err := New(opts...)
+	require.NoError(err)
+	require.NoError(c.store(&token))
+
+	// The plaintext file can be read, but not rewritten.
+	f := plain.Files["credentials.msgpack"]
+	f.Perm = 0400
+	plain.Files["credentials.msgpack"] = f
+
+	cipher, err := fs.NewCipher([]byte("0123456789abcdef"))
+	require.NoError(err)
+
+	c, err = New(append(opts, EncryptStorage(cipher))...)
+	require.NoError(err)
+
+	got, err := c.load()
+	require.NoError(err)
+	assert.Equal(token.Token, got.Token)
+
+	require.Len(fetches, 1)
+	assert.NoError(fetches[0].Err)
+	assert.Error(fetches[0].StoreErr)
+}
+
+func TestEndToEnd503(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Body.Close()
+
+				w.Header().Add("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		),
+	)
+	defer server.Close()
+
+	var called int
+	c, err := New(
+		URL(server.URL),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		NowFunc(func() time.Time { return now }),
+		AddFetchListener(event.FetchListenerFunc(
+			func(e event.Fetch) {
+				assert.Equal(time.Minute, e.RetryIn)
+				assert.Equal(http.StatusServiceUnavailable, e.StatusCode)
+				assert.ErrorIs(e.Err, ErrFetchFailed)
+				called++
+			})),
+	)
+
+	require.NoError(err)
+	require.NotNil(c)
+
+	c.Start()
+	defer c.Stop()
+
+	ctx := context.Background()
+	deadline, cancel := context.WithDeadline(ctx, time.Now().Add(100*time.Millisecond))
+	defer cancel()
+	c.WaitUntilFetched(deadline)
+	assert.Equal(1, called)
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	c := Credentials{
+		retryPolicyFactory: retry.Config{
+			Interval:    2 * time.Second,
+			Multiplier:  2.0,
+			MaxInterval: 5 * time.Second,
+			MaxRetries:  3,
+		},
+	}
+
+	ctx := context.Background()
+	policy := c.retryPolicyFactory.NewPolicy(ctx)
+	defer func() {
+		policy.Cancel()
+	}()
+
+	// The interval grows up to the cap, then starts over once the policy
+	// gives up.
+	assert.Equal(2*time.Second, c.backoff(ctx, &policy))
+	assert.Equal(4*time.Second, c.backoff(ctx, &policy))
+	assert.Equal(5*time.Second, c.backoff(ctx, &policy))
+	assert.Equal(2*time.Second, c.backoff(ctx, &policy))
+
+	// Intervals shorter than a second are not used.
+	c.retryPolicyFactory = retry.Config{Interval: time.Millisecond}
+	policy = c.retryPolicyFactory.NewPolicy(ctx)
+	assert.Equal(time.Second, c.backoff(ctx, &policy))
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c, err := New(
+		UseSource(StaticToken("token")),
+		AssumedLifetime(time.Hour),
+		NowFunc(func() time.Time { return now }),
+	)
+	require.NoError(err)
+
+	// Nothing has been fetched yet.
+	assert.Equal(Status{}, c.Status())
+
+	c.Start()
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.WaitUntilValid(ctx)
+
+	status := c.Status()
+	assert.Equal("static", status.Origin)
+	assert.True(status.Valid)
+	assert.True(now.Add(time.Hour).Equal(status.ExpiresAt))
+	assert.False(status.LastFetch.IsZero())
+	assert.Empty(status.LastError)
+
+	// The token is never part of the status.
+	buf, err := json.Marshal(status)
+	require.NoError(err)
+	assert.NotContains(string(buf), "token")
+}
@@ -15,7 +15,8 @@ type CancelListenerFunc func()
 
 // Fetch is the event that is sent when the credentials are fetched.
 type Fetch struct {
-	// The origin of the data - "fs" or "network" are the only valid values.
+	// The origin of the data - "fs" for the cached credentials, "network",
+	// "file", "static" or "mtls" depending on the source.
 	Origin string
 
 	// At holds the time when the fetch request was made.
@@ -40,6 +41,11 @@ type Fetch struct {
 
 	// Error is the error returned from the SAT service.
 	Err error
+
+	// StoreErr is the error from rewriting the cached credentials with the
+	// current encryption key.  It doesn't make the fetch fail, but the file
+	// may still be plaintext or encrypted with an old key.
+	StoreErr error
 }
 
 // FetchListener is the interface that must be implemented by types that
@@ -5,6 +5,26 @@ package credentials
 
 import "fmt"
 
+// issuerVador applies the validators for the issuer options and uses the
+// issuer as the source, unless another source is being used.
+func issuerVador(vadors ...Option) Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			if c.source != nil {
+				return nil
+			}
+
+			for _, vador := range vadors {
+				if err := vador.apply(c); err != nil {
+					return err
+				}
+			}
+
+			c.source = SourceFunc(c.issue)
+			return nil
+		})
+}
+
 func urlVador() Option {
 	return optionFunc(
 		func(c *Credentials) error {
@@ -94,3 +114,13 @@ func bootRetryWaitVador() Option {
 			return nil
 		})
 }
+
+func issuerKeysVador() Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			if len(c.issuerKeys.Keys) > 0 && len(c.issuerAlgorithms) == 0 {
+				return fmt.Errorf("%w issuer algorithms are missing", ErrInvalidInput)
+			}
+			return nil
+		})
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/retryafter"
+)
+
+// issue fetches the credentials from the issuer, which identifies the device
+// by the X-Midt headers.  This is the default Source.
+func (c *Credentials) issue(ctx context.Context, fe *event.Fetch) (Token, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return Token{}, errors.Join(err, ErrFetchNotAttempted)
+	}
+
+	tid, err := uuid.NewRandom()
+	if err != nil {
+		return Token{}, errors.Join(err, ErrFetchNotAttempted)
+	}
+
+	fe.UUID = tid
+
+	req.Header.Set("X-Midt-Boot-Retry-Wait", c.bootRetryWait.String())
+	req.Header.Set("X-Midt-Mac-Address", c.macAddress.ID())
+	req.Header.Set("X-Midt-Serial-Number", c.serialNumber)
+	req.Header.Set("X-Midt-Uuid", tid.String())
+	req.Header.Set("X-Midt-Partner-Id", c.partnerID())
+	req.Header.Set("X-Midt-Hardware-Model", c.hardwareModel)
+	req.Header.Set("X-Midt-Hardware-Manufacturer", c.hardwareManufacturer)
+	req.Header.Set("X-Midt-Firmware-Name", c.firmwareVersion)
+	req.Header.Set("X-Midt-Protocol", c.xmidtProtocol)
+	req.Header.Set("X-Midt-Last-Reboot-Reason", c.lastRebootReason)
+	req.Header.Set("X-Midt-Last-Reconnect-Reason", c.lastReconnectReason())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+
+	fe.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			fe.RetryIn = retryafter.Parse(resp.Header.Get("Retry-After"), c.nowFunc())
+		}
+
+		return Token{}, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Token{}, err
+	}
+
+	token := Token{
+		Bearer: string(body),
+	}
+
+	if expiration, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		token.ExpiresAt = expiration
+	}
+
+	return token, nil
+}
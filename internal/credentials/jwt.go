@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claims returns the registered claims of the token if it is a JWT.  If
+// issuer keys are configured the token must be a JWT signed by one of them,
+// otherwise the signature is not checked and a token that isn't a JWT
+// results in nil claims and no error.
+func (c *Credentials) claims(token string) (*jwt.RegisteredClaims, error) {
+	var claims jwt.RegisteredClaims
+
+	if len(c.issuerKeys.Keys) == 0 {
+		if strings.Count(token, ".") != 2 {
+			return nil, nil
+		}
+
+		_, _, err := jwt.NewParser().ParseUnverified(token, &claims)
+		if err != nil {
+			// Not a JWT, so it is an opaque token.
+			return nil, nil
+		}
+		return &claims, nil
+	}
+
+	// The time based claims are checked by the caller against nowFunc.
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(c.issuerAlgorithms),
+		jwt.WithoutClaimsValidation(),
+	)
+
+	_, err := parser.ParseWithClaims(token, &claims,
+		func(*jwt.Token) (any, error) {
+			return c.issuerKeys, nil
+		})
+	if err != nil {
+		return nil, errors.Join(ErrInvalidToken, err)
+	}
+
+	return &claims, nil
+}
+
+// applyClaims updates the token times with the JWT claims of the token, if
+// there are any.  The exp claim takes precedence over any other source of the
+// expiration time.
+func (c *Credentials) applyClaims(token *xmidtInfo) error {
+	if c.ignoreBody && len(c.issuerKeys.Keys) == 0 {
+		return nil
+	}
+
+	claims, err := c.claims(token.Token)
+	if err != nil || claims == nil || c.ignoreBody {
+		return err
+	}
+
+	if claims.ExpiresAt != nil {
+		token.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.NotBefore != nil {
+		token.NotBefore = claims.NotBefore.Time
+	}
+	if claims.IssuedAt != nil {
+		token.IssuedAt = claims.IssuedAt.Time
+	}
+
+	return nil
+}
+
+// refetchIn returns how long to wait before fetching a replacement for the
+// token.  The lifetime of the token starts when it was issued (or became
+// valid) if that is known, otherwise it starts now.
+func (c *Credentials) refetchIn(token *xmidtInfo) time.Duration {
+	now := c.nowFunc()
+
+	start := now
+	if issued := latest(token.IssuedAt, token.NotBefore); !issued.IsZero() && issued.Before(now) {
+		start = issued
+	}
+
+	lifetime := token.ExpiresAt.Sub(start)
+	refetchAt := start.Add(time.Duration(float64(lifetime) * c.refetchPercent / 100.0))
+
+	return refetchAt.Sub(now)
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,417 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
+)
+
+func newIssuerKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func signToken(t *testing.T, key *ecdsa.PrivateKey, claims jwt.RegisteredClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+func TestApplyClaims(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key, pub := newIssuerKey(t)
+	_, otherPub := newIssuerKey(t)
+
+	valid := jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Hour)),
+		NotBefore: jwt.NewNumericDate(now.Add(-time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	signed := signToken(t, key, valid)
+
+	unverified, err := jwt.NewWithClaims(jwt.SigningMethodNone, valid).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	tests := []struct {
+		description string
+		opts        []Option
+		token       string
+		expires     time.Time
+		issuedAt    time.Time
+		expectedErr error
+	}{
+		{
+			description: "opaque token",
+			token:       "token",
+			expires:     now.Add(time.Minute),
+		}, {
+			description: "jwt without verification",
+			token:       unverified,
+			expires:     now.Add(time.Hour),
+			issuedAt:    now.Add(-time.Hour),
+		}, {
+			description: "jwt with verification",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("ES256")},
+			token:       signed,
+			expires:     now.Add(time.Hour),
+			issuedAt:    now.Add(-time.Hour),
+		}, {
+			description: "jwt signed by another key",
+			opts:        []Option{IssuerKeys(otherPub), IssuerAlgorithms("ES256")},
+			token:       signed,
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "jwt with a disallowed algorithm",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("RS256")},
+			token:       signed,
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "unsigned jwt with verification",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("ES256")},
+			token:       unverified,
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "opaque token with verification",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("ES256")},
+			token:       "token",
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "jwt with the body ignored",
+			opts:        []Option{IgnoreBody()},
+			token:       unverified,
+			expires:     now.Add(time.Minute),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			c, err := New(append(tc.opts,
+				URL("http://example.com"),
+				MacAddress(wrp.DeviceID("mac:112233445566")),
+				SerialNumber("1234567890"),
+				HardwareModel("model"),
+				HardwareManufacturer("manufacturer"),
+				FirmwareVersion("version"),
+				LastRebootReason("reason"),
+				XmidtProtocol("protocol"),
+				BootRetryWait(1),
+			)...)
+			require.NoError(err)
+
+			token := xmidtInfo{
+				Token:     tc.token,
+				ExpiresAt: now.Add(time.Minute),
+			}
+			err = c.applyClaims(&token)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.True(tc.expires.Equal(token.ExpiresAt))
+			assert.True(tc.issuedAt.Equal(token.IssuedAt))
+		})
+	}
+}
+
+func TestIssuerOptions(t *testing.T) {
+	_, pub := newIssuerKey(t)
+
+	tests := []struct {
+		description string
+		opts        []Option
+		expectedErr error
+	}{
+		{
+			description: "keys and algorithms",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("ES256", "EdDSA")},
+		}, {
+			description: "keys without algorithms",
+			opts:        []Option{IssuerKeys(pub)},
+			expectedErr: ErrInvalidInput,
+		}, {
+			description: "invalid key",
+			opts:        []Option{IssuerKeys([]byte("invalid")), IssuerAlgorithms("ES256")},
+			expectedErr: ErrInvalidInput,
+		}, {
+			description: "invalid algorithm",
+			opts:        []Option{IssuerKeys(pub), IssuerAlgorithms("HS256")},
+			expectedErr: ErrInvalidInput,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := New(append(tc.opts,
+				URL("http://example.com"),
+				MacAddress(wrp.DeviceID("mac:112233445566")),
+				SerialNumber("1234567890"),
+				HardwareModel("model"),
+				HardwareManufacturer("manufacturer"),
+				FirmwareVersion("version"),
+				LastRebootReason("reason"),
+				XmidtProtocol("protocol"),
+				BootRetryWait(1),
+			)...)
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestRefetchIn(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		token       xmidtInfo
+		expected    time.Duration
+	}{
+		{
+			description: "unknown issue time",
+			token:       xmidtInfo{ExpiresAt: now.Add(10 * time.Hour)},
+			expected:    9 * time.Hour,
+		}, {
+			description: "issued earlier",
+			token: xmidtInfo{
+				IssuedAt:  now.Add(-5 * time.Hour),
+				ExpiresAt: now.Add(5 * time.Hour),
+			},
+			expected: 4 * time.Hour,
+		}, {
+			description: "valid later than issued",
+			token: xmidtInfo{
+				IssuedAt:  now.Add(-9 * time.Hour),
+				NotBefore: now.Add(-5 * time.Hour),
+				ExpiresAt: now.Add(5 * time.Hour),
+			},
+			expected: 4 * time.Hour,
+		}, {
+			description: "refetch time has passed",
+			token: xmidtInfo{
+				IssuedAt:  now.Add(-19 * time.Hour),
+				ExpiresAt: now.Add(time.Hour),
+			},
+			expected: -time.Hour,
+		}, {
+			description: "issued in the future",
+			token: xmidtInfo{
+				IssuedAt:  now.Add(time.Hour),
+				ExpiresAt: now.Add(10 * time.Hour),
+			},
+			expected: 9 * time.Hour,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			c := Credentials{
+				nowFunc:        func() time.Time { return now },
+				refetchPercent: DefaultRefetchPercent,
+			}
+
+			assert.Equal(t, tc.expected, c.refetchIn(&tc.token))
+		})
+	}
+}
+
+func TestEndToEndJWT(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, pub := newIssuerKey(t)
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	token := signToken(t, key, jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(exp),
+	})
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Body.Close()
+
+				// The token expiration takes precedence.
+				w.Header().Add("Expires", time.Now().Add(24*time.Hour).Format(http.TimeFormat))
+				_, _ = w.Write([]byte(token))
+			},
+		),
+	)
+	defer server.Close()
+
+	c, err := New(
+		URL(server.URL),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		IssuerKeys(pub),
+		IssuerAlgorithms("ES256"),
+		AddFetchListener(event.FetchListenerFunc(
+			func(e event.Fetch) {
+				assert.NoError(e.Err)
+				assert.True(exp.Equal(e.Expiration))
+			})),
+	)
+	require.NoError(err)
+
+	c.Start()
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.WaitUntilValid(ctx)
+
+	got, expires, err := c.Credentials()
+	require.NoError(err)
+	assert.Equal(token, got)
+	assert.True(exp.Equal(expires))
+}
+
+func TestExpiredJWTFromFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, pub := newIssuerKey(t)
+	now := time.Now()
+
+	expired := signToken(t, key, jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now.Add(-2 * time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(-time.Hour)),
+	})
+
+	fs := mem.New(mem.WithDir(".", 0755))
+
+	var fetches []event.Fetch
+	c, err := New(
+		URL("http://example.com"),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		IssuerKeys(pub),
+		IssuerAlgorithms("ES256"),
+		LocalStorage(fs, "credentials.msgpack", 0600),
+		AddFetchListener(event.FetchListenerFunc(
+			func(e event.Fetch) {
+				fetches = append(fetches, e)
+			})),
+	)
+	require.NoError(err)
+
+	// The stored expiration is in the future, but the token is expired.
+	require.NoError(c.store(&xmidtInfo{
+		Token:     expired,
+		ExpiresAt: now.Add(time.Hour),
+	}))
+
+	got, err := c.load()
+	assert.Nil(got)
+	assert.ErrorIs(err, ErrTokenExpired)
+
+	require.Len(fetches, 1)
+	assert.Equal("fs", fetches[0].Origin)
+	assert.ErrorIs(fetches[0].Err, ErrTokenExpired)
+}
+
+func TestNotYetValidJWT(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, pub := newIssuerKey(t)
+	now := time.Now()
+
+	early := signToken(t, key, jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now.Add(time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(2 * time.Hour)),
+	})
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Body.Close()
+
+				_, _ = w.Write([]byte(early))
+			},
+		),
+	)
+	defer server.Close()
+
+	var decorates []event.Decorate
+	c, err := New(
+		URL(server.URL),
+		MacAddress(wrp.DeviceID("mac:112233445566")),
+		SerialNumber("1234567890"),
+		HardwareModel("model"),
+		HardwareManufacturer("manufacturer"),
+		FirmwareVersion("version"),
+		LastRebootReason("reason"),
+		XmidtProtocol("protocol"),
+		BootRetryWait(1),
+		Required(),
+		IssuerKeys(pub),
+		IssuerAlgorithms("ES256"),
+		AddDecorateListener(event.DecorateListenerFunc(
+			func(e event.Decorate) {
+				decorates = append(decorates, e)
+			})),
+	)
+	require.NoError(err)
+
+	// A fetched token that is not valid yet is rejected.
+	got, _, err := c.fetch(context.Background())
+	assert.Nil(got)
+	assert.ErrorIs(err, ErrTokenNotYetValid)
+	assert.ErrorIs(err, ErrFetchFailed)
+
+	// A token that is not valid yet is not sent.
+	c.token = &xmidtInfo{
+		Token:     early,
+		NotBefore: now.Add(time.Hour),
+		ExpiresAt: now.Add(2 * time.Hour),
+	}
+
+	headers := http.Header{}
+	err = c.Decorate(headers)
+	assert.ErrorIs(err, ErrTokenNotYetValid)
+	assert.Empty(headers.Get("Authorization"))
+
+	require.Len(decorates, 1)
+	assert.ErrorIs(decorates[0].Err, ErrTokenNotYetValid)
+	assert.False(c.Status().Valid)
+}
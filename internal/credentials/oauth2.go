@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/retryafter"
+)
+
+// maxOAuth2Response is the largest token response that is read.
+const maxOAuth2Response = 1024 * 1024
+
+// OAuth2 is a Source that obtains a token using the OAuth2 client credentials
+// grant (RFC 6749 section 4.4).
+type OAuth2 struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+
+	// ClientID and ClientSecret authenticate the client with HTTP basic
+	// authentication.
+	ClientID     string
+	ClientSecret string
+
+	// Scopes are the optional scopes to request.
+	Scopes []string
+
+	// Client is the HTTP client used to request the token.  If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+var _ Source = OAuth2{}
+
+// oauth2Response is the successful token response (RFC 6749 section 5.1).
+type oauth2Response struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Fetch requests a token from the token endpoint.
+func (o OAuth2) Fetch(ctx context.Context, fe *event.Fetch) (Token, error) {
+	if o.TokenURL == "" || o.ClientID == "" {
+		return Token{}, errors.Join(
+			fmt.Errorf("%w token url and client id are required", ErrInvalidInput),
+			ErrFetchNotAttempted)
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, errors.Join(err, ErrFetchNotAttempted)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+
+	fe.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			fe.RetryIn = retryafter.Parse(resp.Header.Get("Retry-After"), now)
+		}
+
+		return Token{}, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	var body oauth2Response
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxOAuth2Response)).Decode(&body)
+	if err != nil {
+		return Token{}, errors.Join(ErrInvalidToken, err)
+	}
+
+	if body.AccessToken == "" {
+		return Token{}, ErrNoToken
+	}
+	if !strings.EqualFold(body.TokenType, "bearer") {
+		return Token{}, fmt.Errorf("%w: unsupported token type '%s'", ErrInvalidToken, body.TokenType)
+	}
+
+	token := Token{
+		Bearer: body.AccessToken,
+	}
+	if body.ExpiresIn > 0 {
+		token.ExpiresAt = now.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -4,10 +4,13 @@
 package credentials
 
 import (
+	"fmt"
 	iofs "io/fs"
 	"net/http"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xmidt-org/retry"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
 	"github.com/xmidt-org/xmidt-agent/internal/fs"
@@ -30,6 +33,21 @@ func (f nilOptionFunc) apply(c *Credentials) error {
 	return nil
 }
 
+// UseSource sets where the credentials are obtained from instead of the
+// issuer.  The issuer options, like URL and MacAddress, are not required when
+// another source is used.
+func UseSource(source Source) Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			if source == nil {
+				return fmt.Errorf("%w nil source", ErrInvalidInput)
+			}
+
+			c.source = source
+			return nil
+		})
+}
+
 // URL is the URL of the credential service.
 func URL(url string) Option {
 	return nilOptionFunc(
@@ -80,7 +98,7 @@ func AssumedLifetime(lifetime time.Duration) Option {
 
 // IgnoreBody is a flag that indicates whether the body of the response should
 // be ignored instead of examined for an expiration time.  The default is to
-// examine the body.
+// examine the body.  A token is still verified if IssuerKeys are provided.
 func IgnoreBody() Option {
 	return nilOptionFunc(
 		func(c *Credentials) {
@@ -97,7 +115,59 @@ func Required() Option {
 		})
 }
 
-// LocalStorage is the local storage used to cache the credentials.
+// IssuerAlgorithms sets the algorithms allowed when verifying the token with
+// the IssuerKeys.  Valid algorithms are "EdDSA", "ES256", "ES384", "ES512",
+// "PS256", "PS384", "PS512", "RS256", "RS384", and "RS512".
+func IssuerAlgorithms(algs ...string) Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			for _, alg := range algs {
+				switch alg {
+				case "EdDSA",
+					"ES256", "ES384", "ES512",
+					"PS256", "PS384", "PS512",
+					"RS256", "RS384", "RS512":
+				default:
+					return fmt.Errorf("%w unsupported algorithm '%s'", ErrInvalidInput, alg)
+				}
+				c.issuerAlgorithms = append(c.issuerAlgorithms, alg)
+			}
+			return nil
+		})
+}
+
+// IssuerKeys adds PEM-encoded public keys of the credential issuer.  If any
+// keys are provided, tokens must be JWTs signed by one of the keys using one
+// of the IssuerAlgorithms, otherwise they are treated as invalid.
+func IssuerKeys(pems ...[]byte) Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			for _, pem := range pems {
+				var key jwt.VerificationKey
+				var err error
+
+				key, err = jwt.ParseECPublicKeyFromPEM(pem)
+
+				if err != nil {
+					key, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+				}
+
+				if err != nil {
+					key, err = jwt.ParseEdPublicKeyFromPEM(pem)
+				}
+
+				if err != nil {
+					return fmt.Errorf("%w invalid pem", ErrInvalidInput)
+				}
+
+				c.issuerKeys.Keys = append(c.issuerKeys.Keys, key)
+			}
+			return nil
+		})
+}
+
+// LocalStorage is the local storage used to cache the credentials.  This is
+// only useful with sources that fetch the credentials over the network.
 //
 // The filename (and path) is relative to the provided filesystem.
 func LocalStorage(fs fs.FS, filename string, perm iofs.FileMode) Option {
@@ -109,6 +179,16 @@ func LocalStorage(fs fs.FS, filename string, perm iofs.FileMode) Option {
 		})
 }
 
+// EncryptStorage encrypts the credentials cached in the LocalStorage with the
+// cipher.  A plaintext file from before encryption was enabled, or a file
+// encrypted with an old key, is rewritten with the current key when loaded.
+func EncryptStorage(cipher *fs.Cipher) Option {
+	return nilOptionFunc(
+		func(c *Credentials) {
+			c.cipher = cipher
+		})
+}
+
 // MacAddress is the MAC address of the device.
 func MacAddress(macAddress wrp.DeviceID) Option {
 	return nilOptionFunc(
@@ -175,6 +255,21 @@ func BootRetryWait(bootRetryWait time.Duration) Option {
 		})
 }
 
+// RetryPolicy sets the retry policy factory used for delaying between failed
+// attempts to fetch the credentials.  A Retry-After from the server is
+// honored if it is longer.  The default is DefaultRetryPolicy.
+func RetryPolicy(pf retry.PolicyFactory) Option {
+	return optionFunc(
+		func(c *Credentials) error {
+			if pf == nil {
+				return fmt.Errorf("%w nil retry policy", ErrInvalidInput)
+			}
+
+			c.retryPolicyFactory = pf
+			return nil
+		})
+}
+
 // LastReconnectReason is the reason for the most recent reconnect of the
 // device.  This is a dynamic value that is obtained by calling the function
 // provided.
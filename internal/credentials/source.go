@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
+)
+
+// defaultFileCheckInterval is how often a token file is read again if no
+// interval is provided.
+const defaultFileCheckInterval = time.Minute
+
+// Token is the credential obtained from a Source.
+type Token struct {
+	// Bearer is the token added to the Authorization header.
+	Bearer string
+
+	// ExpiresAt is when the token expires.  The zero value means the source
+	// doesn't know, in which case the AssumedLifetime or the exp claim of a
+	// JWT are used.
+	ExpiresAt time.Time
+
+	// RefreshIn, if positive, is the longest to wait before fetching again
+	// even if the token hasn't expired.
+	RefreshIn time.Duration
+}
+
+// Source is where the credentials are obtained from.  The default source is
+// the issuer configured by the URL and device identity options.
+type Source interface {
+	// Fetch obtains a token.  Details of the attempt, like the status code
+	// and any suggested retry time, are recorded in the fetch event.
+	Fetch(context.Context, *event.Fetch) (Token, error)
+}
+
+// SourceFunc is a function type that implements Source.
+type SourceFunc func(context.Context, *event.Fetch) (Token, error)
+
+func (f SourceFunc) Fetch(ctx context.Context, fe *event.Fetch) (Token, error) {
+	return f(ctx, fe)
+}
+
+// StaticToken returns a Source that always provides the same token.
+func StaticToken(token string) Source {
+	return SourceFunc(
+		func(_ context.Context, fe *event.Fetch) (Token, error) {
+			fe.Origin = "static"
+			if token == "" {
+				return Token{}, ErrNoToken
+			}
+			return Token{Bearer: token}, nil
+		})
+}
+
+// FileToken returns a Source that reads the token from a file kept up to date
+// by another process.  The file is read again every checkInterval, or every
+// minute if checkInterval is not positive.
+func FileToken(f fs.FS, name string, checkInterval time.Duration) Source {
+	if checkInterval <= 0 {
+		checkInterval = defaultFileCheckInterval
+	}
+
+	return SourceFunc(
+		func(_ context.Context, fe *event.Fetch) (Token, error) {
+			fe.Origin = "file"
+
+			if f == nil {
+				return Token{}, errors.Join(ErrInvalidInput, ErrFetchNotAttempted)
+			}
+
+			buf, err := f.ReadFile(name)
+			if err != nil {
+				return Token{}, err
+			}
+
+			token := strings.TrimSpace(string(buf))
+			if token == "" {
+				return Token{}, ErrNoToken
+			}
+
+			return Token{
+				Bearer:    token,
+				RefreshIn: checkInterval,
+			}, nil
+		})
+}
+
+// MTLSOnly returns a Source for when the client certificate is the only
+// credential.  No Authorization header is added to requests.
+func MTLSOnly() Source {
+	return mtlsOnly{}
+}
+
+type mtlsOnly struct{}
+
+func (mtlsOnly) Fetch(_ context.Context, fe *event.Fetch) (Token, error) {
+	fe.Origin = "mtls"
+	return Token{}, nil
+}
@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	iofs "io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials/event"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
+	xafsos "github.com/xmidt-org/xmidt-agent/internal/fs/os"
+)
+
+func TestUseSource(t *testing.T) {
+	assert := assert.New(t)
+
+	// The issuer options are not needed with another source.
+	c, err := New(UseSource(StaticToken("token")))
+	assert.NoError(err)
+	assert.NotNil(c)
+
+	c, err = New(UseSource(nil))
+	assert.ErrorIs(err, ErrInvalidInput)
+	assert.Nil(c)
+}
+
+func TestStaticToken(t *testing.T) {
+	assert := assert.New(t)
+
+	var fe event.Fetch
+	got, err := StaticToken("token").Fetch(context.Background(), &fe)
+	assert.NoError(err)
+	assert.Equal(Token{Bearer: "token"}, got)
+	assert.Equal("static", fe.Origin)
+
+	_, err = StaticToken("").Fetch(context.Background(), &fe)
+	assert.ErrorIs(err, ErrNoToken)
+}
+
+func TestFileToken(t *testing.T) {
+	tests := []struct {
+		description string
+		fs          *mem.FS
+		interval    time.Duration
+		expected    Token
+		expectedErr error
+	}{
+		{
+			description: "token",
+			fs:          mem.New(mem.WithFile("token", "abc\n", 0600)),
+			interval:    time.Hour,
+			expected:    Token{Bearer: "abc", RefreshIn: time.Hour},
+		}, {
+			description: "default interval",
+			fs:          mem.New(mem.WithFile("token", "abc", 0600)),
+			expected:    Token{Bearer: "abc", RefreshIn: defaultFileCheckInterval},
+		}, {
+			description: "empty file",
+			fs:          mem.New(mem.WithFile("token", " \n", 0600)),
+			expectedErr: ErrNoToken,
+		}, {
+			description: "missing file",
+			fs:          mem.New(),
+			expectedErr: iofs.ErrNotExist,
+		}, {
+			description: "no filesystem",
+			expectedErr: ErrFetchNotAttempted,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			source := FileToken(nil, "token", tc.interval)
+			if tc.fs != nil {
+				source = FileToken(tc.fs, "token", tc.interval)
+			}
+
+			var fe event.Fetch
+			got, err := source.Fetch(context.Background(), &fe)
+			assert.Equal("file", fe.Origin)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tc.expected, got)
+		})
+	}
+}
+
+func TestEndToEndFileToken(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "token"), []byte("first"), 0600))
+
+	fs, err := xafsos.New(dir)
+	require.NoError(err)
+
+	c, err := New(
+		UseSource(FileToken(fs, "token", 10*time.Millisecond)),
+	)
+	require.NoError(err)
+
+	c.Start()
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.WaitUntilValid(ctx)
+
+	got, _, err := c.Credentials()
+	require.NoError(err)
+	assert.Equal("first", got)
+
+	// The file is updated by another process.
+	require.NoError(os.WriteFile(filepath.Join(dir, "token"), []byte("second"), 0600))
+
+	assert.Eventually(func() bool {
+		got, _, _ := c.Credentials()
+		return got == "second"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMTLSOnly(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var origin string
+	c, err := New(
+		UseSource(MTLSOnly()),
+		Required(),
+		AddFetchListener(event.FetchListenerFunc(
+			func(e event.Fetch) {
+				origin = e.Origin
+				assert.NoError(e.Err)
+			})),
+	)
+	require.NoError(err)
+
+	headers := http.Header{}
+
+	// Before the credentials are obtained, decorating fails.
+	assert.ErrorIs(c.Decorate(headers), ErrNoToken)
+
+	c.Start()
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.WaitUntilValid(ctx)
+
+	assert.NoError(c.Decorate(headers))
+	assert.Empty(headers.Get("Authorization"))
+	assert.Equal("mtls", origin)
+}
+
+func TestOAuth2(t *testing.T) {
+	tests := []struct {
+		description string
+		oauth2      OAuth2
+		status      int
+		header      http.Header
+		body        any
+		expected    string
+		expires     bool
+		retryIn     time.Duration
+		expectedErr error
+	}{
+		{
+			description: "token",
+			oauth2: OAuth2{
+				ClientID:     "client",
+				ClientSecret: "secret",
+				Scopes:       []string{"a", "b"},
+			},
+			status: http.StatusOK,
+			body: oauth2Response{
+				AccessToken: "token",
+				TokenType:   "Bearer",
+				ExpiresIn:   3600,
+			},
+			expected: "token",
+			expires:  true,
+		}, {
+			description: "token without expiration",
+			oauth2: OAuth2{
+				ClientID: "client",
+			},
+			status: http.StatusOK,
+			body: oauth2Response{
+				AccessToken: "token",
+				TokenType:   "bearer",
+			},
+			expected: "token",
+		}, {
+			description: "unavailable",
+			oauth2: OAuth2{
+				ClientID: "client",
+			},
+			status:      http.StatusServiceUnavailable,
+			header:      http.Header{"Retry-After": []string{"30"}},
+			retryIn:     30 * time.Second,
+			expectedErr: ErrUnexpectedStatus,
+		}, {
+			description: "unsupported token type",
+			oauth2: OAuth2{
+				ClientID: "client",
+			},
+			status: http.StatusOK,
+			body: oauth2Response{
+				AccessToken: "token",
+				TokenType:   "mac",
+			},
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "missing token",
+			oauth2: OAuth2{
+				ClientID: "client",
+			},
+			status: http.StatusOK,
+			body: oauth2Response{
+				TokenType: "bearer",
+			},
+			expectedErr: ErrNoToken,
+		}, {
+			description: "invalid body",
+			oauth2: OAuth2{
+				ClientID: "client",
+			},
+			status:      http.StatusOK,
+			body:        "not json",
+			expectedErr: ErrInvalidToken,
+		}, {
+			description: "missing client id",
+			expectedErr: ErrFetchNotAttempted,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						assert.Equal(http.MethodPost, r.Method)
+						assert.NoError(r.ParseForm())
+						assert.Equal("client_credentials", r.PostForm.Get("grant_type"))
+						if len(tc.oauth2.Scopes) > 0 {
+							assert.Equal("a b", r.PostForm.Get("scope"))
+						}
+
+						id, secret, ok := r.BasicAuth()
+						assert.True(ok)
+						assert.Equal(tc.oauth2.ClientID, id)
+						assert.Equal(tc.oauth2.ClientSecret, secret)
+
+						for k, v := range tc.header {
+							w.Header()[k] = v
+						}
+						w.WriteHeader(tc.status)
+
+						if s, ok := tc.body.(string); ok {
+							_, _ = w.Write([]byte(s))
+							return
+						}
+						_ = json.NewEncoder(w).Encode(tc.body)
+					}))
+			defer server.Close()
+
+			if tc.oauth2.ClientID != "" {
+				tc.oauth2.TokenURL = server.URL
+			}
+
+			var fe event.Fetch
+			got, err := tc.oauth2.Fetch(context.Background(), &fe)
+			assert.Equal(tc.retryIn, fe.RetryIn)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(http.StatusOK, fe.StatusCode)
+			assert.Equal(tc.expected, got.Bearer)
+			assert.Equal(tc.expires, !got.ExpiresAt.IsZero())
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package fs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid encryption key")
+	ErrNoKey      = errors.New("no key for encrypted file")
+	ErrDecrypt    = errors.New("unable to decrypt file")
+)
+
+// encryptedMagic marks the start of an encrypted file.  Files without it are
+// plaintext files written before encryption was enabled.
+var encryptedMagic = []byte("XAENC1")
+
+// keyIDSize is the length of the key fingerprint stored in an encrypted file
+// so the key used to encrypt it can be found.
+const keyIDSize = 8
+
+// Cipher encrypts file contents using AES-GCM.  The first key is used to
+// encrypt and any of the keys may be used to decrypt, which allows the key
+// to be rotated by adding a new key in front of the old one.
+type Cipher struct {
+	keys []cipherKey
+}
+
+type cipherKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// NewCipher creates a Cipher from the AES keys, which must be 16, 24 or 32
+// bytes long.  At least one key is required.
+func NewCipher(keys ...[]byte) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: at least one key is required", ErrInvalidKey)
+	}
+
+	var c Cipher
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidKey, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidKey, err)
+		}
+
+		sum := sha256.Sum256(key)
+		c.keys = append(c.keys, cipherKey{
+			id:   sum[:keyIDSize],
+			aead: aead,
+		})
+	}
+
+	return &c, nil
+}
+
+// Encrypt encrypts the plaintext with the current key.  The result is the
+// magic marker, the key id, the nonce and the sealed data.
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	key := c.keys[0]
+
+	header := make([]byte, 0, len(encryptedMagic)+keyIDSize)
+	header = append(header, encryptedMagic...)
+	header = append(header, key.id...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+key.aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+
+	return key.aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Decrypt returns the plaintext of data.  If the data is not encrypted it is
+// returned as is so files written before encryption was enabled can be
+// migrated.  The returned bool is true if the data was encrypted with the
+// current key; otherwise the data should be encrypted again.
+func (c *Cipher) Decrypt(data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, false, nil
+	}
+
+	headerSize := len(encryptedMagic) + keyIDSize
+	if len(data) < headerSize {
+		return nil, false, fmt.Errorf("%w: truncated header", ErrDecrypt)
+	}
+
+	header, rest := data[:headerSize], data[headerSize:]
+	id := header[len(encryptedMagic):]
+
+	for i, key := range c.keys {
+		if !bytes.Equal(id, key.id) {
+			continue
+		}
+
+		size := key.aead.NonceSize()
+		if len(rest) < size {
+			return nil, false, fmt.Errorf("%w: truncated nonce", ErrDecrypt)
+		}
+
+		plaintext, err := key.aead.Open(nil, rest[:size], rest[size:], header)
+		if err != nil {
+			return nil, false, errors.Join(ErrDecrypt, err)
+		}
+
+		return plaintext, i == 0, nil
+	}
+
+	return nil, false, ErrNoKey
+}
+
+// WriteFileEncrypted encrypts the data with the cipher and writes it with
+// WriteFileWithSHA256.
+func WriteFileEncrypted(name string, data []byte, perm fs.FileMode, c *Cipher) Option {
+	return OptionFunc(
+		func(f FS) error {
+			encrypted, err := c.Encrypt(data)
+			if err != nil {
+				return err
+			}
+
+			return WriteFileWithSHA256(name, encrypted, perm).Apply(f)
+		})
+}
+
+// ReadFileEncrypted reads the file with ReadFileWithSHA256 and decrypts it
+// with the cipher.  Plaintext files are read as is.  If stale is not nil it
+// is set to true when the file is plaintext or was encrypted with an old key
+// and should be written again.
+func ReadFileEncrypted(name string, data *[]byte, c *Cipher, stale *bool) Option {
+	return OptionFunc(
+		func(f FS) error {
+			var contents []byte
+			if err := ReadFileWithSHA256(name, &contents).Apply(f); err != nil {
+				return err
+			}
+
+			plaintext, current, err := c.Decrypt(contents)
+			if err != nil {
+				return fmt.Errorf("%w: '%s'", err, name)
+			}
+
+			*data = plaintext
+			if stale != nil {
+				*stale = !current
+			}
+
+			return nil
+		})
+}
+
+// ParseKey parses an AES key from the contents of a key file.  The key may be
+// the hex or base64 encoding of 16, 24 or 32 bytes, or the raw bytes.  The
+// encodings are tried first so a trailing newline doesn't change the key.
+func ParseKey(data []byte) ([]byte, error) {
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+
+	if validKeySize(len(data)) {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("%w: the key must be 16, 24 or 32 bytes", ErrInvalidKey)
+}
+
+// DeriveKey derives a 32 byte key from the device identity using HKDF-SHA256.
+// The identity is not a secret, so this only protects against casual
+// disclosure of the file; prefer a key file or keyring key where possible.
+func DeriveKey(identity ...string) ([]byte, error) {
+	if len(identity) == 0 {
+		return nil, fmt.Errorf("%w: no identity to derive the key from", ErrInvalidKey)
+	}
+
+	var secret bytes.Buffer
+	for _, id := range identity {
+		// Length prefix the parts so they can't run together.
+		fmt.Fprintf(&secret, "%d:%s", len(id), id)
+	}
+
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, secret.Bytes(), nil, []byte("xmidt-agent file encryption"))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
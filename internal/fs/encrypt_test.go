@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package fs_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xafs "github.com/xmidt-org/xmidt-agent/internal/fs"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{0x01}, 32)
+	newKey = bytes.Repeat([]byte{0x02}, 16)
+)
+
+func TestNewCipher(t *testing.T) {
+	tests := []struct {
+		description string
+		keys        [][]byte
+		expectErr   error
+	}{
+		{
+			description: "one key",
+			keys:        [][]byte{oldKey},
+		}, {
+			description: "several keys",
+			keys:        [][]byte{newKey, oldKey},
+		}, {
+			description: "no keys",
+			expectErr:   xafs.ErrInvalidKey,
+		}, {
+			description: "invalid key size",
+			keys:        [][]byte{[]byte("short")},
+			expectErr:   xafs.ErrInvalidKey,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			c, err := xafs.NewCipher(tc.keys...)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				assert.Nil(t, c)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, c)
+		})
+	}
+}
+
+func TestReadWriteFileEncrypted(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := []byte("secret token")
+
+	oldCipher, err := xafs.NewCipher(oldKey)
+	require.NoError(err)
+	rotated, err := xafs.NewCipher(newKey, oldKey)
+	require.NoError(err)
+	other, err := xafs.NewCipher(newKey)
+	require.NoError(err)
+
+	f := mem.New()
+
+	// A plaintext file is read as is and needs to be rewritten.
+	require.NoError(xafs.Operate(f, xafs.WriteFileWithSHA256("file", data, 0600)))
+
+	var got []byte
+	var stale bool
+	require.NoError(xafs.Operate(f, xafs.ReadFileEncrypted("file", &got, oldCipher, &stale)))
+	assert.Equal(data, got)
+	assert.True(stale)
+
+	// Once written encrypted, the data is not readable without the key.
+	require.NoError(xafs.Operate(f, xafs.WriteFileEncrypted("file", data, 0600, oldCipher)))
+
+	raw, err := f.ReadFile("file")
+	require.NoError(err)
+	assert.False(bytes.Contains(raw, data))
+
+	got, stale = nil, true
+	require.NoError(xafs.Operate(f, xafs.ReadFileEncrypted("file", &got, oldCipher, &stale)))
+	assert.Equal(data, got)
+	assert.False(stale)
+
+	// The old key still decrypts after rotation, but the file is stale.
+	got, stale = nil, false
+	require.NoError(xafs.Operate(f, xafs.ReadFileEncrypted("file", &got, rotated, &stale)))
+	assert.Equal(data, got)
+	assert.True(stale)
+
+	// Without the old key the file can't be read.
+	err = xafs.Operate(f, xafs.ReadFileEncrypted("file", &got, other, nil))
+	assert.ErrorIs(err, xafs.ErrNoKey)
+
+	// A missing file is an error.
+	err = xafs.Operate(f, xafs.ReadFileEncrypted("missing", &got, oldCipher, nil))
+	assert.Error(err)
+}
+
+func TestDecrypt(t *testing.T) {
+	c, err := xafs.NewCipher(oldKey)
+	require.NoError(t, err)
+
+	encrypted, err := c.Encrypt([]byte("data"))
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(encrypted)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		description string
+		data        []byte
+		expect      []byte
+		current     bool
+		expectErr   error
+	}{
+		{
+			description: "encrypted",
+			data:        encrypted,
+			expect:      []byte("data"),
+			current:     true,
+		}, {
+			description: "plaintext",
+			data:        []byte("data"),
+			expect:      []byte("data"),
+		}, {
+			description: "tampered",
+			data:        tampered,
+			expectErr:   xafs.ErrDecrypt,
+		}, {
+			description: "truncated header",
+			data:        encrypted[:8],
+			expectErr:   xafs.ErrDecrypt,
+		}, {
+			description: "truncated nonce",
+			data:        encrypted[:16],
+			expectErr:   xafs.ErrDecrypt,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			got, current, err := c.Decrypt(tc.data)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, got)
+			assert.Equal(t, tc.current, current)
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		description string
+		data        []byte
+		expected    []byte
+		expectErr   error
+	}{
+		{
+			description: "raw",
+			data:        oldKey,
+			expected:    oldKey,
+		}, {
+			description: "hex",
+			data:        []byte(hex.EncodeToString(oldKey) + "\n"),
+			expected:    oldKey,
+		}, {
+			description: "base64",
+			data:        []byte(base64.StdEncoding.EncodeToString(oldKey) + "\n"),
+			expected:    oldKey,
+		}, {
+			// 32 hex characters are also the size of a raw key.
+			description: "hex of 16 bytes without a newline",
+			data:        []byte(hex.EncodeToString(newKey)),
+			expected:    newKey,
+		}, {
+			description: "hex of 16 bytes with a newline",
+			data:        []byte(hex.EncodeToString(newKey) + "\n"),
+			expected:    newKey,
+		}, {
+			// 32 base64 characters are also the size of a raw key.
+			description: "base64 of 24 bytes without a newline",
+			data:        []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x03}, 24))),
+			expected:    bytes.Repeat([]byte{0x03}, 24),
+		}, {
+			description: "invalid",
+			data:        []byte("not a key"),
+			expectErr:   xafs.ErrInvalidKey,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			got, err := xafs.ParseKey(tc.data)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	assert := assert.New(t)
+
+	a, err := xafs.DeriveKey("mac:112233445566", "serial")
+	assert.NoError(err)
+	assert.Len(a, 32)
+
+	again, err := xafs.DeriveKey("mac:112233445566", "serial")
+	assert.NoError(err)
+	assert.Equal(a, again)
+
+	// The parts can't be shifted between each other.
+	shifted, err := xafs.DeriveKey("mac:112233445566s", "erial")
+	assert.NoError(err)
+	assert.NotEqual(a, shifted)
+
+	_, err = xafs.DeriveKey()
+	assert.ErrorIs(err, xafs.ErrInvalidKey)
+}
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package fs
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// KeyFromKeyring reads an AES key from the kernel keyring.  The key is a
+// "user" key with the description provided, found in the session or user
+// keyring.  The payload is parsed with ParseKey.
+func KeyFromKeyring(description string) ([]byte, error) {
+	var errs error
+	for _, ring := range []int{unix.KEY_SPEC_SESSION_KEYRING, unix.KEY_SPEC_USER_KEYRING} {
+		id, err := unix.KeyctlSearch(ring, "user", description, 0)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		// A zero length buffer returns the size of the payload.
+		size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidKey, err)
+		}
+
+		buf := make([]byte, size)
+		n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidKey, err)
+		}
+
+		return ParseKey(buf[:min(n, size)])
+	}
+
+	return nil, errors.Join(ErrInvalidKey, errs)
+}
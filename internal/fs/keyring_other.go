@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package fs
+
+import (
+	"errors"
+	"syscall"
+)
+
+// KeyFromKeyring is only supported on linux.
+func KeyFromKeyring(string) ([]byte, error) {
+	return nil, errors.Join(ErrInvalidKey, syscall.ENOTSUP)
+}
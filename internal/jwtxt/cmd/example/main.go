@@ -34,6 +34,7 @@ func main() {
 		jwtxt.Timeout(5*time.Second),
 		jwtxt.WithFetchListener(event.FetchListenerFunc(func(fe event.Fetch) {
 			fmt.Printf("           FQDN: %s\n", fe.FQDN)
+			fmt.Printf("       Resolver: %s\n", fe.Resolver)
 			fmt.Printf("         Server: %s\n", fe.Server)
 			fmt.Printf("          Found: %t\n", fe.Found)
 			fmt.Printf("        Timeout: %t\n", fe.Timeout)
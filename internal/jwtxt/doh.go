@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxDoHResponse is the largest DNS message that is read (RFC 8484 section 6).
+const maxDoHResponse = 65535
+
+// dohContentType is the media type of the DNS messages (RFC 8484 section 6).
+const dohContentType = "application/dns-message"
+
+var errDoHFailed = errors.New("dns over https query failed")
+
+// DoHResolver is a Resolver that uses DNS-over-HTTPS (RFC 8484).  It is useful
+// when the local DNS servers block or truncate large TXT records.
+type DoHResolver struct {
+	// URL is the URL of the DoH server, for example
+	// "https://cloudflare-dns.com/dns-query".
+	URL string
+
+	// Client is the HTTP client used to query the server.  If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+var _ Resolver = DoHResolver{}
+
+// String returns the URL of the server, which is used to report the resolver
+// in fetch events.
+func (d DoHResolver) String() string {
+	return d.URL
+}
+
+// LookupTXT returns the TXT records for the given name.  The strings of a
+// record are concatenated, matching net.Resolver.
+func (d DoHResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid dns over https url '%s'", ErrInvalidInput, d.URL)
+	}
+
+	query, err := dohQuery(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:         err.Error(),
+			Name:        name,
+			Server:      u.Host,
+			IsTimeout:   ctx.Err() != nil,
+			IsTemporary: true,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{
+			Err:         fmt.Sprintf("%s: unexpected status %d", errDoHFailed, resp.StatusCode),
+			Name:        name,
+			Server:      u.Host,
+			IsTemporary: resp.StatusCode >= http.StatusInternalServerError,
+		}
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, err
+	}
+
+	return dohAnswer(buf, name, u.Host)
+}
+
+// dohQuery builds the DNS message asking for the TXT records of the name.
+func dohQuery(name string) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid name '%s'", ErrInvalidInput, name)
+	}
+
+	// The ID is 0 so responses are cache friendly (RFC 8484 section 4.1).
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	err = b.Question(dnsmessage.Question{
+		Name:  n,
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// dohAnswer extracts the TXT records from the DNS message.
+func dohAnswer(buf []byte, name, server string) ([]string, error) {
+	var p dnsmessage.Parser
+
+	hdr, err := p.Start(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDoHFailed, err)
+	}
+
+	switch hdr.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			Name:       name,
+			Server:     server,
+			IsNotFound: true,
+		}
+	default:
+		return nil, &net.DNSError{
+			Err:         fmt.Sprintf("%s: %s", errDoHFailed, hdr.RCode),
+			Name:        name,
+			Server:      server,
+			IsTemporary: hdr.RCode == dnsmessage.RCodeServerFailure,
+		}
+	}
+
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errDoHFailed, err)
+	}
+
+	var txts []string
+	for {
+		ah, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errDoHFailed, err)
+		}
+
+		if ah.Type != dnsmessage.TypeTXT || ah.Class != dnsmessage.ClassINET {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("%w: %w", errDoHFailed, err)
+			}
+			continue
+		}
+
+		txt, err := p.TXTResource()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errDoHFailed, err)
+		}
+		txts = append(txts, strings.Join(txt.TXT, ""))
+	}
+
+	if len(txts) == 0 {
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			Name:       name,
+			Server:     server,
+			IsNotFound: true,
+		}
+	}
+
+	return txts, nil
+}
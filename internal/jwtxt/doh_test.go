@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var randomTXT = []string{
+	"01:eyJhbGciOiJFUzI1NiIsInR5cCI6IkpXVCJ9.eyJlbmRwb2ludCI6I",
+	"02:mZhYnJpYy54bWlkdC5leGFtcGxlLm9yZyIsImV4cCI6MTY5MDAwMDA",
+	"03:wMH0.4ELQaJAcX67M0Me1ZjTAusZT3QZpiCj2WQATDCvgllnEN9g4R",
+	"04:xMeDqnqnYAE_GdzsXI_e9fAGI9o1QuIym7_zQ",
+}
+
+// dohServer answers TXT queries for the names in the zone like a DNS-over-HTTPS
+// server.
+func dohServer(t *testing.T, zone map[string][]string, status int) *httptest.Server {
+	return httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, dohContentType, r.Header.Get("Content-Type"))
+
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+
+			buf, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			var p dnsmessage.Parser
+			hdr, err := p.Start(buf)
+			require.NoError(t, err)
+			q, err := p.Question()
+			require.NoError(t, err)
+			assert.Equal(t, dnsmessage.TypeTXT, q.Type)
+
+			hdr.Response = true
+			txts, found := zone[q.Name.String()]
+			if !found {
+				hdr.RCode = dnsmessage.RCodeNameError
+			}
+
+			b := dnsmessage.NewBuilder(nil, hdr)
+			require.NoError(t, b.StartQuestions())
+			require.NoError(t, b.Question(q))
+			require.NoError(t, b.StartAnswers())
+			for _, txt := range txts {
+				// Split each record in two strings to check they are joined.
+				half := len(txt) / 2
+				require.NoError(t, b.TXTResource(
+					dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60},
+					dnsmessage.TXTResource{TXT: []string{txt[:half], txt[half:]}},
+				))
+			}
+			msg, err := b.Finish()
+			require.NoError(t, err)
+
+			w.Header().Set("Content-Type", dohContentType)
+			_, _ = w.Write(msg)
+		}))
+}
+
+func TestDoHResolver_LookupTXT(t *testing.T) {
+	zone := map[string][]string{
+		"112233445566.fabric.random.example.org.": randomTXT,
+	}
+
+	tests := []struct {
+		description string
+		name        string
+		url         string
+		status      int
+		expected    []string
+		notFound    bool
+		expectedErr error
+	}{
+		{
+			description: "found",
+			name:        "112233445566.fabric.random.example.org",
+			expected:    randomTXT,
+		}, {
+			description: "found with a trailing dot",
+			name:        "112233445566.fabric.random.example.org.",
+			expected:    randomTXT,
+		}, {
+			description: "not found",
+			name:        "665544332211.fabric.random.example.org",
+			notFound:    true,
+		}, {
+			description: "server error",
+			name:        "112233445566.fabric.random.example.org",
+			status:      http.StatusInternalServerError,
+		}, {
+			description: "not https",
+			name:        "112233445566.fabric.random.example.org",
+			url:         "http://dns.example.org/dns-query",
+			expectedErr: ErrInvalidInput,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			if tc.status == 0 {
+				tc.status = http.StatusOK
+			}
+
+			server := dohServer(t, zone, tc.status)
+			defer server.Close()
+
+			r := DoHResolver{
+				URL:    server.URL + "/dns-query",
+				Client: server.Client(),
+			}
+			if tc.url != "" {
+				r.URL = tc.url
+			}
+
+			got, err := r.LookupTXT(context.Background(), tc.name)
+
+			if tc.expected != nil {
+				assert.NoError(err)
+				assert.Equal(tc.expected, got)
+				return
+			}
+
+			assert.Error(err)
+			assert.Nil(got)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			var dnsError *net.DNSError
+			if assert.True(errors.As(err, &dnsError)) {
+				assert.Equal(tc.notFound, dnsError.IsNotFound)
+				assert.NotEmpty(dnsError.Server)
+			}
+		})
+	}
+}
+
+type failingResolver struct{}
+
+func (failingResolver) String() string { return "failing" }
+
+func (failingResolver) LookupTXT(context.Context, string) ([]string, error) {
+	return nil, &net.DNSError{Err: "truncated", IsTemporary: true}
+}
+
+func TestInstructions_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	server := dohServer(t, map[string][]string{
+		"112233445566.fabric.random.example.org.": randomTXT,
+	}, http.StatusOK)
+	defer server.Close()
+
+	doh := DoHResolver{
+		URL:    server.URL + "/dns-query",
+		Client: server.Client(),
+	}
+
+	then := func() time.Time { return time.Unix(1680000000, 0) }
+
+	var events []event.Fetch
+	ins, err := New(
+		BaseURL("https://blocked.example.org", "https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseResolver(failingResolver{}, doh),
+		UseNowFunc(then),
+		WithFetchListener(event.FetchListenerFunc(
+			func(fe event.Fetch) {
+				events = append(events, fe)
+			})),
+	)
+	require.NoError(err)
+	ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(then))
+
+	endpoint, err := ins.Endpoint(context.Background())
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+
+	// Every base url is tried with every resolver until one works.
+	require.Len(events, 4)
+	tried := []struct {
+		fqdn     string
+		resolver string
+	}{
+		{"112233445566.blocked.example.org", "failing"},
+		{"112233445566.blocked.example.org", doh.URL},
+		{"112233445566.fabric.random.example.org", "failing"},
+		{"112233445566.fabric.random.example.org", doh.URL},
+	}
+	for i, want := range tried {
+		assert.Equal(want.fqdn, events[i].FQDN)
+		assert.Equal(want.resolver, events[i].Resolver)
+	}
+	for _, fe := range events[:3] {
+		assert.Error(fe.Err)
+	}
+	assert.NoError(events[3].Err)
+	assert.True(events[3].Found)
+	assert.Equal("fabric.xmidt.example.org", events[3].Endpoint)
+}
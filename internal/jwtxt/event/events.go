@@ -9,9 +9,17 @@ import (
 
 // Fetch is an event that is emitted when a TXT record fetch is attempted.
 type Fetch struct {
+	// Origin is where the TXT record came from: "dns" for a lookup or "fs"
+	// for the copy kept in local storage.
+	Origin string
+
 	// FQDN is the fully qualified domain name of the TXT record.
 	FQDN string
 
+	// Resolver is the resolver that was used.  It is "system" for the system
+	// resolver and the URL of the server for a DNS-over-HTTPS resolver.
+	Resolver string
+
 	// Server is the DNS server that was queried.
 	Server string
 
@@ -57,3 +65,47 @@ type FetchListenerFunc func(Fetch)
 func (f FetchListenerFunc) OnFetchEvent(fe Fetch) {
 	f(fe)
 }
+
+// KeySetFetch is an event that is emitted when a key set fetch is attempted.
+type KeySetFetch struct {
+	// Origin is where the key set came from: "http" for the JWKS URL or "fs"
+	// for the copy kept in local storage.
+	Origin string
+
+	// URL is the url the key set was fetched from.
+	URL string
+
+	// At holds the time when the fetch request was made.
+	At time.Time
+
+	// Duration is the time waited for the response.
+	Duration time.Duration
+
+	// StatusCode is the http status code of the response.
+	StatusCode int
+
+	// Keys is the number of signing keys in the key set.
+	Keys int
+
+	// IssuedAt is when the key set was issued.
+	IssuedAt time.Time
+
+	// Expiration is the expiration time of the key set.
+	Expiration time.Time
+
+	// Err indicates whether an error occurred during the fetch.
+	Err error
+}
+
+// KeySetFetchListener is a sink for key set fetch events.
+type KeySetFetchListener interface {
+	OnKeySetFetchEvent(KeySetFetch)
+}
+
+// KeySetFetchListenerFunc is a function type that implements
+// KeySetFetchListener.
+type KeySetFetchListenerFunc func(KeySetFetch)
+
+func (f KeySetFetchListenerFunc) OnKeySetFetchEvent(e KeySetFetch) {
+	f(e)
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Record is a TXT record that has been reassembled and checked, for
+// diagnosing redirection problems.  Fields are filled in as far as the
+// record could be processed.
+type Record struct {
+	// FQDN and Resolver are where the record was looked up.  They are empty
+	// if the record was provided.
+	FQDN     string
+	Resolver string
+
+	// Lines are the lines of the TXT record.
+	Lines []string
+
+	// Token is the JWT reassembled from the lines.
+	Token string
+
+	// Header is the JWT header.
+	Header map[string]any
+
+	// Payload is the JWT payload, even if the JWT could not be verified.
+	Payload []byte
+
+	// Verified is true if the JWT is valid.
+	Verified bool
+
+	// Expiration is when the JWT expires, even if it could not be verified.
+	Expiration time.Time
+
+	// Redirect is the connection guidance of the valid JWT.
+	Redirect Redirect
+
+	// Err is why the record could not be looked up or is not valid.
+	Err error
+}
+
+// Decode reassembles the TXT record lines and verifies the JWT with the
+// configured keys and algorithms.  The current instructions are not changed.
+// The returned error is the same as the Err field of the record.
+func (ins *Instructions) Decode(lines []string) (Record, error) {
+	rec := Record{
+		Lines: lines,
+		Token: ins.reassemble(lines),
+	}
+
+	parser := jwt.NewParser()
+
+	var claims jwt.RegisteredClaims
+	token, parts, err := parser.ParseUnverified(rec.Token, &claims)
+	if err != nil {
+		rec.Err = err
+		return rec, err
+	}
+
+	rec.Header = token.Header
+	rec.Payload, _ = parser.DecodeSegment(parts[1])
+	if claims.ExpiresAt != nil {
+		rec.Expiration = claims.ExpiresAt.Time
+	}
+
+	valid, err := ins.validate(rec.Token)
+	if err != nil {
+		rec.Err = err
+		return rec, err
+	}
+
+	rec.Verified = true
+	rec.Payload = valid.payload
+	rec.Redirect = valid.redirect
+	return rec, nil
+}
+
+// Lookup looks up the TXT record with each resolver for each base URL, in
+// order, until a valid record is found.  A record is returned for each
+// attempt.  The current instructions are not changed.
+func (ins *Instructions) Lookup(ctx context.Context) ([]Record, error) {
+	var recs []Record
+	var errs []error
+	for _, fqdn := range ins.fqdns {
+		for _, resolver := range ins.resolvers {
+			rec := ins.lookupOne(ctx, fqdn, resolver)
+			recs = append(recs, rec)
+			if rec.Err == nil {
+				return recs, nil
+			}
+			errs = append(errs, rec.Err)
+		}
+	}
+
+	return recs, errors.Join(errs...)
+}
+
+func (ins *Instructions) lookupOne(ctx context.Context, fqdn string, resolver Resolver) Record {
+	ctx, cancel := context.WithTimeout(ctx, ins.timeout)
+	defer cancel()
+
+	lines, err := resolver.LookupTXT(ctx, fqdn)
+	if err != nil {
+		return Record{
+			FQDN:     fqdn,
+			Resolver: resolverName(resolver),
+			Err:      err,
+		}
+	}
+
+	rec, _ := ins.Decode(lines)
+	rec.FQDN = fqdn
+	rec.Resolver = resolverName(resolver)
+	return rec
+}
+
+// FetchKeySet fetches the signed key set from the JWKS URL now, if there is
+// one, so the fetched keys can be used without starting the background
+// fetching.
+func (ins *Instructions) FetchKeySet(ctx context.Context) error {
+	if ins.jwksURL == "" {
+		return nil
+	}
+
+	return ins.fetchKeys(ctx)
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstructions_Decode(t *testing.T) {
+	t0 := time.Unix(1680000000, 0)
+	now := func() time.Time { return t0 }
+
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	valid := txtLines(signToken(t, key, "", jwt.MapClaims{
+		"endpoint": "fabric.xmidt.example.org",
+		"exp":      t0.Add(time.Hour).Unix(),
+	}))
+
+	tests := []struct {
+		description string
+		lines       []string
+		expected    Redirect
+		expiration  time.Time
+		decoded     bool
+		expectedErr error
+	}{
+		{
+			description: "valid",
+			lines:       valid,
+			expected:    Redirect{Endpoint: "fabric.xmidt.example.org"},
+			expiration:  t0.Add(time.Hour),
+			decoded:     true,
+		}, {
+			description: "expired",
+			lines: txtLines(signToken(t, key, "", jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"exp":      t0.Add(-time.Hour).Unix(),
+			})),
+			expiration:  t0.Add(-time.Hour),
+			decoded:     true,
+			expectedErr: jwt.ErrTokenExpired,
+		}, {
+			description: "signed by another key",
+			lines: txtLines(signToken(t, other, "", jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"exp":      t0.Add(time.Hour).Unix(),
+			})),
+			expiration:  t0.Add(time.Hour),
+			decoded:     true,
+			expectedErr: jwt.ErrTokenSignatureInvalid,
+		}, {
+			description: "missing a line",
+			lines:       valid[1:],
+			expectedErr: jwt.ErrTokenMalformed,
+		}, {
+			description: "no lines",
+			expectedErr: jwt.ErrTokenMalformed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ins, err := New(
+				BaseURL("https://fabric.random.example.org"),
+				DeviceID("mac:112233445566"),
+				Algorithms("ES256"),
+				publicECOption(),
+				UseNowFunc(now),
+			)
+			require.NoError(err)
+			ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(now))
+
+			got, err := ins.Decode(tc.lines)
+			assert.Equal(tc.lines, got.Lines)
+			assert.Equal(err, got.Err)
+
+			// The instructions are not changed.
+			assert.Empty(ins.current.token)
+
+			if tc.decoded {
+				assert.Equal("ES256", got.Header["alg"])
+				assert.Contains(string(got.Payload), "fabric.xmidt.example.org")
+				assert.Equal(tc.expiration, got.Expiration)
+			}
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				assert.False(got.Verified)
+				return
+			}
+
+			assert.NoError(err)
+			assert.True(got.Verified)
+			assert.Equal(tc.expected, got.Redirect)
+		})
+	}
+}
+
+func TestInstructions_Lookup(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	then := func() time.Time { return time.Unix(1680000000, 0) }
+
+	ins, err := New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseNowFunc(then),
+		UseResolver(failingResolver{}, &mockdns.Resolver{
+			Zones: map[string]mockdns.Zone{
+				"112233445566.fabric.random.example.org.": {
+					TXT: randomTXT,
+				},
+			},
+		}),
+	)
+	require.NoError(err)
+	ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(then))
+
+	recs, err := ins.Lookup(context.Background())
+	require.NoError(err)
+	require.Len(recs, 2)
+
+	var dnsError *net.DNSError
+	assert.ErrorAs(recs[0].Err, &dnsError)
+	assert.Equal("failing", recs[0].Resolver)
+
+	assert.NoError(recs[1].Err)
+	assert.True(recs[1].Verified)
+	assert.Equal("112233445566.fabric.random.example.org", recs[1].FQDN)
+	assert.Equal("fabric.xmidt.example.org", recs[1].Redirect.Endpoint)
+
+	// Nothing is found.
+	ins, err = New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		publicECOption(),
+		UseResolver(failingResolver{}),
+	)
+	require.NoError(err)
+
+	recs, err = ins.Lookup(context.Background())
+	assert.Error(err)
+	assert.Len(recs, 1)
+}
@@ -0,0 +1,490 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
+)
+
+var (
+	ErrInvalidJWKS = errors.New("invalid jwks")
+	ErrStaleJWKS   = errors.New("jwks is older than the current jwks")
+)
+
+const (
+	// maxJWKSResponse is the largest key set document that is read.
+	maxJWKSResponse = 1024 * 1024
+
+	// maxKeySets is the most key sets that are kept so the newest can be
+	// verified after a restart.  If more key sets are each signed by a key
+	// from the one before, the oldest are dropped and the newest can't be
+	// verified after a restart until it is fetched again.
+	maxKeySets = 16
+)
+
+// verificationKey is a public key along with the information needed to select
+// it for a JWT.
+type verificationKey struct {
+	// kid is the key id.  An empty kid matches any JWT.
+	kid string
+
+	// alg is the algorithm the key is for.  An empty alg matches any JWT.
+	alg string
+
+	// notBefore and expires are the window when the key can be used.  Zero
+	// values mean there is no limit.
+	notBefore time.Time
+	expires   time.Time
+
+	key jwt.VerificationKey
+}
+
+// matches returns true if the key can verify a JWT with the kid and alg at the
+// given time.
+func (k verificationKey) matches(kid, alg string, now time.Time) bool {
+	if k.kid != "" && kid != "" && k.kid != kid {
+		return false
+	}
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	if !k.notBefore.IsZero() && now.Before(k.notBefore) {
+		return false
+	}
+	if !k.expires.IsZero() && !now.Before(k.expires) {
+		return false
+	}
+	return true
+}
+
+// jwks is a JSON Web Key Set (RFC 7517).
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwk is a JSON Web Key (RFC 7517).  The "nbf" and "exp" members are not part
+// of the RFC; they limit when the key can be used so signing keys can be
+// rolled over.  Both are seconds since the epoch.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+
+	// EC and OKP keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	NotBefore int64 `json:"nbf,omitempty"`
+	Expires   int64 `json:"exp,omitempty"`
+}
+
+// parseJWKS converts the key set into verification keys.  Keys that are not
+// signing keys or have an unsupported type are skipped, as RFC 7517 requires.
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJWKS, err)
+	}
+
+	return set.verificationKeys()
+}
+
+func (set jwks) verificationKeys() ([]verificationKey, error) {
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%w: key '%s': %w", ErrInvalidJWKS, k.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+
+		vk := verificationKey{
+			kid: k.Kid,
+			alg: k.Alg,
+			key: key,
+		}
+		if k.NotBefore != 0 {
+			vk.notBefore = time.Unix(k.NotBefore, 0)
+		}
+		if k.Expires != 0 {
+			vk.expires = time.Unix(k.Expires, 0)
+		}
+		keys = append(keys, vk)
+	}
+
+	return keys, nil
+}
+
+// publicKey returns the public key, or nil if the key type is unsupported.
+func (k jwk) publicKey() (jwt.VerificationKey, error) {
+	switch k.Kty {
+	case "EC":
+		return k.ecKey()
+	case "RSA":
+		return k.rsaKey()
+	case "OKP":
+		return k.edKey()
+	}
+	return nil, nil
+}
+
+func (k jwk) ecKey() (jwt.VerificationKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	var size int
+	switch k.Crv {
+	case "P-256":
+		curve, check, size = elliptic.P256(), ecdh.P256(), 32
+	case "P-384":
+		curve, check, size = elliptic.P384(), ecdh.P384(), 48
+	case "P-521":
+		curve, check, size = elliptic.P521(), ecdh.P521(), 66
+	default:
+		return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+	}
+
+	x, err := decodeMember("x", k.X, size)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeMember("y", k.Y, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the point is on the curve.
+	point := append(append([]byte{4}, x...), y...)
+	if _, err = check.NewPublicKey(point); err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
+
+func (k jwk) rsaKey() (jwt.VerificationKey, error) {
+	n, err := decodeMember("n", k.N, 0)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeMember("e", k.E, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}, nil
+}
+
+func (k jwk) edKey() (jwt.VerificationKey, error) {
+	if k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+	}
+
+	x, err := decodeMember("x", k.X, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// decodeMember decodes the base64url value of a key member.  If size is not 0
+// the value must be exactly that many bytes.
+func decodeMember(name, value string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid '%s'", name)
+	}
+	if size != 0 && len(b) != size {
+		return nil, fmt.Errorf("invalid '%s' length %d", name, len(b))
+	}
+	return b, nil
+}
+
+// keyfunc selects the keys that can verify the JWT based on the kid and alg in
+// the JWT header and the time windows of the keys.
+func (ins *Instructions) keyfunc(t *jwt.Token) (any, error) {
+	ins.m.Lock()
+	keys := slices.Concat(ins.keys, ins.fetchedKeys)
+	ins.m.Unlock()
+
+	return ins.selectKeys(t, keys)
+}
+
+// configuredKeyfunc is like keyfunc, but only the configured keys are used.
+func (ins *Instructions) configuredKeyfunc(t *jwt.Token) (any, error) {
+	return ins.selectKeys(t, ins.keys)
+}
+
+func (ins *Instructions) selectKeys(t *jwt.Token, keys []verificationKey) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	alg := t.Method.Alg()
+	now := ins.now()
+
+	var set jwt.VerificationKeySet
+	for _, k := range keys {
+		if k.matches(kid, alg, now) {
+			set.Keys = append(set.Keys, k.key)
+		}
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("%w: kid '%s' alg '%s'", ErrNoKeysMatch, kid, alg)
+	}
+
+	return set, nil
+}
+
+// keySetClaims are the claims of the signed key set fetched from the JWKS URL.
+// The key set must be signed by a key that is already trusted.
+type keySetClaims struct {
+	jwks
+	jwt.RegisteredClaims
+}
+
+// runKeys fetches the key set from the JWKS URL at the refresh interval until
+// the context is canceled.
+func (ins *Instructions) runKeys(ctx context.Context) {
+	defer ins.wg.Done()
+
+	for {
+		timer := time.NewTimer(ins.jwksInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		_ = ins.fetchKeys(ctx)
+	}
+}
+
+// fetchKeys fetches the signed key set from the JWKS URL and, if it is valid,
+// replaces the previously fetched keys and stores the key set.
+func (ins *Instructions) fetchKeys(ctx context.Context) error {
+	fe := event.KeySetFetch{
+		Origin: "http",
+		URL:    ins.jwksURL,
+	}
+
+	// Don't wait forever if things are broken.
+	ctx, cancel := context.WithTimeout(ctx, ins.timeout)
+	defer cancel()
+
+	fe.At = time.Now()
+	body, err := ins.getKeySet(ctx, &fe)
+	fe.Duration = time.Since(fe.At)
+	if err != nil {
+		fe.Err = err
+		return ins.dispatchKeySet(fe)
+	}
+
+	fe.Err = ins.useKeySet(string(body), true, &fe)
+	return ins.dispatchKeySet(fe)
+}
+
+// useKeySet verifies the signed key set and, if it is valid and not older than
+// the current key set, replaces the previously fetched keys.  If store is true
+// the key set is also kept in the local storage.
+func (ins *Instructions) useKeySet(set string, store bool, fe *event.KeySetFetch) error {
+	opts := append(slices.Clone(ins.jwtOptions), jwt.WithExpirationRequired())
+	parser := jwt.NewParser(opts...)
+
+	ins.m.Lock()
+	newest := len(ins.keySets) > 0 && ins.keySets[len(ins.keySets)-1] == set
+	ins.m.Unlock()
+
+	var claims keySetClaims
+	if newest {
+		// The newest key set was verified already and may have rotated out
+		// the key that signed it, so only the claims are checked again.
+		if _, _, err := parser.ParseUnverified(set, &claims); err != nil {
+			return err
+		}
+		if err := jwt.NewValidator(opts...).Validate(claims); err != nil {
+			return err
+		}
+	} else if _, err := parser.ParseWithClaims(set, &claims, ins.keyfunc); err != nil {
+		return err
+	}
+
+	keys, err := claims.verificationKeys()
+	if err != nil {
+		return err
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	fe.Keys = len(keys)
+	fe.IssuedAt = issuedAt
+	fe.Expiration = claims.ExpiresAt.Time
+
+	// A key set signed by a configured key can be verified on its own, but
+	// one signed by a fetched key needs the key sets before it.
+	_, err = parser.ParseWithClaims(set, &keySetClaims{}, ins.configuredKeyfunc)
+	rooted := err == nil
+
+	ins.m.Lock()
+	defer ins.m.Unlock()
+
+	if issuedAt.Before(ins.fetchedKeysIssuedAt) {
+		return fmt.Errorf("%w: issued at %s", ErrStaleJWKS, issuedAt)
+	}
+
+	ins.fetchedKeys = keys
+	ins.fetchedKeysIssuedAt = issuedAt
+
+	switch {
+	case rooted:
+		ins.keySets = []string{set}
+	case !newest:
+		ins.keySets = append(ins.keySets, set)
+		if len(ins.keySets) > maxKeySets {
+			ins.keySets = slices.Clone(ins.keySets[len(ins.keySets)-maxKeySets:])
+		}
+	}
+
+	if !store {
+		return nil
+	}
+
+	// A failure to store the key set doesn't make it invalid.
+	_ = ins.storeKeys()
+	return nil
+}
+
+// keySetFilename is where the key sets are stored, next to the JWT.
+func (ins *Instructions) keySetFilename() string {
+	return ins.filename + ".jwks"
+}
+
+// storeKeys writes the key sets to the local storage, if there is one, one per
+// line.  The caller must hold the lock.
+func (ins *Instructions) storeKeys() error {
+	if ins.fs == nil {
+		return nil
+	}
+
+	name := ins.keySetFilename()
+	return fs.Operate(ins.fs,
+		fs.WithPath(name, ins.perm),
+		fs.WriteFileWithSHA256(name, []byte(strings.Join(ins.keySets, "\n")), ins.perm))
+}
+
+// loadKeys reads the key sets from the local storage, if there is one, and
+// uses them if they are still valid.  The key sets are verified again, in the
+// order they were fetched, since each may be signed by a key from the one
+// before it.
+func (ins *Instructions) loadKeys() error {
+	if ins.fs == nil || ins.jwksURL == "" {
+		return nil
+	}
+
+	var buf []byte
+
+	name := ins.keySetFilename()
+	at := time.Now()
+	ins.m.Lock()
+	err := fs.Operate(ins.fs,
+		fs.WithPath(name, ins.perm),
+		fs.ReadFileWithSHA256(name, &buf))
+	ins.m.Unlock()
+
+	if err != nil {
+		return ins.dispatchKeySet(event.KeySetFetch{
+			Origin:   "fs",
+			URL:      ins.jwksURL,
+			At:       at,
+			Duration: time.Since(at),
+			Err:      err,
+		})
+	}
+
+	var errs []error
+	for _, set := range strings.Split(string(buf), "\n") {
+		fe := event.KeySetFetch{
+			Origin: "fs",
+			URL:    ins.jwksURL,
+			At:     at,
+		}
+		fe.Err = ins.useKeySet(set, false, &fe)
+		fe.Duration = time.Since(at)
+		errs = append(errs, ins.dispatchKeySet(fe))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (ins *Instructions) getKeySet(ctx context.Context, fe *event.KeySetFetch) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ins.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ins.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	fe.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrInvalidJWKS, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSResponse))
+}
+
+func (ins *Instructions) dispatchKeySet(fe event.KeySetFetch) error {
+	ins.keySetListeners.Visit(func(listener event.KeySetFetchListener) {
+		listener.OnKeySetFetchEvent(fe)
+	})
+	return fe.Err
+}
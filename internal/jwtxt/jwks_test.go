@@ -0,0 +1,586 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
+	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
+)
+
+// jwkOf returns the JWK of the public key.
+func jwkOf(t *testing.T, pub any, kid string) map[string]any {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	m := map[string]any{}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		m["kty"] = "EC"
+		m["crv"] = pub.Curve.Params().Name
+		m["x"] = enc(pub.X.FillBytes(make([]byte, size)))
+		m["y"] = enc(pub.Y.FillBytes(make([]byte, size)))
+	case *rsa.PublicKey:
+		m["kty"] = "RSA"
+		m["n"] = enc(pub.N.Bytes())
+		m["e"] = enc(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		m["kty"] = "OKP"
+		m["crv"] = "Ed25519"
+		m["x"] = enc(pub)
+	default:
+		require.FailNow(t, "unsupported key type")
+	}
+	if kid != "" {
+		m["kid"] = kid
+	}
+	return m
+}
+
+func jwksOf(t *testing.T, keys ...map[string]any) []byte {
+	buf, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return buf
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecPub, err := jwt.ParseECPublicKeyFromPEM([]byte(pemECPublic))
+	require.NoError(t, err)
+	rsaPub, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pemRSAPublic))
+	require.NoError(t, err)
+	edPub, err := jwt.ParseEdPublicKeyFromPEM([]byte(pemEdPublic))
+	require.NoError(t, err)
+
+	with := func(m map[string]any, kv ...any) map[string]any {
+		out := map[string]any{}
+		for k, v := range m {
+			out[k] = v
+		}
+		for i := 0; i < len(kv); i += 2 {
+			out[kv[i].(string)] = kv[i+1]
+		}
+		return out
+	}
+
+	ec := jwkOf(t, ecPub, "ec")
+
+	tests := []struct {
+		description string
+		set         []byte
+		expected    []verificationKey
+		expectedErr error
+	}{
+		{
+			description: "all key types",
+			set: jwksOf(t,
+				with(ec, "alg", "ES256", "nbf", 1680000000, "exp", 1690000000),
+				jwkOf(t, rsaPub, "rsa"),
+				jwkOf(t, edPub, "ed"),
+			),
+			expected: []verificationKey{
+				{
+					kid:       "ec",
+					alg:       "ES256",
+					notBefore: time.Unix(1680000000, 0),
+					expires:   time.Unix(1690000000, 0),
+					key:       ecPub,
+				},
+				{kid: "rsa", key: rsaPub},
+				{kid: "ed", key: edPub},
+			},
+		}, {
+			description: "skip encryption and unsupported keys",
+			set: jwksOf(t,
+				with(ec, "use", "enc"),
+				map[string]any{"kty": "oct", "k": "c2VjcmV0"},
+				with(ec, "use", "sig"),
+			),
+			expected: []verificationKey{
+				{kid: "ec", key: ecPub},
+			},
+		}, {
+			description: "empty",
+			set:         []byte(`{"keys":[]}`),
+			expected:    []verificationKey{},
+		}, {
+			description: "not json",
+			set:         []byte("invalid"),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "unsupported curve",
+			set:         jwksOf(t, with(ec, "crv", "P-192")),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "wrong coordinate length",
+			set:         jwksOf(t, with(ec, "x", "AQID")),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "point not on the curve",
+			set: jwksOf(t, with(ec,
+				"x", base64.RawURLEncoding.EncodeToString(make([]byte, 32)),
+				"y", base64.RawURLEncoding.EncodeToString(make([]byte, 32)))),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "invalid base64",
+			set:         jwksOf(t, with(ec, "y", "!!")),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "invalid rsa exponent",
+			set:         jwksOf(t, with(jwkOf(t, rsaPub, "rsa"), "e", "AQ")),
+			expectedErr: ErrInvalidJWKS,
+		}, {
+			description: "unsupported okp curve",
+			set:         jwksOf(t, with(jwkOf(t, edPub, "ed"), "crv", "X25519")),
+			expectedErr: ErrInvalidJWKS,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			got, err := parseJWKS(tc.set)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				assert.Nil(got)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tc.expected, got)
+		})
+	}
+}
+
+func TestInstructions_KeySelection(t *testing.T) {
+	t0 := time.Unix(1680000000, 0)
+
+	oldKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// The old key expires at t0 and the new key is usable an hour before.
+	oldJWK := jwkOf(t, &oldKey.PublicKey, "old")
+	oldJWK["exp"] = t0.Unix()
+	newJWK := jwkOf(t, &newKey.PublicKey, "new")
+	newJWK["nbf"] = t0.Add(-time.Hour).Unix()
+	set := jwksOf(t, oldJWK, newJWK)
+
+	tests := []struct {
+		description string
+		now         time.Time
+		key         any
+		kid         string
+		expectedErr error
+	}{
+		{
+			description: "old key before it expires",
+			now:         t0.Add(-2 * time.Hour),
+			key:         oldKey,
+			kid:         "old",
+		}, {
+			description: "old key after it expires",
+			now:         t0,
+			key:         oldKey,
+			kid:         "old",
+			expectedErr: ErrNoKeysMatch,
+		}, {
+			description: "new key before it is valid",
+			now:         t0.Add(-2 * time.Hour),
+			key:         newKey,
+			kid:         "new",
+			expectedErr: ErrNoKeysMatch,
+		}, {
+			description: "new key during the overlap",
+			now:         t0.Add(-time.Minute),
+			key:         newKey,
+			kid:         "new",
+		}, {
+			description: "new key",
+			now:         t0,
+			key:         newKey,
+			kid:         "new",
+		}, {
+			description: "no kid",
+			now:         t0,
+			key:         newKey,
+		}, {
+			description: "unknown kid",
+			now:         t0,
+			key:         newKey,
+			kid:         "unknown",
+			expectedErr: ErrNoKeysMatch,
+		}, {
+			description: "kid of another key",
+			now:         t0.Add(-2 * time.Hour),
+			key:         newKey,
+			kid:         "old",
+			expectedErr: jwt.ErrTokenSignatureInvalid,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			now := func() time.Time { return tc.now }
+
+			txt := signToken(t, tc.key, tc.kid, jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"exp":      t0.Add(24 * time.Hour).Unix(),
+			})
+
+			ins, err := New(
+				BaseURL("https://fabric.random.example.org"),
+				DeviceID("mac:112233445566"),
+				Algorithms("ES256"),
+				WithJWKS(set),
+				UseNowFunc(now),
+				UseResolver(&mockdns.Resolver{
+					Zones: map[string]mockdns.Zone{
+						"112233445566.fabric.random.example.org.": {
+							TXT: txtLines(txt),
+						},
+					},
+				}),
+			)
+			require.NoError(err)
+			ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(now))
+
+			endpoint, err := ins.Endpoint(context.Background())
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal("fabric.xmidt.example.org", endpoint)
+		})
+	}
+}
+
+func TestInstructions_JWKSURL(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	t0 := time.Unix(1680000000, 0)
+	now := func() time.Time { return t0 }
+
+	trusted, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(err)
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	keySet := func(key any, issuedAt time.Time) string {
+		return signToken(t, key, "", jwt.MapClaims{
+			"keys": []map[string]any{jwkOf(t, &next.PublicKey, "next")},
+			"iat":  issuedAt.Unix(),
+			"exp":  issuedAt.Add(24 * time.Hour).Unix(),
+		})
+	}
+
+	var m sync.Mutex
+	status := http.StatusOK
+	body := ""
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+	defer server.Close()
+
+	serve := func(s int, b string) {
+		m.Lock()
+		defer m.Unlock()
+		status, body = s, b
+	}
+
+	var events []event.KeySetFetch
+	ins, err := New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		JWKSURL(server.URL, time.Hour),
+		HTTPClient(server.Client()),
+		UseNowFunc(now),
+		UseResolver(&mockdns.Resolver{
+			Zones: map[string]mockdns.Zone{
+				"112233445566.fabric.random.example.org.": {
+					TXT: txtLines(signToken(t, next, "next", jwt.MapClaims{
+						"endpoint": "fabric.xmidt.example.org",
+						"exp":      t0.Add(time.Hour).Unix(),
+					})),
+				},
+			},
+		}),
+		WithKeySetFetchListener(event.KeySetFetchListenerFunc(
+			func(e event.KeySetFetch) {
+				events = append(events, e)
+			})),
+	)
+	require.NoError(err)
+	ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(now))
+
+	ctx := context.Background()
+
+	// The key set isn't available.
+	serve(http.StatusInternalServerError, "")
+	assert.ErrorIs(ins.fetchKeys(ctx), ErrInvalidJWKS)
+	require.Len(events, 1)
+	assert.Equal(server.URL, events[0].URL)
+	assert.Equal(http.StatusInternalServerError, events[0].StatusCode)
+
+	// A key set that isn't signed by a trusted key is rejected.
+	serve(http.StatusOK, keySet(untrusted, t0))
+	assert.Error(ins.fetchKeys(ctx))
+
+	// Only the configured key, which has no kid, is tried.
+	_, err = ins.Endpoint(ctx)
+	assert.ErrorIs(err, jwt.ErrTokenSignatureInvalid)
+
+	// The signed key set adds the next key.
+	serve(http.StatusOK, keySet(trusted, t0))
+	require.NoError(ins.fetchKeys(ctx))
+	require.Len(events, 3)
+	assert.Equal(1, events[2].Keys)
+	assert.Equal(t0, events[2].IssuedAt)
+	assert.Equal(t0.Add(24*time.Hour), events[2].Expiration)
+
+	endpoint, err := ins.Endpoint(ctx)
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+
+	// An older key set can't replace a newer one.
+	serve(http.StatusOK, keySet(trusted, t0.Add(-time.Hour)))
+	assert.ErrorIs(ins.fetchKeys(ctx), ErrStaleJWKS)
+
+	// The next key can sign the key set that follows.
+	serve(http.StatusOK, keySet(next, t0.Add(time.Hour)))
+	assert.NoError(ins.fetchKeys(ctx))
+}
+
+func TestInstructions_JWKSURLStart(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	t0 := time.Unix(1680000000, 0)
+	now := func() time.Time { return t0 }
+
+	trusted, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(err)
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	set := signToken(t, trusted, "", jwt.MapClaims{
+		"keys": []map[string]any{jwkOf(t, &next.PublicKey, "next")},
+		"iat":  t0.Unix(),
+		"exp":  t0.Add(24 * time.Hour).Unix(),
+	})
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(set))
+		}))
+	defer server.Close()
+
+	var m sync.Mutex
+	var keySets, found int
+	ins, err := New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		JWKSURL(server.URL, 10*time.Millisecond),
+		UseNowFunc(now),
+		UseResolver(&mockdns.Resolver{
+			Zones: map[string]mockdns.Zone{
+				"112233445566.fabric.random.example.org.": {
+					TXT: txtLines(signToken(t, next, "next", jwt.MapClaims{
+						"endpoint": "fabric.xmidt.example.org",
+						"exp":      t0.Add(time.Hour).Unix(),
+					})),
+				},
+			},
+		}),
+		WithKeySetFetchListener(event.KeySetFetchListenerFunc(
+			func(e event.KeySetFetch) {
+				m.Lock()
+				defer m.Unlock()
+				assert.NoError(e.Err)
+				keySets++
+			})),
+		WithFetchListener(event.FetchListenerFunc(
+			func(fe event.Fetch) {
+				m.Lock()
+				defer m.Unlock()
+				if fe.Err == nil {
+					found++
+				}
+			})),
+	)
+	require.NoError(err)
+	ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(now))
+
+	ins.Start()
+	defer ins.Stop()
+
+	// The key set is fetched before the JWT, and then again periodically.
+	assert.Eventually(func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return keySets > 2 && found > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInstructions_JWKSURLStorage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	t0 := time.Unix(1680000000, 0)
+	now := func() time.Time { return t0 }
+
+	trusted, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(err)
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	later, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	keySet := func(signer any, key *ecdsa.PrivateKey, kid string, issuedAt time.Time) string {
+		return signToken(t, signer, "", jwt.MapClaims{
+			"keys": []map[string]any{jwkOf(t, &key.PublicKey, kid)},
+			"iat":  issuedAt.Unix(),
+			"exp":  issuedAt.Add(24 * time.Hour).Unix(),
+		})
+	}
+
+	var m sync.Mutex
+	status := http.StatusOK
+	body := ""
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+	defer server.Close()
+
+	serve := func(s int, b string) {
+		m.Lock()
+		defer m.Unlock()
+		status, body = s, b
+	}
+
+	storage := mem.New(mem.WithDir(".", 0755))
+	opts := []Option{
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		JWKSURL(server.URL, time.Hour),
+		HTTPClient(server.Client()),
+		UseNowFunc(now),
+		LocalStorage(storage, "jwtxt.jwt", 0600),
+	}
+
+	first, err := New(append(opts,
+		UseResolver(&mockdns.Resolver{
+			Zones: map[string]mockdns.Zone{
+				"112233445566.fabric.random.example.org.": {
+					TXT: txtLines(signToken(t, later, "later", jwt.MapClaims{
+						"endpoint": "fabric.xmidt.example.org",
+						"exp":      t0.Add(time.Hour).Unix(),
+					})),
+				},
+			},
+		}))...)
+	require.NoError(err)
+	first.jwtOptions = append(first.jwtOptions, jwt.WithTimeFunc(now))
+
+	ctx := context.Background()
+
+	// The key rotates from the trusted key to next and then to later.
+	serve(http.StatusOK, keySet(trusted, next, "next", t0))
+	require.NoError(first.fetchKeys(ctx))
+	serve(http.StatusOK, keySet(next, later, "later", t0.Add(time.Minute)))
+	require.NoError(first.fetchKeys(ctx))
+	assert.Len(first.keySets, 2)
+
+	_, err = first.Endpoint(ctx)
+	require.NoError(err)
+
+	// The same key set fetched again isn't kept twice.
+	require.NoError(first.fetchKeys(ctx))
+	assert.Len(first.keySets, 2)
+
+	// A key set signed by the trusted key replaces the chain.
+	serve(http.StatusOK, keySet(trusted, later, "later", t0.Add(2*time.Minute)))
+	require.NoError(first.fetchKeys(ctx))
+	assert.Len(first.keySets, 1)
+	serve(http.StatusOK, keySet(later, later, "later", t0.Add(3*time.Minute)))
+	require.NoError(first.fetchKeys(ctx))
+	assert.Len(first.keySets, 2)
+
+	// After a restart with neither the key set nor the DNS available, the
+	// stored key sets verify the stored JWT as soon as Start returns.
+	serve(http.StatusInternalServerError, "")
+
+	var loaded []event.KeySetFetch
+	second, err := New(append(opts,
+		UseResolver(failingResolver{}),
+		WithKeySetFetchListener(event.KeySetFetchListenerFunc(
+			func(e event.KeySetFetch) {
+				m.Lock()
+				defer m.Unlock()
+				if e.Origin == "fs" {
+					loaded = append(loaded, e)
+				}
+			})),
+	)...)
+	require.NoError(err)
+	second.jwtOptions = append(second.jwtOptions, jwt.WithTimeFunc(now))
+
+	second.Start()
+	defer second.Stop()
+
+	endpoint, err := second.Endpoint(ctx)
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+
+	m.Lock()
+	defer m.Unlock()
+	require.Len(loaded, 2)
+	for _, e := range loaded {
+		assert.NoError(e.Err)
+		assert.Equal(1, e.Keys)
+	}
+}
@@ -5,11 +5,14 @@ package jwtxt
 
 import (
 	"fmt"
+	iofs "io/fs"
+	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
 )
 
@@ -34,19 +37,26 @@ func (f fetchListener) apply(ins *Instructions) error {
 	return nil
 }
 
-// UseResolver sets the resolver to use for DNS queries.
-func UseResolver(resolver Resolver) Option {
+// UseResolver sets the resolvers to use for DNS queries.  The resolvers are
+// tried in order for each base URL until a valid record is found.  The default
+// is the system resolver.
+func UseResolver(resolvers ...Resolver) Option {
 	return &useResolver{
-		resolver: resolver,
+		resolvers: resolvers,
 	}
 }
 
 type useResolver struct {
-	resolver Resolver
+	resolvers []Resolver
 }
 
 func (u useResolver) apply(ins *Instructions) error {
-	ins.resolver = u.resolver
+	ins.resolvers = nil
+	for _, r := range u.resolvers {
+		if r != nil {
+			ins.resolvers = append(ins.resolvers, r)
+		}
+	}
 	return nil
 }
 
@@ -148,30 +158,127 @@ func (p pemOption) apply(ins *Instructions) error {
 			return fmt.Errorf("%w: invalid pem", ErrInvalidInput)
 		}
 
-		ins.publicKeys.Keys = append(ins.publicKeys.Keys, key)
+		ins.keys = append(ins.keys, verificationKey{key: key})
+	}
+
+	return nil
+}
+
+// WithJWKS adds the keys from JSON Web Key Sets (RFC 7517) to the list of keys
+// to use for verification.  The key with the kid of a JWT is used to verify
+// it, and keys with "nbf" or "exp" members (seconds since the epoch) are only
+// used during that window, so signing keys can be rolled over.
+func WithJWKS(sets ...[]byte) Option {
+	return &jwksOption{
+		sets: sets,
+	}
+}
+
+type jwksOption struct {
+	sets [][]byte
+}
+
+func (j jwksOption) apply(ins *Instructions) error {
+	for _, set := range j.sets {
+		keys, err := parseJWKS(set)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		ins.keys = append(ins.keys, keys...)
+	}
+	return nil
+}
+
+// JWKSURL sets the url to fetch a signed key set from every interval once
+// started.  The response is a JWT with a "keys" claim holding the key set, and
+// it must be signed by a key that is already trusted.  The fetched keys are
+// used along with the configured keys, and replace the previously fetched
+// keys.  An interval of 0 means use the default.  A negative interval is
+// invalid.
+func JWKSURL(u string, interval time.Duration) Option {
+	return &jwksURL{
+		url:      u,
+		interval: interval,
+	}
+}
+
+type jwksURL struct {
+	url      string
+	interval time.Duration
+}
+
+func (j jwksURL) apply(ins *Instructions) error {
+	if _, err := url.ParseRequestURI(j.url); err != nil {
+		return fmt.Errorf("%w: invalid jwks url %s", ErrInvalidInput, j.url)
+	}
+	if j.interval < 0 {
+		return fmt.Errorf("%w: jwks interval is invalid %s", ErrInvalidInput, j.interval)
 	}
+	if j.interval == 0 {
+		j.interval = DefaultJWKSInterval
+	}
+	ins.jwksURL = j.url
+	ins.jwksInterval = j.interval
+	return nil
+}
 
+// HTTPClient sets the HTTP client used to fetch the key set.  The default is
+// http.DefaultClient.
+func HTTPClient(client *http.Client) Option {
+	return &httpClient{
+		client: client,
+	}
+}
+
+type httpClient struct {
+	client *http.Client
+}
+
+func (h httpClient) apply(ins *Instructions) error {
+	if h.client == nil {
+		h.client = http.DefaultClient
+	}
+	ins.client = h.client
 	return nil
 }
 
-// BaseURL sets the base URL to use for the endpoint.
-func BaseURL(url string) Option {
+// WithKeySetFetchListener adds a listener for key set fetch events.
+func WithKeySetFetchListener(listener event.KeySetFetchListener) Option {
+	return &keySetFetchListener{
+		listener: listener,
+	}
+}
+
+type keySetFetchListener struct {
+	listener event.KeySetFetchListener
+}
+
+func (k keySetFetchListener) apply(ins *Instructions) error {
+	ins.keySetListeners.Add(k.listener)
+	return nil
+}
+
+// BaseURL adds the base URLs to use for the endpoint.  The base URLs are tried
+// in the order they are added until a valid record is found.
+func BaseURL(urls ...string) Option {
 	return &baseURL{
-		url: url,
+		urls: urls,
 	}
 }
 
 type baseURL struct {
-	url string
+	urls []string
 }
 
 func (b baseURL) apply(ins *Instructions) error {
-	u, err := url.ParseRequestURI(b.url)
-	if err != nil {
-		return fmt.Errorf("%w: invalid url %s", ErrInvalidInput, b.url)
-	}
+	for _, s := range b.urls {
+		u, err := url.ParseRequestURI(s)
+		if err != nil {
+			return fmt.Errorf("%w: invalid url %s", ErrInvalidInput, s)
+		}
 
-	ins.baseURL = u.Hostname()
+		ins.baseURLs = append(ins.baseURLs, u.Hostname())
+	}
 	return nil
 }
 
@@ -194,3 +301,79 @@ func (i idOption) apply(ins *Instructions) error {
 	ins.id = id.ID()
 	return nil
 }
+
+// LocalStorage is the local storage used to keep the most recent valid JWT so
+// the instructions survive a restart.  The stored JWT is verified again when
+// it is loaded by Start.  If a JWKS URL is used, the fetched key sets are
+// kept next to the JWT in the file with the ".jwks" suffix added, so a JWT
+// signed by a rotated key can still be verified after a restart.
+//
+// The filename (and path) is relative to the provided filesystem.
+func LocalStorage(fs fs.FS, filename string, perm iofs.FileMode) Option {
+	return &localStorage{
+		fs:       fs,
+		filename: filename,
+		perm:     perm,
+	}
+}
+
+type localStorage struct {
+	fs       fs.FS
+	filename string
+	perm     iofs.FileMode
+}
+
+func (l localStorage) apply(ins *Instructions) error {
+	ins.fs = l.fs
+	ins.filename = l.filename
+	ins.perm = l.perm
+	return nil
+}
+
+// RefetchPercent sets the percentage of the remaining lifetime of the
+// instructions to wait before fetching them again in the background.  0 means
+// use the default.  Values outside of 0 to 100 are invalid.
+func RefetchPercent(percent float64) Option {
+	return &refetchPercent{
+		percent: percent,
+	}
+}
+
+type refetchPercent struct {
+	percent float64
+}
+
+func (r refetchPercent) apply(ins *Instructions) error {
+	if r.percent < 0.0 || r.percent > 100.0 {
+		return fmt.Errorf("%w: refetch percent is invalid %f", ErrInvalidInput, r.percent)
+	}
+	if r.percent == 0.0 {
+		r.percent = DefaultRefetchPercent
+	}
+	ins.refetchPercent = r.percent
+	return nil
+}
+
+// RetryInterval sets the time to wait before fetching the instructions again
+// after a failed background fetch.  0 means use the default.  A negative
+// interval is invalid.
+func RetryInterval(interval time.Duration) Option {
+	return &retryInterval{
+		interval: interval,
+	}
+}
+
+type retryInterval struct {
+	interval time.Duration
+}
+
+func (r retryInterval) apply(ins *Instructions) error {
+	if r.interval < 0 {
+		return fmt.Errorf("%w: retry interval is invalid %s", ErrInvalidInput, r.interval)
+	}
+	if r.interval == 0 {
+		r.interval = DefaultRetryInterval
+	}
+	ins.retryInterval = r.interval
+	return nil
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xmidt-org/retry"
+)
+
+// IP family preferences.
+const (
+	IPv4 = "ipv4"
+	IPv6 = "ipv6"
+)
+
+// Redirect is the connection guidance from a valid JWT.  Only the Endpoint is
+// required; the other fields are empty unless the JWT includes them.
+type Redirect struct {
+	// Endpoint is the endpoint to connect to.
+	Endpoint string
+
+	// Endpoints are several endpoints to spread connections across.
+	Endpoints []Endpoint
+
+	// URLPath is the websocket url path to use with the endpoints.
+	URLPath string
+
+	// IPFamily is the preferred address family, IPv4 or IPv6.
+	IPFamily string
+
+	// Retry is the retry policy to use for the connection.
+	Retry *retry.Config
+}
+
+// Endpoint is one of several endpoints in a Redirect.
+type Endpoint struct {
+	// URL is the url of the endpoint.
+	URL string
+
+	// Priority orders the endpoints; lower values are preferred.
+	Priority int
+
+	// Weight is the relative share of connections an endpoint gets among the
+	// endpoints with the same priority.
+	Weight int
+}
+
+// preferred returns the endpoint to use when only one can be used: the
+// Endpoint, or the first of the Endpoints with the lowest priority.
+func (r Redirect) preferred() string {
+	if r.Endpoint != "" || len(r.Endpoints) == 0 {
+		return r.Endpoint
+	}
+
+	best := r.Endpoints[0]
+	for _, ep := range r.Endpoints[1:] {
+		if ep.Priority < best.Priority {
+			best = ep
+		}
+	}
+	return best.URL
+}
+
+// customClaims are the claims of the JWT found in the TXT record.  For
+// example:
+//
+//	{
+//	  "endpoint": "fabric.example.org",
+//	  "endpoints": [
+//	    {"url": "https://a.example.org", "priority": 1, "weight": 3},
+//	    {"url": "https://b.example.org", "priority": 1, "weight": 1}
+//	  ],
+//	  "connection": {
+//	    "url_path": "/api/v2/device",
+//	    "ip_family": "ipv6",
+//	    "retry": {"interval": "1s", "multiplier": 2, "jitter": 0.1, "max_interval": "5m"}
+//	  },
+//	  "exp": 1690000000
+//	}
+type customClaims struct {
+	Endpoint   string           `json:"endpoint"`
+	Endpoints  []endpointClaim  `json:"endpoints,omitempty"`
+	Connection *connectionClaim `json:"connection,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type endpointClaim struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+}
+
+type connectionClaim struct {
+	URLPath  string      `json:"url_path,omitempty"`
+	IPFamily string      `json:"ip_family,omitempty"`
+	Retry    *retryClaim `json:"retry,omitempty"`
+}
+
+type retryClaim struct {
+	Interval    string  `json:"interval"`
+	Multiplier  float64 `json:"multiplier,omitempty"`
+	Jitter      float64 `json:"jitter,omitempty"`
+	MaxInterval string  `json:"max_interval,omitempty"`
+}
+
+// redirect converts the claims into a Redirect, rejecting claims that can't
+// be used.
+func (c *customClaims) redirect() (Redirect, error) {
+	r := Redirect{
+		Endpoint: c.Endpoint,
+	}
+
+	for _, ep := range c.Endpoints {
+		if _, err := url.ParseRequestURI(ep.URL); err != nil {
+			return Redirect{}, fmt.Errorf("%w: invalid endpoint url '%s'", ErrInvalidJWT, ep.URL)
+		}
+		if ep.Weight < 0 {
+			return Redirect{}, fmt.Errorf("%w: negative endpoint weight", ErrInvalidJWT)
+		}
+		r.Endpoints = append(r.Endpoints, Endpoint(ep))
+	}
+
+	if c.Connection == nil {
+		return r, nil
+	}
+
+	r.URLPath = c.Connection.URLPath
+
+	switch c.Connection.IPFamily {
+	case "", IPv4, IPv6:
+		r.IPFamily = c.Connection.IPFamily
+	default:
+		return Redirect{}, fmt.Errorf("%w: invalid ip family '%s'", ErrInvalidJWT, c.Connection.IPFamily)
+	}
+
+	if c.Connection.Retry != nil {
+		policy, err := c.Connection.Retry.config()
+		if err != nil {
+			return Redirect{}, err
+		}
+		r.Retry = &policy
+	}
+
+	return r, nil
+}
+
+func (rc retryClaim) config() (retry.Config, error) {
+	interval, err := time.ParseDuration(rc.Interval)
+	if err != nil || interval <= 0 {
+		return retry.Config{}, fmt.Errorf("%w: invalid retry interval '%s'", ErrInvalidJWT, rc.Interval)
+	}
+
+	var maxInterval time.Duration
+	if rc.MaxInterval != "" {
+		maxInterval, err = time.ParseDuration(rc.MaxInterval)
+		if err != nil || maxInterval < 0 {
+			return retry.Config{}, fmt.Errorf("%w: invalid retry max interval '%s'", ErrInvalidJWT, rc.MaxInterval)
+		}
+	}
+
+	if rc.Multiplier < 0 || rc.Jitter < 0 {
+		return retry.Config{}, fmt.Errorf("%w: negative retry multiplier or jitter", ErrInvalidJWT)
+	}
+
+	return retry.Config{
+		Interval:    interval,
+		Multiplier:  rc.Multiplier,
+		Jitter:      rc.Jitter,
+		MaxInterval: maxInterval,
+	}, nil
+}
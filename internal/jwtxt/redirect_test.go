@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package jwtxt
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/retry"
+)
+
+// signedTXT signs the claims and splits the JWT into TXT record lines.
+func signedTXT(t *testing.T, claims jwt.MapClaims) []string {
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemECPrivate))
+	require.NoError(t, err)
+
+	return txtLines(signToken(t, key, "", claims))
+}
+
+// signToken signs the claims with the ES256 key, adding the kid header if it
+// is not empty.
+func signToken(t *testing.T, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+// txtLines splits the JWT into TXT record lines.
+func txtLines(token string) []string {
+	var lines []string
+	for i := 0; len(token) > 0; i++ {
+		n := min(len(token), 200)
+		lines = append(lines, fmt.Sprintf("%02d:%s", i, token[:n]))
+		token = token[n:]
+	}
+	return lines
+}
+
+func TestInstructions_Redirect(t *testing.T) {
+	exp := time.Unix(1690000000, 0)
+
+	tests := []struct {
+		description      string
+		claims           jwt.MapClaims
+		expected         Redirect
+		expectedEndpoint string
+		expectedErr      error
+	}{
+		{
+			description: "only an endpoint",
+			claims: jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"exp":      exp.Unix(),
+			},
+			expected: Redirect{
+				Endpoint: "fabric.xmidt.example.org",
+			},
+			expectedEndpoint: "fabric.xmidt.example.org",
+		}, {
+			description: "endpoints and connection parameters",
+			claims: jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"endpoints": []map[string]any{
+					{"url": "https://a.example.org", "priority": 1, "weight": 3},
+					{"url": "https://b.example.org", "priority": 1},
+				},
+				"connection": map[string]any{
+					"url_path":  "/api/v3/device",
+					"ip_family": "ipv6",
+					"retry": map[string]any{
+						"interval":     "2s",
+						"multiplier":   2.0,
+						"jitter":       0.1,
+						"max_interval": "5m",
+					},
+				},
+				"exp": exp.Unix(),
+			},
+			expected: Redirect{
+				Endpoint: "fabric.xmidt.example.org",
+				Endpoints: []Endpoint{
+					{URL: "https://a.example.org", Priority: 1, Weight: 3},
+					{URL: "https://b.example.org", Priority: 1},
+				},
+				URLPath:  "/api/v3/device",
+				IPFamily: IPv6,
+				Retry: &retry.Config{
+					Interval:    2 * time.Second,
+					Multiplier:  2.0,
+					Jitter:      0.1,
+					MaxInterval: 5 * time.Minute,
+				},
+			},
+			expectedEndpoint: "fabric.xmidt.example.org",
+		}, {
+			description: "only endpoints",
+			claims: jwt.MapClaims{
+				"endpoints": []map[string]any{
+					{"url": "https://b.example.org", "priority": 2},
+					{"url": "https://a.example.org", "priority": 1},
+				},
+				"exp": exp.Unix(),
+			},
+			expected: Redirect{
+				Endpoints: []Endpoint{
+					{URL: "https://b.example.org", Priority: 2},
+					{URL: "https://a.example.org", Priority: 1},
+				},
+			},
+			expectedEndpoint: "https://a.example.org",
+		}, {
+			description: "invalid endpoint url",
+			claims: jwt.MapClaims{
+				"endpoints": []map[string]any{{"url": "invalid"}},
+				"exp":       exp.Unix(),
+			},
+			expectedErr: ErrInvalidJWT,
+		}, {
+			description: "negative endpoint weight",
+			claims: jwt.MapClaims{
+				"endpoints": []map[string]any{{"url": "https://a.example.org", "weight": -1}},
+				"exp":       exp.Unix(),
+			},
+			expectedErr: ErrInvalidJWT,
+		}, {
+			description: "invalid ip family",
+			claims: jwt.MapClaims{
+				"endpoint":   "fabric.xmidt.example.org",
+				"connection": map[string]any{"ip_family": "ipx"},
+				"exp":        exp.Unix(),
+			},
+			expectedErr: ErrInvalidJWT,
+		}, {
+			description: "invalid retry interval",
+			claims: jwt.MapClaims{
+				"endpoint":   "fabric.xmidt.example.org",
+				"connection": map[string]any{"retry": map[string]any{"interval": "soon"}},
+				"exp":        exp.Unix(),
+			},
+			expectedErr: ErrInvalidJWT,
+		}, {
+			description: "invalid retry max interval",
+			claims: jwt.MapClaims{
+				"endpoint": "fabric.xmidt.example.org",
+				"connection": map[string]any{
+					"retry": map[string]any{"interval": "1s", "max_interval": "later"},
+				},
+				"exp": exp.Unix(),
+			},
+			expectedErr: ErrInvalidJWT,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			then := func() time.Time { return time.Unix(1680000000, 0) }
+
+			ins, err := New(
+				BaseURL("https://fabric.random.example.org"),
+				DeviceID("mac:112233445566"),
+				Algorithms("ES256"),
+				publicECOption(),
+				UseNowFunc(then),
+				UseResolver(&mockdns.Resolver{
+					Zones: map[string]mockdns.Zone{
+						"112233445566.fabric.random.example.org.": {
+							TXT: signedTXT(t, tc.claims),
+						},
+					},
+				}),
+			)
+			require.NoError(err)
+			ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(then))
+
+			got, err := ins.Redirect(context.Background())
+			if tc.expectedErr != nil {
+				assert.ErrorIs(err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(err)
+			assert.Equal(tc.expected, got)
+
+			endpoint, err := ins.Endpoint(context.Background())
+			require.NoError(err)
+			assert.Equal(tc.expectedEndpoint, endpoint)
+		})
+	}
+}
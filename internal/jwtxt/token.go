@@ -7,13 +7,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	iofs "io/fs"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/xmidt-org/eventor"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
 )
 
@@ -31,6 +34,17 @@ var (
 const (
 	// DefaultTimeout is the default timeout for DNS queries.
 	DefaultTimeout = time.Second * 15
+
+	// DefaultRefetchPercent is the default percentage of the remaining
+	// lifetime of the instructions to wait before fetching them again.
+	DefaultRefetchPercent = 90.0
+
+	// DefaultRetryInterval is the default time to wait before fetching the
+	// instructions again after a failure.
+	DefaultRetryInterval = time.Minute
+
+	// DefaultJWKSInterval is the default time between fetches of the key set.
+	DefaultJWKSInterval = time.Hour
 )
 
 // The Resolver interface allows users to provide their own resolver for
@@ -40,14 +54,16 @@ type Resolver interface {
 }
 
 type Instructions struct {
-	// baseURL is the base url to examine for a JWT from a DNS TXT record.
-	baseURL string
+	// baseURLs are the base urls to examine for a JWT from a DNS TXT record,
+	// in the order they are tried.
+	baseURLs []string
 
 	// id is the identifier to prepend when looking for a DNS TXT record.
 	id string
 
-	// fqdn is the 'device_id.base_url' based on the input configuration.
-	fqdn string
+	// fqdns are the 'device_id.base_url' names based on the input
+	// configuration, in the order they are tried.
+	fqdns []string
 
 	// jwtOptions allows for normal and test configurations.
 	jwtOptions []jwt.ParserOption
@@ -58,32 +74,81 @@ type Instructions struct {
 	// algorithms is the list of algorithms allowed for JWT validation.
 	algorithms []string
 
-	// publicKeys is the collection of keys split out by supported algorithm.
-	publicKeys jwt.VerificationKeySet
+	// keys are the configured keys to use for verification.
+	keys []verificationKey
 
 	// now is used to supply the current time that is needed for expiration.
 	// it's here just for testing support.
 	now func() time.Time
 
-	// resolver is used to supply the resolver to use; it's here just for
-	// testing support.
-	resolver Resolver
+	// resolvers are the resolvers to use, in the order they are tried for
+	// each fqdn.
+	resolvers []Resolver
 
 	// fetchListeners calls back listeners when a fetch event occurs.
 	fetchListeners eventor.Eventor[event.FetchListener]
 
+	// keySetListeners calls back listeners when a key set fetch event occurs.
+	keySetListeners eventor.Eventor[event.KeySetFetchListener]
+
+	// jwksURL is where the signed key set is fetched from every jwksInterval
+	// using the client.
+	jwksURL      string
+	jwksInterval time.Duration
+	client       *http.Client
+
+	// fs, filename and perm are where the most recent valid JWT is stored
+	// so it survives a restart.
+	fs       fs.FS
+	filename string
+	perm     iofs.FileMode
+
+	// refetchPercent is the percentage of the remaining lifetime to wait
+	// before fetching the instructions again in the background.
+	refetchPercent float64
+
+	// retryInterval is the time to wait after a failed background fetch.
+	retryInterval time.Duration
+
+	// wg and shutdown control the background fetching.
+	wg       sync.WaitGroup
+	shutdown context.CancelFunc
+
 	// ---- These fields are populated/used by the fetch method. ----
 
 	// m protects the fields below.
 	m sync.Mutex
 
+	// current is the most recent valid set of instructions.
+	current record
+
+	// refetchAt is when the instructions are fetched again in the
+	// background.
+	refetchAt time.Time
+
+	// fetchedKeys are the keys from the most recent valid key set fetched
+	// from the jwksURL, which was issued at fetchedKeysIssuedAt.
+	fetchedKeys         []verificationKey
+	fetchedKeysIssuedAt time.Time
+
+	// keySets are the signed key sets that are stored, oldest first.  The
+	// first is signed by a configured key, and each of the others by a key
+	// from the one before it.
+	keySets []string
+}
+
+// record is the information obtained from a valid JWT.
+type record struct {
+	// token is the JWT itself.
+	token string
+
 	// validUntil is when the information from the JWT is valid until.
 	validUntil time.Time
 
-	// endpoint is the endpoint of the most recent valid JWT.
-	endpoint string
+	// redirect is the connection guidance of the JWT.
+	redirect Redirect
 
-	// payload is the payload of the most recent valid JWT.
+	// payload is the payload of the JWT.
 	payload []byte
 }
 
@@ -91,9 +156,13 @@ type Instructions struct {
 func New(opts ...Option) (*Instructions, error) {
 	ins := Instructions{
 		now:        time.Now,
-		resolver:   net.DefaultResolver,
+		resolvers:  []Resolver{net.DefaultResolver},
 		timeout:    DefaultTimeout,
 		algorithms: []string{},
+		client:     http.DefaultClient,
+
+		refetchPercent: DefaultRefetchPercent,
+		retryInterval:  DefaultRetryInterval,
 	}
 
 	for _, opt := range opts {
@@ -105,11 +174,17 @@ func New(opts ...Option) (*Instructions, error) {
 		}
 	}
 
-	if ins.baseURL == "" || ins.id == "" {
+	if len(ins.baseURLs) == 0 || ins.id == "" {
 		return nil, fmt.Errorf("%w: baseURL and id must be set", ErrInvalidInput)
 	}
 
-	ins.fqdn = ins.id + "." + ins.baseURL
+	if len(ins.resolvers) == 0 {
+		return nil, fmt.Errorf("%w: at least one resolver must be set", ErrInvalidInput)
+	}
+
+	for _, baseURL := range ins.baseURLs {
+		ins.fqdns = append(ins.fqdns, ins.id+"."+baseURL)
+	}
 	ins.jwtOptions = []jwt.ParserOption{jwt.WithValidMethods(ins.algorithms)}
 
 	return &ins, nil
@@ -122,28 +197,173 @@ func (ins *Instructions) dispatch(fe event.Fetch) error {
 	return fe.Err
 }
 
+// Start loads the stored key sets and instructions and starts fetching them
+// in the background.  Both are loaded before Start returns so they are used
+// even if the network can't be reached.
+func (ins *Instructions) Start() {
+	ins.m.Lock()
+	if ins.shutdown != nil {
+		ins.m.Unlock()
+		return
+	}
+
+	var ctx context.Context
+	ctx, ins.shutdown = context.WithCancel(context.Background())
+
+	ins.wg.Add(1)
+	if ins.jwksURL != "" {
+		ins.wg.Add(1)
+	}
+	ins.m.Unlock()
+
+	// The stored JWT may be signed by a key from the stored key sets.
+	_ = ins.loadKeys()
+	_ = ins.load()
+
+	go ins.run(ctx)
+
+	if ins.jwksURL != "" {
+		go ins.runKeys(ctx)
+	}
+}
+
+// Stop stops fetching the instructions in the background.
+func (ins *Instructions) Stop() {
+	ins.m.Lock()
+	shutdown := ins.shutdown
+	ins.shutdown = nil
+	ins.m.Unlock()
+
+	if shutdown != nil {
+		shutdown()
+	}
+	ins.wg.Wait()
+}
+
 // Endpoint returns the valid endpoint based on the instructions, or an error if
-// there is no valid set of instructions.
+// there is no valid set of instructions.  If the instructions only list
+// several endpoints, the preferred one is returned.
 func (ins *Instructions) Endpoint(ctx context.Context) (string, error) {
+	r, err := ins.Redirect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return r.preferred(), nil
+}
+
+// Redirect returns the valid connection guidance based on the instructions, or
+// an error if there is no valid set of instructions.  The instructions are
+// only fetched here if there are no valid instructions; otherwise they are
+// kept up to date in the background once started.
+func (ins *Instructions) Redirect(ctx context.Context) (Redirect, error) {
 	ins.m.Lock()
-	defer ins.m.Unlock()
+	current := ins.current
+	ins.m.Unlock()
 
-	if ins.now().Before(ins.validUntil) {
-		return ins.endpoint, nil
+	if ins.now().Before(current.validUntil) {
+		return current.redirect, nil
 	}
 
-	err := ins.fetch(ctx)
+	current, err := ins.fetch(ctx)
 	if err != nil {
-		return "", err
+		return Redirect{}, err
 	}
 
-	return ins.endpoint, nil
+	return current.redirect, nil
 }
 
-func (ins *Instructions) fetch(ctx context.Context) error {
+// run fetches the instructions whenever they are due to be refreshed until the
+// context is canceled.
+func (ins *Instructions) run(ctx context.Context) {
+	defer ins.wg.Done()
+
+	// The JWT may be signed by a key from the key set.
+	if ins.jwksURL != "" {
+		_ = ins.fetchKeys(ctx)
+	}
+
+	// Stored instructions that are still valid don't need to be fetched yet.
+	wait := ins.refetchIn()
+	for {
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		wait = ins.retryInterval
+		if _, err := ins.fetch(ctx); err == nil {
+			// Never fetch more often than the retry interval.
+			wait = max(ins.refetchIn(), ins.retryInterval)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// refetchIn returns how long until the instructions should be fetched again.
+func (ins *Instructions) refetchIn() time.Duration {
+	ins.m.Lock()
+	defer ins.m.Unlock()
+
+	return ins.refetchAt.Sub(ins.now())
+}
+
+// set makes the record the current instructions and optionally stores it.
+func (ins *Instructions) set(rec record, store bool) error {
+	ins.m.Lock()
+	defer ins.m.Unlock()
+
+	now := ins.now()
+	lifetime := rec.validUntil.Sub(now)
+
+	ins.current = rec
+	ins.refetchAt = now.Add(time.Duration(float64(lifetime) * ins.refetchPercent / 100.0))
+
+	if !store {
+		return nil
+	}
+	return ins.store(rec)
+}
+
+// fetch tries each fqdn with each resolver, in order, until a valid JWT is
+// found.  Every attempt is reported as a fetch event.
+func (ins *Instructions) fetch(ctx context.Context) (record, error) {
+	ins.m.Lock()
+	prior := ins.current.validUntil
+	ins.m.Unlock()
+
+	var errs []error
+	for _, fqdn := range ins.fqdns {
+		for _, resolver := range ins.resolvers {
+			rec, err := ins.fetchOne(ctx, fqdn, resolver, prior)
+			if err == nil {
+				return rec, nil
+			}
+			errs = append(errs, err)
+
+			if ctx.Err() != nil {
+				return record{}, errors.Join(errs...)
+			}
+		}
+	}
+
+	return record{}, errors.Join(errs...)
+}
+
+func (ins *Instructions) fetchOne(ctx context.Context, fqdn string, resolver Resolver, prior time.Time) (record, error) {
 	fe := event.Fetch{
-		FQDN:            ins.fqdn,
-		PriorExpiration: ins.validUntil,
+		Origin:          "dns",
+		FQDN:            fqdn,
+		Resolver:        resolverName(resolver),
+		PriorExpiration: prior,
 	}
 
 	// Don't wait forever if things are broken.
@@ -151,7 +371,7 @@ func (ins *Instructions) fetch(ctx context.Context) error {
 	defer cancel()
 
 	fe.At = time.Now()
-	lines, err := ins.resolver.LookupTXT(ctx, ins.fqdn)
+	lines, err := resolver.LookupTXT(ctx, fqdn)
 	if err != nil {
 		var dnsError *net.DNSError
 
@@ -167,7 +387,7 @@ func (ins *Instructions) fetch(ctx context.Context) error {
 		}
 		fe.Duration = time.Since(fe.At)
 		fe.Err = err
-		return ins.dispatch(fe)
+		return record{}, ins.dispatch(fe)
 	}
 	fe.Duration = time.Since(fe.At)
 
@@ -175,19 +395,87 @@ func (ins *Instructions) fetch(ctx context.Context) error {
 
 	txt := ins.reassemble(lines)
 
-	err = ins.validate(txt)
+	rec, err := ins.validate(txt)
+	if err == nil {
+		// A failure to store the instructions doesn't make them invalid.
+		_ = ins.set(rec, true)
+
+		fe.Endpoint = rec.redirect.preferred()
+		fe.Expiration = rec.validUntil
+		fe.Payload = rec.payload
+	}
+
+	fe.Err = err
+	return rec, ins.dispatch(fe)
+}
+
+// store writes the JWT to the local storage, if there is one.  The caller
+// must hold the lock.
+func (ins *Instructions) store(rec record) error {
+	if ins.fs == nil {
+		return nil
+	}
+
+	return fs.Operate(ins.fs,
+		fs.WithPath(ins.filename, ins.perm),
+		fs.WriteFileWithSHA256(ins.filename, []byte(rec.token), ins.perm))
+}
+
+// load reads the JWT from the local storage, if there is one, and makes it the
+// current instructions if it is still valid.  The JWT is verified again since
+// the keys may have changed since it was stored.
+func (ins *Instructions) load() error {
+	if ins.fs == nil {
+		return nil
+	}
+
+	fe := event.Fetch{
+		Origin: "fs",
+	}
+
+	var buf []byte
+
+	ins.m.Lock()
+	fe.At = time.Now()
+	err := fs.Operate(ins.fs,
+		fs.WithPath(ins.filename, ins.perm),
+		fs.ReadFileWithSHA256(ins.filename, &buf))
+	fe.Duration = time.Since(fe.At)
+	ins.m.Unlock()
+
+	if err != nil {
+		fe.Err = err
+		return ins.dispatch(fe)
+	}
+
+	fe.Found = true
+
+	rec, err := ins.validate(string(buf))
 	if err != nil {
 		fe.Err = err
 		return ins.dispatch(fe)
 	}
 
-	fe.Endpoint = ins.endpoint
-	fe.Expiration = ins.validUntil
-	fe.Payload = ins.payload
+	_ = ins.set(rec, false)
+
+	fe.Endpoint = rec.redirect.preferred()
+	fe.Expiration = rec.validUntil
+	fe.Payload = rec.payload
 
 	return ins.dispatch(fe)
 }
 
+// resolverName returns the name of the resolver used in fetch events.
+func resolverName(r Resolver) string {
+	switch r := r.(type) {
+	case *net.Resolver:
+		return "system"
+	case fmt.Stringer:
+		return r.String()
+	}
+	return fmt.Sprintf("%T", r)
+}
+
 // reassemble converts the TXT record from the list of encoded lines into
 // the expected string of text that we all hope is a legit JWT.  The format
 // of the lines in the TXT is:
@@ -237,42 +525,40 @@ func (ins *Instructions) reassemble(lines []string) string {
 }
 
 // validate takes a string that is believed to be a JWT and validates it.
-// If it is valid, the information is saved in the Instruction object for
-// use along with when the information is no longer valid after.
-func (ins *Instructions) validate(input string) error {
+// If it is valid, the information is returned for use along with when the
+// information is no longer valid after.
+func (ins *Instructions) validate(input string) (record, error) {
 	parser := jwt.NewParser(ins.jwtOptions...)
 
-	token, err := parser.ParseWithClaims(input, &customClaims{},
-		func(t *jwt.Token) (any, error) {
-			return ins.publicKeys, nil
-		})
+	token, err := parser.ParseWithClaims(input, &customClaims{}, ins.keyfunc)
 	if err != nil {
-		return err
+		return record{}, err
 	}
 
 	until, err := token.Claims.GetExpirationTime()
 	if err != nil {
-		return err
+		return record{}, err
+	}
+
+	redirect, err := token.Claims.(*customClaims).redirect()
+	if err != nil {
+		return record{}, err
 	}
 
 	_, parts, err := parser.ParseUnverified(input, &customClaims{})
 	if err != nil {
-		return err
+		return record{}, err
 	}
 
 	payload, err := parser.DecodeSegment(parts[1])
 	if err != nil {
-		return err
+		return record{}, err
 	}
 
-	ins.endpoint = token.Claims.(*customClaims).Endpoint
-	ins.payload = payload
-	ins.validUntil = (*until).Time
-
-	return nil
-}
-
-type customClaims struct {
-	Endpoint string `json:"endpoint"`
-	jwt.RegisteredClaims
+	return record{
+		token:      input,
+		redirect:   redirect,
+		payload:    payload,
+		validUntil: (*until).Time,
+	}, nil
 }
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +15,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/xmidt-agent/internal/fs"
+	"github.com/xmidt-org/xmidt-agent/internal/fs/mem"
 	"github.com/xmidt-org/xmidt-agent/internal/jwtxt/event"
 )
 
@@ -75,6 +78,7 @@ func TestInstructions_EndToEnd(t *testing.T) {
 			},
 			listener: func(assert *assert.Assertions, fe event.Fetch) {
 				assert.Equal("112233445566.fabric.random.example.org", fe.FQDN)
+				assert.Equal("*mockdns.Resolver", fe.Resolver)
 				assert.Equal("", fe.Server)
 				assert.True(fe.Found)
 				assert.False(fe.Timeout)
@@ -281,6 +285,14 @@ func TestInstructions_EndToEnd(t *testing.T) {
 				publicECOption(),
 				randomResolver(),
 			},
+		}, {
+			description:    "no resolvers",
+			expectedNewErr: ErrInvalidInput,
+			opts: []Option{
+				BaseURL("https://fabric.random.example.org"),
+				DeviceID("mac:112233445566"),
+				UseResolver(),
+			},
 		}, {
 			description: "invalid base url",
 			opts: []Option{
@@ -368,3 +380,193 @@ func TestInstructions_EndToEnd(t *testing.T) {
 		})
 	}
 }
+
+type countingResolver struct {
+	Resolver
+	count atomic.Int32
+}
+
+func (c *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	c.count.Add(1)
+	return c.Resolver.LookupTXT(ctx, name)
+}
+
+func TestInstructions_LocalStorage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	then := func() time.Time { return time.Unix(1680000000, 0) }
+	storage := mem.New(mem.WithDir(".", 0755))
+
+	opts := []Option{
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseNowFunc(then),
+		LocalStorage(storage, "jwtxt.jwt", 0600),
+	}
+
+	// A successful lookup is stored.
+	first, err := New(append(opts, randomResolver())...)
+	require.NoError(err)
+	first.jwtOptions = append(first.jwtOptions, jwt.WithTimeFunc(then))
+
+	endpoint, err := first.Endpoint(context.Background())
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+
+	// After a restart during a DNS outage the stored instructions are used.
+	loaded := make(chan event.Fetch, 1)
+	second, err := New(append(opts,
+		UseResolver(&niceNeverResolver{}),
+		WithFetchListener(event.FetchListenerFunc(
+			func(fe event.Fetch) {
+				if fe.Origin == "fs" {
+					loaded <- fe
+				}
+			})),
+	)...)
+	require.NoError(err)
+	second.jwtOptions = append(second.jwtOptions, jwt.WithTimeFunc(then))
+
+	second.Start()
+	defer second.Stop()
+
+	select {
+	case fe := <-loaded:
+		assert.NoError(fe.Err)
+		assert.True(fe.Found)
+		assert.Equal("fabric.xmidt.example.org", fe.Endpoint)
+		assert.Equal(time.Unix(1690000000, 0), fe.Expiration)
+	case <-time.After(time.Second):
+		require.FailNow("the stored instructions were not loaded")
+	}
+
+	endpoint, err = second.Endpoint(context.Background())
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+}
+
+func TestInstructions_LocalStorageStart(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	then := func() time.Time { return time.Unix(1680000000, 0) }
+	storage := mem.New(mem.WithDir(".", 0755))
+
+	opts := []Option{
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseNowFunc(then),
+		LocalStorage(storage, "jwtxt.jwt", 0600),
+	}
+
+	first, err := New(append(opts, randomResolver())...)
+	require.NoError(err)
+	first.jwtOptions = append(first.jwtOptions, jwt.WithTimeFunc(then))
+
+	_, err = first.Endpoint(context.Background())
+	require.NoError(err)
+
+	// The stored instructions are used as soon as Start returns, even though
+	// the DNS lookups fail.
+	second, err := New(append(opts, UseResolver(failingResolver{}))...)
+	require.NoError(err)
+	second.jwtOptions = append(second.jwtOptions, jwt.WithTimeFunc(then))
+
+	second.Start()
+	defer second.Stop()
+
+	endpoint, err := second.Endpoint(context.Background())
+	require.NoError(err)
+	assert.Equal("fabric.xmidt.example.org", endpoint)
+}
+
+func TestInstructions_LocalStorageInvalid(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	then := func() time.Time { return time.Unix(1680000000, 0) }
+	storage := mem.New(mem.WithDir(".", 0755))
+	require.NoError(fs.Operate(storage,
+		fs.WriteFileWithSHA256("jwtxt.jwt", []byte("not a jwt"), 0600)))
+
+	loaded := make(chan event.Fetch, 1)
+	ins, err := New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseNowFunc(then),
+		UseResolver(&niceNeverResolver{}),
+		Timeout(time.Millisecond),
+		LocalStorage(storage, "jwtxt.jwt", 0600),
+		WithFetchListener(event.FetchListenerFunc(
+			func(fe event.Fetch) {
+				if fe.Origin == "fs" {
+					loaded <- fe
+				}
+			})),
+	)
+	require.NoError(err)
+
+	ins.Start()
+	defer ins.Stop()
+
+	select {
+	case fe := <-loaded:
+		assert.ErrorIs(fe.Err, jwt.ErrTokenMalformed)
+	case <-time.After(time.Second):
+		require.FailNow("the stored instructions were not loaded")
+	}
+
+	// Nothing valid was stored and the lookup fails.
+	_, err = ins.Endpoint(context.Background())
+	assert.Error(err)
+}
+
+func TestInstructions_BackgroundRefresh(t *testing.T) {
+	require := require.New(t)
+
+	// The JWT expires a second from now, so it is fetched again often.
+	then := func() time.Time { return time.Unix(1690000000-1, 0) }
+
+	resolver := countingResolver{}
+	resolver.Resolver = &mockdns.Resolver{
+		Zones: map[string]mockdns.Zone{
+			"112233445566.fabric.random.example.org.": {
+				TXT: randomTXT,
+			},
+		},
+	}
+
+	ins, err := New(
+		BaseURL("https://fabric.random.example.org"),
+		DeviceID("mac:112233445566"),
+		Algorithms("ES256"),
+		publicECOption(),
+		UseNowFunc(then),
+		UseResolver(&resolver),
+		RefetchPercent(1),
+		RetryInterval(time.Millisecond),
+	)
+	require.NoError(err)
+	ins.jwtOptions = append(ins.jwtOptions, jwt.WithTimeFunc(then))
+
+	ins.Start()
+	defer ins.Stop()
+
+	require.Eventually(func() bool {
+		return resolver.count.Load() >= 3
+	}, time.Second, time.Millisecond)
+
+	// The background fetch means there is no lookup here.
+	before := resolver.count.Load()
+	endpoint, err := ins.Endpoint(context.Background())
+	require.NoError(err)
+	require.Equal("fabric.xmidt.example.org", endpoint)
+	require.LessOrEqual(resolver.count.Load()-before, int32(1))
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
 	"encoding/base64"
 
@@ -40,6 +41,7 @@ const (
 	BootTimeRetryDelay         = "boot-time-retry-wait"
 	InterfaceUsed       string = "webpa-interface-used"
 	InterfacesAvailable        = "interfaces-available"
+	LastReconnectReason        = "webpa-last-reconnect-reason"
 )
 
 type MetadataProvider struct {
@@ -53,7 +55,10 @@ type MetadataProvider struct {
 	protocol           string
 	bootTime           string
 	bootTimeRetryDelay string
-	interfaceUsed      string
+
+	lock                sync.RWMutex
+	interfaceUsed       string
+	lastReconnectReason string
 }
 
 func New(opts ...Option) (*MetadataProvider, error) {
@@ -92,7 +97,11 @@ func (c *MetadataProvider) GetMetadata() map[string]interface{} {
 		case BootTimeRetryDelay:
 			header[field] = c.bootTimeRetryDelay
 		case InterfaceUsed:
+			c.lock.RLock()
 			header[field] = c.interfaceUsed
+			c.lock.RUnlock()
+		case LastReconnectReason:
+			header[field] = c.GetLastReconnectReason()
 		case InterfacesAvailable: // what if we can't get interfaces available?
 			names, err := c.networkService.GetInterfaceNames()
 			if err != nil {
@@ -108,6 +117,30 @@ func (c *MetadataProvider) GetMetadata() map[string]interface{} {
 	return header
 }
 
+// SetInterfaceUsed updates the network interface reported as being used to
+// connect to the cloud.
+func (c *MetadataProvider) SetInterfaceUsed(name string) {
+	c.lock.Lock()
+	c.interfaceUsed = name
+	c.lock.Unlock()
+}
+
+// SetLastReconnectReason updates the reason reported for the most recent
+// reconnect of the websocket.
+func (c *MetadataProvider) SetLastReconnectReason(reason string) {
+	c.lock.Lock()
+	c.lastReconnectReason = reason
+	c.lock.Unlock()
+}
+
+// GetLastReconnectReason returns the reason for the most recent reconnect of
+// the websocket.
+func (c *MetadataProvider) GetLastReconnectReason() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lastReconnectReason
+}
+
 func (c *MetadataProvider) Decorate(headers http.Header) error {
 	header := c.GetMetadata()
 	headerBytes, err := json.Marshal(header)
@@ -81,6 +81,28 @@ func (suite *ConveySuite) TestGetConveyHeader() {
 	suite.Equal("erouter0", header["webpa-interface-used"])
 }
 
+func (suite *ConveySuite) TestSetInterfaceUsed() {
+	suite.mockNetworkService.On("GetInterfaceNames").Return([]string{"erouter0", "eth0"}, nil)
+	suite.conveyHeaderProvider.SetInterfaceUsed("eth0")
+
+	header := suite.conveyHeaderProvider.GetMetadata()
+	suite.Equal("eth0", header["webpa-interface-used"])
+}
+
+func (suite *ConveySuite) TestSetLastReconnectReason() {
+	suite.mockNetworkService.On("GetInterfaceNames").Return([]string{"erouter0"}, nil)
+	suite.conveyHeaderProvider.fields = []string{"webpa-last-reconnect-reason"}
+
+	header := suite.conveyHeaderProvider.GetMetadata()
+	suite.Equal("", header["webpa-last-reconnect-reason"])
+
+	suite.conveyHeaderProvider.SetLastReconnectReason("server_close:1001")
+	suite.Equal("server_close:1001", suite.conveyHeaderProvider.GetLastReconnectReason())
+
+	header = suite.conveyHeaderProvider.GetMetadata()
+	suite.Equal("server_close:1001", header["webpa-last-reconnect-reason"])
+}
+
 func (suite *ConveySuite) TestGetConveyHeaderSubsetFields() {
 	suite.mockNetworkService.On("GetInterfaceNames").Return([]string{"docsis"}, nil)
 	suite.conveyHeaderProvider.fields = []string{"fw-name", "hw-model"}
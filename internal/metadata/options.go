@@ -13,7 +13,7 @@ import (
 
 var (
 	ErrInvalidInput = errors.New("invalid input")
-	validFields     = []string{Firmware, Hardware, SerialNumber, Manufacturer, LastRebootReason, Protocol, BootTime, BootTimeRetryDelay, InterfaceUsed, InterfacesAvailable}
+	validFields     = []string{Firmware, Hardware, SerialNumber, Manufacturer, LastRebootReason, Protocol, BootTime, BootTimeRetryDelay, InterfaceUsed, InterfacesAvailable, LastReconnectReason}
 )
 
 func NetworkServiceOpt(networkService net.NetworkServicer) Option {
@@ -110,7 +110,8 @@ func BootRetryWaitOpt(bootTimeRetryDelay time.Duration) Option {
 		})
 }
 
-// if we want websocket to populate this value, pass in InterfaceUsedProvider instead
+// InterfaceUsedOpt sets the initial network interface reported as being used.
+// The websocket updates it with SetInterfaceUsed as it picks an interface.
 func InterfaceUsedOpt(interfaceUsed string) Option {
 	return optionFunc(
 		func(c *MetadataProvider) error {
@@ -214,6 +214,17 @@ func (c *Conn) flate() bool {
 	return c.copts != nil
 }
 
+// CompressionMode returns the compression mode negotiated with the peer.
+func (c *Conn) CompressionMode() CompressionMode {
+	if !c.flate() {
+		return CompressionDisabled
+	}
+	if !c.msgWriter.flateContextTakeover() {
+		return CompressionNoContextTakeover
+	}
+	return CompressionContextTakeover
+}
+
 // Ping sends a ping to the peer and waits for a pong.
 // Use this to measure latency or ensure the peer is responsive.
 // Ping must be called concurrently with Reader as it does
@@ -79,6 +79,10 @@ func (c *Conn) CloseRead(ctx context.Context) context.Context {
 	return ctx
 }
 
+// ErrPongWrite is returned by a reader when the pong in response to a ping
+// could not be written, usually because the ping write timeout passed.
+var ErrPongWrite = errors.New("failed to respond to ping")
+
 // SetPingListener calls the provided function when a ping is received.
 func (c *Conn) SetPingListener(f func(context.Context, []byte)) {
 	if f == nil {
@@ -326,7 +330,11 @@ func (c *Conn) handleControl(ctx context.Context, h header) (err error) {
 		}
 
 		c.pingListener(ctx, b)
-		return c.writeControl(ctx, opPong, b)
+		err = c.writeControl(ctx, opPong, b)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPongWrite, err)
+		}
+		return nil
 	case opPong:
 		c.pongListener(ctx, b)
 		c.activePingsMu.Lock()
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proxy builds the proxy selection function used by the http clients
+// from configuration.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// Config is the configuration of an explicit proxy.
+type Config struct {
+	// URL is the proxy to use.  The http:// and https:// schemes are HTTP
+	// CONNECT proxies, and socks5:// is a SOCKS5 proxy (socks5h:// resolves
+	// names at the proxy).
+	URL string
+
+	// Username and Password authenticate with the proxy, using basic auth
+	// for HTTP proxies and username/password auth for SOCKS5 proxies.  They
+	// replace any user info in the URL.
+	Username string
+	Password string
+
+	// Bypass lists the destinations that are connected to directly.  Each
+	// entry is one of:
+	//   - "*" to bypass the proxy for everything
+	//   - a host name, which also matches its subdomains ("example.com"
+	//     matches "api.example.com")
+	//   - an IP address or a CIDR block ("10.0.0.0/8")
+	//   - any of the above followed by ":port" to only match that port
+	Bypass []string
+}
+
+// ProxyFunc returns the function that chooses the proxy for each request, for
+// use as the http.Transport Proxy.
+func (c Config) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("%w: proxy url is required", ErrInvalidInput)
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: unsupported proxy scheme '%s'", ErrInvalidInput, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: proxy url '%s' has no host", ErrInvalidInput, c.URL)
+	}
+
+	if c.Username != "" {
+		u.User = url.UserPassword(c.Username, c.Password)
+	}
+
+	rules := make([]rule, 0, len(c.Bypass))
+	for _, entry := range c.Bypass {
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host, port := hostPort(req.URL)
+		for _, r := range rules {
+			if r.matches(host, port) {
+				return nil, nil
+			}
+		}
+		return u, nil
+	}, nil
+}
+
+// rule is a parsed bypass entry.
+type rule struct {
+	all    bool
+	domain string
+	ipNet  *net.IPNet
+	port   string
+}
+
+func parseRule(entry string) (rule, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" {
+		return rule{}, fmt.Errorf("%w: empty bypass entry", ErrInvalidInput)
+	}
+	if entry == "*" {
+		return rule{all: true}, nil
+	}
+
+	var r rule
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		r.ipNet = ipNet
+		return r, nil
+	}
+
+	host := entry
+	if h, p, err := net.SplitHostPort(entry); err == nil {
+		host, r.port = h, p
+	}
+
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		r.ipNet = ipNet
+		return r, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		r.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return r, nil
+	}
+
+	r.domain = strings.TrimPrefix(strings.TrimPrefix(host, "*"), ".")
+	if r.domain == "" {
+		return rule{}, fmt.Errorf("%w: invalid bypass entry '%s'", ErrInvalidInput, entry)
+	}
+
+	return r, nil
+}
+
+func (r rule) matches(host, port string) bool {
+	if r.all {
+		return true
+	}
+	if r.port != "" && r.port != port {
+		return false
+	}
+
+	if r.ipNet != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && r.ipNet.Contains(ip)
+	}
+
+	return host == r.domain || strings.HasSuffix(host, "."+r.domain)
+}
+
+// hostPort returns the lower case host and the port of the url, using the
+// default port for the scheme if there isn't one.
+func hostPort(u *url.URL) (string, string) {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+
+	return strings.ToLower(u.Hostname()), port
+}
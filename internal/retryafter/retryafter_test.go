@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package retryafter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		value       string
+		expected    time.Duration
+	}{
+		{description: "empty"},
+		{description: "seconds", value: "120", expected: 2 * time.Minute},
+		{description: "seconds with spaces", value: " 120 ", expected: 2 * time.Minute},
+		{description: "negative seconds", value: "-5"},
+		{description: "http date", value: now.Add(time.Hour).Format(http.TimeFormat), expected: time.Hour},
+		{description: "http date in the past", value: now.Add(-time.Hour).Format(http.TimeFormat)},
+		{description: "invalid", value: "soon"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Parse(tc.value, now))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package websocket
+
+import (
+	"syscall"
+)
+
+// bindToDeviceSupported is true if SO_BINDTODEVICE is available.
+const bindToDeviceSupported = true
+
+// bindToDevice returns a net.Dialer Control function that binds the socket to
+// the named interface with SO_BINDTODEVICE.  This requires CAP_NET_RAW.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		ctrlErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package websocket
+
+import (
+	"syscall"
+)
+
+// bindToDeviceSupported is true if SO_BINDTODEVICE is available.
+const bindToDeviceSupported = false
+
+// bindToDevice is only supported on linux; the BindToDevice option prevents
+// it from being used elsewhere.
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return syscall.ENOTSUP
+	}
+}
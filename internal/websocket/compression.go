@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+// CompressionMode is the permessage-deflate (RFC 7692) compression mode
+// requested from the server.
+type CompressionMode string
+
+const (
+	// CompressionDisabled does not request compression.  This is the default.
+	CompressionDisabled CompressionMode = "disabled"
+
+	// CompressionContextTakeover compresses messages reusing the sliding
+	// window from previous messages.  It compresses better but keeps the
+	// compression state for the life of the connection.
+	CompressionContextTakeover CompressionMode = "context_takeover"
+
+	// CompressionNoContextTakeover compresses each message on its own, using
+	// less memory but compressing less efficiently.
+	CompressionNoContextTakeover CompressionMode = "no_context_takeover"
+)
+
+func (m CompressionMode) valid() bool {
+	switch m {
+	case CompressionDisabled, CompressionContextTakeover, CompressionNoContextTakeover:
+		return true
+	}
+	return false
+}
+
+func (m CompressionMode) toNHWS() nhws.CompressionMode {
+	switch m {
+	case CompressionContextTakeover:
+		return nhws.CompressionContextTakeover
+	case CompressionNoContextTakeover:
+		return nhws.CompressionNoContextTakeover
+	}
+	return nhws.CompressionDisabled
+}
+
+// negotiatedCompression returns the compression mode agreed with the server.
+func negotiatedCompression(conn *nhws.Conn) event.CompressionMode {
+	switch conn.CompressionMode() {
+	case nhws.CompressionContextTakeover:
+		return event.CompressionContextTakeover
+	case nhws.CompressionNoContextTakeover:
+		return event.CompressionNoContextTakeover
+	}
+	return event.CompressionDisabled
+}
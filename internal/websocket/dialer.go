@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is the time to wait for the preferred address family to
+// connect before also trying the other one.  This is the "Connection Attempt
+// Delay" recommended by RFC 8305.
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// dialFunc matches the signature of net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type dialResult struct {
+	conn net.Conn
+	mode ipMode
+	err  error
+}
+
+// happyEyeballs returns a dialFunc that races connections over both address
+// families in the style of RFC 8305.  The preferred family is dialed first and
+// the other is started after delay, or as soon as the preferred family fails.
+// The first connection made is used and won is called with its family; any
+// other connection is closed.
+func happyEyeballs(dial dialFunc, preferred ipMode, delay time.Duration, won func(ipMode)) dialFunc {
+	fallback := ipv4
+	if preferred == ipv4 {
+		fallback = ipv6
+	}
+
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make(chan dialResult)
+		start := func(mode ipMode) {
+			go func() {
+				conn, err := dial(ctx, string(mode), addr)
+				select {
+				case results <- dialResult{conn: conn, mode: mode, err: err}:
+				case <-ctx.Done():
+					if conn != nil {
+						_ = conn.Close()
+					}
+				}
+			}()
+		}
+
+		start(preferred)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		var errs []error
+		pending, fallbackStarted := 1, false
+		for pending > 0 || !fallbackStarted {
+			select {
+			case <-timer.C:
+				if !fallbackStarted {
+					fallbackStarted = true
+					pending++
+					start(fallback)
+				}
+			case r := <-results:
+				pending--
+				if r.err == nil {
+					won(r.mode)
+					return r.conn, nil
+				}
+				errs = append(errs, r.err)
+
+				// Don't wait for the delay if the preferred family failed.
+				if !fallbackStarted {
+					fallbackStarted = true
+					pending++
+					start(fallback)
+				}
+			case <-ctx.Done():
+				return nil, errors.Join(append(errs, ctx.Err())...)
+			}
+		}
+
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errV4 = errors.New("ipv4 failed")
+	errV6 = errors.New("ipv6 failed")
+)
+
+// fakeFamily describes how a fake dial over one address family behaves.
+type fakeFamily struct {
+	// after is how long the dial takes.
+	after time.Duration
+	// hang makes the dial block until the context is canceled.
+	hang bool
+	// err is returned instead of a connection.
+	err error
+}
+
+type fakeDialer struct {
+	families map[ipMode]fakeFamily
+
+	lock   sync.Mutex
+	dialed []ipMode
+}
+
+func (f *fakeDialer) dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	mode := ipMode(network)
+	f.lock.Lock()
+	f.dialed = append(f.dialed, mode)
+	f.lock.Unlock()
+
+	fam := f.families[mode]
+	if fam.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	select {
+	case <-time.After(fam.after):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if fam.err != nil {
+		return nil, fam.err
+	}
+
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (f *fakeDialer) Dialed() []ipMode {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]ipMode{}, f.dialed...)
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	tests := []struct {
+		description string
+		preferred   ipMode
+		families    map[ipMode]fakeFamily
+		expected    ipMode
+		dialed      []ipMode
+		expectedErr []error
+	}{
+		{
+			description: "preferred family connects first",
+			preferred:   ipv6,
+			families: map[ipMode]fakeFamily{
+				ipv6: {},
+			},
+			expected: ipv6,
+			dialed:   []ipMode{ipv6},
+		}, {
+			description: "preferred family hangs",
+			preferred:   ipv6,
+			families: map[ipMode]fakeFamily{
+				ipv6: {hang: true},
+				ipv4: {},
+			},
+			expected: ipv4,
+			dialed:   []ipMode{ipv6, ipv4},
+		}, {
+			description: "preferred family fails fast",
+			preferred:   ipv4,
+			families: map[ipMode]fakeFamily{
+				ipv4: {err: errV4},
+				ipv6: {},
+			},
+			expected: ipv6,
+			dialed:   []ipMode{ipv4, ipv6},
+		}, {
+			description: "slow preferred family still wins",
+			preferred:   ipv6,
+			families: map[ipMode]fakeFamily{
+				ipv6: {after: 100 * time.Millisecond},
+				ipv4: {hang: true},
+			},
+			expected: ipv6,
+			dialed:   []ipMode{ipv6, ipv4},
+		}, {
+			description: "both families fail",
+			preferred:   ipv6,
+			families: map[ipMode]fakeFamily{
+				ipv6: {err: errV6},
+				ipv4: {err: errV4},
+			},
+			dialed:      []ipMode{ipv6, ipv4},
+			expectedErr: []error{errV4, errV6},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			f := fakeDialer{families: tc.families}
+
+			var won ipMode
+			dial := happyEyeballs(f.dial, tc.preferred, 10*time.Millisecond,
+				func(m ipMode) {
+					won = m
+				})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			conn, err := dial(ctx, "tcp", "example.com:443")
+			assert.Equal(tc.dialed, f.Dialed())
+
+			if len(tc.expectedErr) > 0 {
+				for _, want := range tc.expectedErr {
+					assert.ErrorIs(err, want)
+				}
+				assert.Nil(conn)
+				assert.Empty(won)
+				return
+			}
+
+			require.NoError(err)
+			require.NotNil(conn)
+			assert.Equal(tc.expected, won)
+			_ = conn.Close()
+		})
+	}
+}
+
+func TestHappyEyeballsCanceled(t *testing.T) {
+	f := fakeDialer{families: map[ipMode]fakeFamily{
+		ipv6: {hang: true},
+		ipv4: {hang: true},
+	}}
+
+	dial := happyEyeballs(f.dial, ipv6, 10*time.Millisecond, func(ipMode) {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", "example.com:443")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, conn)
+}
@@ -5,12 +5,20 @@ package websocket_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -18,9 +26,12 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/arrange/arrangehttp"
+	"github.com/xmidt-org/arrange/arrangetls"
 	"github.com/xmidt-org/retry"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/proxy"
 	ws "github.com/xmidt-org/xmidt-agent/internal/websocket"
 	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
 )
@@ -502,3 +513,1012 @@ func TestEndToEndInactivityTimeout(t *testing.T) {
 	time.Sleep(400 * time.Millisecond)
 	got.Stop()
 }
+
+type switchableInterfaces struct {
+	lock   sync.Mutex
+	ifaces []net.Interface
+}
+
+func (s *switchableInterfaces) GetInterfaces() ([]net.Interface, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ifaces, nil
+}
+
+func (s *switchableInterfaces) Set(ifaces ...net.Interface) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ifaces = ifaces
+}
+
+func TestEndToEndInterfaceFailover(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ifaces, err := net.Interfaces()
+	require.NoError(err)
+
+	var lo net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			lo = iface
+		}
+	}
+	if lo.Name == "" {
+		t.Skip("no loopback interface available")
+	}
+
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				// Hold the connection open until the client closes it.
+				_, _, _ = c.Read(r.Context())
+			}))
+	defer s.Close()
+
+	available := switchableInterfaces{}
+	available.Set(lo)
+
+	var (
+		lock       sync.Mutex
+		used       []string
+		connects   []event.Connect
+		disconnect atomic.Int64
+	)
+
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.Interfaces(&available),
+		ws.InterfaceCheckInterval(20*time.Millisecond),
+		ws.InterfaceUsed(func(name string) {
+			lock.Lock()
+			used = append(used, name)
+			lock.Unlock()
+		}),
+		ws.AddConnectListener(
+			event.ConnectListenerFunc(
+				func(e event.Connect) {
+					lock.Lock()
+					connects = append(connects, e)
+					lock.Unlock()
+				})),
+		ws.AddDisconnectListener(
+			event.DisconnectListenerFunc(
+				func(event.Disconnect) {
+					disconnect.Add(1)
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: 50 * time.Millisecond,
+		}),
+		ws.WithIPv4(),
+		ws.WithIPv6(false),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	connected := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(connects) > 0
+	}
+	require.Eventually(connected, time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.NoError(connects[0].Err)
+	assert.Equal(lo.Name, connects[0].Interface)
+	assert.Equal([]string{lo.Name}, used)
+	lock.Unlock()
+
+	// Take the interface down; the connection should be closed and no new
+	// connection made since no interfaces remain.
+	available.Set()
+	require.Eventually(func() bool {
+		return disconnect.Load() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		last := connects[len(connects)-1]
+		return errors.Is(last.Err, ws.ErrNoInterface)
+	}
+	assert.Eventually(failed, time.Second, 10*time.Millisecond)
+}
+
+func TestEndToEndEndpointFailover(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	handler := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			c, err := websocket.Accept(w, r, nil)
+			require.NoError(err)
+			defer c.CloseNow()
+
+			// Hold the connection open until the client closes it.
+			_, _, _ = c.Read(r.Context())
+		})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	// Nothing is listening on the primary endpoint yet.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	addr := l.Addr().String()
+	primary := "http://" + addr
+	require.NoError(l.Close())
+
+	var (
+		lock     sync.Mutex
+		connects []event.Connect
+	)
+
+	got, err := ws.New(
+		ws.URL(primary),
+		ws.Endpoints(ws.Endpoint{URL: s.URL}),
+		ws.CircuitBreaker(1, 100*time.Millisecond),
+		ws.ReturnToPreferredInterval(50*time.Millisecond),
+		ws.DeviceID("mac:112233445566"),
+		ws.AddConnectListener(
+			event.ConnectListenerFunc(
+				func(e event.Connect) {
+					lock.Lock()
+					connects = append(connects, e)
+					lock.Unlock()
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: 10 * time.Millisecond,
+		}),
+		ws.WithIPv4(),
+		ws.WithIPv6(false),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	fellBack := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, e := range connects {
+			if e.Err == nil && e.Endpoint == s.URL {
+				return true
+			}
+		}
+		return false
+	}
+	require.Eventually(fellBack, time.Second, 10*time.Millisecond)
+
+	// The primary is still failing when its cooldown is over, so the probes
+	// fail and the connection to the fallback is kept.
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	last := connects[len(connects)-1]
+	lock.Unlock()
+	assert.NoError(last.Err)
+	assert.Equal(s.URL, last.Endpoint)
+
+	// Once the primary is listening the agent returns to it.
+	l, err = net.Listen("tcp", addr)
+	require.NoError(err)
+	p := httptest.NewUnstartedServer(handler)
+	p.Listener = l
+	p.Start()
+	defer p.Close()
+
+	returned := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		var fellBack bool
+		for _, e := range connects {
+			if e.Err == nil && e.Endpoint == s.URL {
+				fellBack = true
+			}
+			if fellBack && e.Err == nil && e.Endpoint == primary {
+				return true
+			}
+		}
+		return false
+	}
+	require.Eventually(returned, 2*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.NotEmpty(connects)
+	assert.Equal(primary, connects[0].Endpoint)
+	assert.Error(connects[0].Err)
+}
+
+func TestEndToEndRedirect(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				// Hold the connection open until the client closes it.
+				_, _, _ = c.Read(r.Context())
+			}))
+	defer s.Close()
+
+	// Nothing is listening on the configured endpoint.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	configured := "http://" + l.Addr().String()
+	require.NoError(l.Close())
+
+	connected := make(chan event.Connect, 10)
+
+	got, err := ws.New(
+		ws.URL(configured),
+		ws.FetchRedirect(func(context.Context) (ws.Redirect, error) {
+			return ws.Redirect{
+				Endpoints: []ws.Endpoint{{URL: s.URL}},
+				IPFamily:  "ipv4",
+				RetryPolicy: &retry.Config{
+					Interval: 10 * time.Millisecond,
+				},
+			}, nil
+		}),
+		ws.DeviceID("mac:112233445566"),
+		ws.AddConnectListener(
+			event.ConnectListenerFunc(
+				func(e event.Connect) {
+					connected <- e
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: time.Minute,
+		}),
+		ws.WithIPv4(),
+		ws.WithIPv6(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	// The redirect endpoint is used instead of the configured one, over the
+	// preferred family.
+	select {
+	case e := <-connected:
+		assert.NoError(e.Err)
+		assert.Equal(s.URL, e.Endpoint)
+		assert.Equal(event.IPv4, e.Mode)
+	case <-time.After(2 * time.Second):
+		require.FailNow("no connection was made")
+	}
+}
+
+func TestEndToEndFlushOnConnect(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	received := make(chan wrp.Message, 2)
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// Delay the connection so the messages are sent while the
+				// client is still disconnected.
+				time.Sleep(100 * time.Millisecond)
+
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				for {
+					_, b, err := c.Read(r.Context())
+					if err != nil {
+						return
+					}
+
+					var msg wrp.Message
+					require.NoError(wrp.NewDecoderBytes(b, wrp.Msgpack).Decode(&msg))
+					received <- msg
+				}
+			}))
+	defer s.Close()
+
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.FlushOnConnect(2),
+		ws.RetryPolicy(&retry.Config{
+			Interval: time.Second,
+		}),
+		ws.WithIPv4(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	var wg sync.WaitGroup
+	for _, source := range []string{"first", "second"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(got.Send(context.Background(),
+				wrp.Message{
+					Type:   wrp.SimpleEventMessageType,
+					Source: source,
+				}))
+		}()
+	}
+	wg.Wait()
+
+	sources := map[string]bool{}
+	for range 2 {
+		select {
+		case msg := <-received:
+			sources[msg.Source] = true
+		case <-time.After(time.Second):
+			assert.Fail("timed out waiting for messages")
+			return
+		}
+	}
+	assert.Equal(map[string]bool{"first": true, "second": true}, sources)
+}
+
+func TestEndToEndCompression(t *testing.T) {
+	tests := []struct {
+		description string
+		client      ws.CompressionMode
+		server      websocket.CompressionMode
+		expected    event.CompressionMode
+	}{
+		{
+			description: "disabled",
+			client:      ws.CompressionDisabled,
+			server:      websocket.CompressionContextTakeover,
+			expected:    event.CompressionDisabled,
+		}, {
+			description: "context takeover",
+			client:      ws.CompressionContextTakeover,
+			server:      websocket.CompressionContextTakeover,
+			expected:    event.CompressionContextTakeover,
+		}, {
+			description: "no context takeover",
+			client:      ws.CompressionNoContextTakeover,
+			server:      websocket.CompressionContextTakeover,
+			expected:    event.CompressionNoContextTakeover,
+		}, {
+			description: "server does not support compression",
+			client:      ws.CompressionContextTakeover,
+			server:      websocket.CompressionDisabled,
+			expected:    event.CompressionDisabled,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			received := make(chan wrp.Message, 1)
+			s := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						c, err := websocket.Accept(w, r,
+							&websocket.AcceptOptions{
+								CompressionMode: tc.server,
+							})
+						require.NoError(err)
+						defer c.CloseNow()
+
+						_, b, err := c.Read(r.Context())
+						if err != nil {
+							return
+						}
+
+						var msg wrp.Message
+						require.NoError(wrp.NewDecoderBytes(b, wrp.Msgpack).Decode(&msg))
+						received <- msg
+
+						// Hold the connection open until the client leaves.
+						_, _, _ = c.Read(r.Context())
+					}))
+			defer s.Close()
+
+			connected := make(chan event.Connect, 1)
+			got, err := ws.New(
+				ws.URL(s.URL),
+				ws.DeviceID("mac:112233445566"),
+				ws.Compression(tc.client, 1),
+				ws.AddConnectListener(
+					event.ConnectListenerFunc(
+						func(e event.Connect) {
+							if e.Err == nil {
+								connected <- e
+							}
+						})),
+				ws.RetryPolicy(&retry.Config{
+					Interval: time.Second,
+				}),
+				ws.WithIPv4(),
+				ws.NowFunc(time.Now),
+				ws.SendTimeout(time.Second),
+				ws.FetchURLTimeout(time.Second),
+				ws.CredentialsDecorator(func(h http.Header) error {
+					return nil
+				}),
+				ws.ConveyDecorator(func(h http.Header) error {
+					return nil
+				}),
+			)
+			require.NoError(err)
+			require.NotNil(got)
+
+			got.Start()
+			defer got.Stop()
+
+			select {
+			case e := <-connected:
+				assert.Equal(tc.expected, e.Compression)
+			case <-time.After(time.Second):
+				assert.Fail("timed out waiting to connect")
+				return
+			}
+
+			payload := strings.Repeat("compress me ", 100)
+			assert.NoError(got.Send(context.Background(),
+				wrp.Message{
+					Type:    wrp.SimpleEventMessageType,
+					Source:  "mac:112233445566",
+					Payload: []byte(payload),
+				}))
+
+			select {
+			case msg := <-received:
+				assert.Equal(payload, string(msg.Payload))
+			case <-time.After(time.Second):
+				assert.Fail("timed out waiting for the message")
+			}
+		})
+	}
+}
+
+func TestEndToEndStats(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				msg := wrp.Message{
+					Type:   wrp.SimpleEventMessageType,
+					Source: "server",
+				}
+				require.NoError(c.Write(r.Context(), websocket.MessageBinary, wrp.MustEncode(&msg, wrp.Msgpack)))
+
+				// Keep reading so the pings are answered.
+				for {
+					if _, _, err := c.Read(r.Context()); err != nil {
+						return
+					}
+				}
+			}))
+	defer s.Close()
+
+	received := make(chan struct{}, 1)
+	reported := make(chan event.Stats, 10)
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.MaxMessageBytes(256*1024),
+		ws.RTTInterval(10*time.Millisecond),
+		ws.StatsInterval(10*time.Millisecond),
+		ws.AddMessageListener(
+			event.MsgListenerFunc(
+				func(wrp.Message) {
+					received <- struct{}{}
+				})),
+		ws.AddStatsListener(
+			event.StatsListenerFunc(
+				func(e event.Stats) {
+					select {
+					case reported <- e:
+					default:
+					}
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: time.Second,
+		}),
+		ws.WithIPv4(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		require.Fail("timed out waiting for the message")
+	}
+
+	msg := wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "mac:112233445566",
+	}
+	require.NoError(got.Send(context.Background(), msg))
+
+	assert.Eventually(func() bool {
+		return got.Stats().PingsSent > 0
+	}, time.Second, 10*time.Millisecond)
+
+	stats := got.Stats()
+	assert.True(stats.Connected)
+	assert.Positive(stats.Uptime)
+	assert.Equal(1, stats.Connects)
+	assert.Equal(uint64(1), stats.MessagesSent)
+	assert.Equal(uint64(len(wrp.MustEncode(&msg, wrp.Msgpack))), stats.BytesSent)
+	assert.Equal(uint64(1), stats.MessagesReceived)
+	assert.Positive(stats.BytesReceived)
+	assert.Positive(stats.RTT)
+	assert.Zero(stats.PingFailures)
+
+	select {
+	case e := <-reported:
+		assert.True(e.Connected)
+	case <-time.After(time.Second):
+		assert.Fail("timed out waiting for stats")
+	}
+}
+
+func TestEndToEndDisconnectReason(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				c.Close(websocket.StatusPolicyViolation, "go away")
+			}))
+	defer s.Close()
+
+	disconnected := make(chan event.Disconnect, 1)
+	reasons := make(chan string, 1)
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.MaxMessageBytes(256*1024),
+		ws.AddDisconnectListener(
+			event.DisconnectListenerFunc(
+				func(e event.Disconnect) {
+					select {
+					case disconnected <- e:
+					default:
+					}
+				})),
+		ws.ReconnectReason(func(reason string) {
+			select {
+			case reasons <- reason:
+			default:
+			}
+		}),
+		ws.RetryPolicy(&retry.Config{
+			Interval: time.Second,
+		}),
+		ws.WithIPv4(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	select {
+	case e := <-disconnected:
+		assert.Equal(event.ReasonServerClose, e.Reason)
+		assert.Equal(int(websocket.StatusPolicyViolation), e.CloseCode)
+	case <-time.After(time.Second):
+		require.Fail("timed out waiting to disconnect")
+	}
+
+	select {
+	case reason := <-reasons:
+		assert.Equal("server_close:1008", reason)
+	case <-time.After(time.Second):
+		assert.Fail("timed out waiting for the reason")
+	}
+	assert.Equal("server_close:1008", got.LastReconnectReason())
+}
+
+func TestEndToEndRefreshCredentials(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var attempts atomic.Int64
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer fresh" {
+					attempts.Add(1)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				_, _, _ = c.Read(r.Context())
+			}))
+	defer s.Close()
+
+	var token atomic.Value
+	token.Store("stale")
+
+	var refreshed atomic.Int64
+	connected := make(chan struct{}, 1)
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.MaxMessageBytes(256*1024),
+		ws.CredentialsRefresher(func(context.Context) {
+			refreshed.Add(1)
+			token.Store("fresh")
+		}),
+		ws.AddConnectListener(
+			event.ConnectListenerFunc(
+				func(e event.Connect) {
+					if e.Err == nil {
+						connected <- struct{}{}
+					}
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: 10 * time.Millisecond,
+		}),
+		ws.WithIPv4(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			h.Set("Authorization", "Bearer "+token.Load().(string))
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		require.Fail("timed out waiting to connect")
+	}
+
+	assert.Equal(int64(1), attempts.Load())
+	assert.Equal(int64(1), refreshed.Load())
+}
+
+// newClientCert creates a self signed client certificate with the common name.
+func newClientCert(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func TestEndToEndClientCertificateRotation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	peers := make(chan string, 2)
+	s := httptest.NewUnstartedServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				peers <- r.TLS.PeerCertificates[0].Subject.CommonName
+
+				c, err := websocket.Accept(w, r, nil)
+				require.NoError(err)
+				defer c.CloseNow()
+
+				// Close the connection once a message arrives so the client
+				// reconnects.
+				if _, _, err := c.Read(r.Context()); err == nil {
+					c.Close(websocket.StatusGoingAway, "")
+				}
+			}))
+	s.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+	}
+	s.StartTLS()
+	defer s.Close()
+
+	var current atomic.Value
+	current.Store(newClientCert(t, "first"))
+
+	var disconnects atomic.Int64
+	got, err := ws.New(
+		ws.URL(s.URL),
+		ws.DeviceID("mac:112233445566"),
+		ws.MaxMessageBytes(256*1024),
+		ws.HTTPClient(arrangehttp.ClientConfig{
+			TLS: &arrangetls.Config{
+				InsecureSkipVerify: true,
+			},
+		}),
+		ws.ClientCertificates(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert := current.Load().(tls.Certificate)
+			return &cert, nil
+		}),
+		ws.AddDisconnectListener(
+			event.DisconnectListenerFunc(
+				func(event.Disconnect) {
+					disconnects.Add(1)
+				})),
+		ws.RetryPolicy(&retry.Config{
+			Interval: 10 * time.Millisecond,
+		}),
+		ws.WithIPv4(),
+		ws.NowFunc(time.Now),
+		ws.SendTimeout(time.Second),
+		ws.FetchURLTimeout(time.Second),
+		ws.CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ws.ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+	)
+	require.NoError(err)
+	require.NotNil(got)
+
+	got.Start()
+	defer got.Stop()
+
+	select {
+	case cn := <-peers:
+		assert.Equal("first", cn)
+	case <-time.After(time.Second):
+		require.Fail("timed out waiting to connect")
+	}
+
+	// Rotating the certificate does not affect the current connection.
+	current.Store(newClientCert(t, "second"))
+	assert.Eventually(func() bool {
+		return got.SendAndWait(context.Background(), wrp.Message{
+			Type:   wrp.SimpleEventMessageType,
+			Source: "mac:112233445566",
+		}) == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Zero(disconnects.Load())
+
+	select {
+	case cn := <-peers:
+		assert.Equal("second", cn)
+	case <-time.After(time.Second):
+		assert.Fail("timed out waiting to reconnect")
+	}
+}
+
+func TestEndToEndProxy(t *testing.T) {
+	tests := []struct {
+		description string
+		bypass      []string
+		proxied     bool
+	}{
+		{
+			description: "through the proxy",
+			proxied:     true,
+		}, {
+			description: "bypassing the proxy",
+			bypass:      []string{"127.0.0.1"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			s := httptest.NewTLSServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						c, err := websocket.Accept(w, r, nil)
+						require.NoError(err)
+						defer c.CloseNow()
+
+						msg := wrp.Message{
+							Type:   wrp.SimpleEventMessageType,
+							Source: "server",
+						}
+						_ = c.Write(r.Context(), websocket.MessageBinary, wrp.MustEncode(&msg, wrp.Msgpack))
+						_, _, _ = c.Read(r.Context())
+					}))
+			defer s.Close()
+
+			var tunnels atomic.Int64
+			p := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						if r.Method != http.MethodConnect {
+							w.WriteHeader(http.StatusMethodNotAllowed)
+							return
+						}
+						if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" { // user:pass
+							w.WriteHeader(http.StatusProxyAuthRequired)
+							return
+						}
+
+						dst, err := net.Dial("tcp", r.Host)
+						if err != nil {
+							w.WriteHeader(http.StatusBadGateway)
+							return
+						}
+						defer dst.Close()
+
+						conn, buf, err := w.(http.Hijacker).Hijack()
+						require.NoError(err)
+						defer conn.Close()
+
+						tunnels.Add(1)
+						_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+						go func() {
+							_, _ = io.Copy(dst, buf)
+							dst.Close()
+						}()
+						_, _ = io.Copy(conn, dst)
+					}))
+			defer p.Close()
+
+			proxyFunc, err := proxy.Config{
+				URL:      p.URL,
+				Username: "user",
+				Password: "pass",
+				Bypass:   tc.bypass,
+			}.ProxyFunc()
+			require.NoError(err)
+
+			received := make(chan wrp.Message, 1)
+			got, err := ws.New(
+				ws.URL(s.URL),
+				ws.DeviceID("mac:112233445566"),
+				ws.MaxMessageBytes(256*1024),
+				ws.HTTPClient(arrangehttp.ClientConfig{
+					TLS: &arrangetls.Config{
+						InsecureSkipVerify: true,
+					},
+				}),
+				ws.Proxy(proxyFunc),
+				ws.AddMessageListener(
+					event.MsgListenerFunc(
+						func(m wrp.Message) {
+							received <- m
+						})),
+				ws.RetryPolicy(&retry.Config{
+					Interval: time.Second,
+				}),
+				ws.WithIPv4(),
+				ws.NowFunc(time.Now),
+				ws.SendTimeout(time.Second),
+				ws.FetchURLTimeout(time.Second),
+				ws.CredentialsDecorator(func(h http.Header) error {
+					return nil
+				}),
+				ws.ConveyDecorator(func(h http.Header) error {
+					return nil
+				}),
+			)
+			require.NoError(err)
+			require.NotNil(got)
+
+			got.Start()
+			defer got.Stop()
+
+			select {
+			case msg := <-received:
+				assert.Equal("server", msg.Source)
+			case <-time.After(time.Second):
+				require.Fail("timed out waiting for the message")
+			}
+
+			if tc.proxied {
+				assert.Equal(int64(1), tunnels.Load())
+			} else {
+				assert.Zero(tunnels.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/retry"
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+const (
+	// defaultFailureThreshold is the number of consecutive failures before an
+	// endpoint is avoided.
+	defaultFailureThreshold = 3
+
+	// defaultCooldown is how long an endpoint is avoided once it has failed
+	// too many times.
+	defaultCooldown = time.Minute
+
+	// defaultReturnInterval is how often a connection to a fallback endpoint
+	// checks if a preferred endpoint is healthy again.
+	defaultReturnInterval = 10 * time.Minute
+)
+
+// Endpoint is an additional XMiDT service endpoint the websocket may connect
+// to when the URL (or FetchURL) endpoint is unhealthy.
+type Endpoint struct {
+	// URL is the websocket url of the endpoint.
+	URL string
+
+	// Priority orders the endpoints; lower values are preferred.  The URL
+	// (or FetchURL) endpoint is always preferred over these endpoints.
+	Priority int
+
+	// Weight is the relative share of connections an endpoint gets among the
+	// healthy endpoints with the same priority.  Zero is treated as 1.
+	Weight int
+}
+
+// Redirect is connection guidance from the XMiDT service, for example from a
+// signed jwtxt record.  It overrides the configured values while it is in
+// effect.
+type Redirect struct {
+	// Endpoints, if not empty, replace the URL (or FetchURL) endpoint.  The
+	// Endpoints option endpoints are still used as fallbacks.
+	Endpoints []Endpoint
+
+	// IPFamily is the preferred address family, "ipv4" or "ipv6".  Empty
+	// means no preference.  A family that isn't allowed is ignored.
+	IPFamily string
+
+	// RetryPolicy, if not nil, replaces the RetryPolicy.
+	RetryPolicy *retry.Config
+}
+
+// endpoint tracks the health of a single endpoint.
+type endpoint struct {
+	fetch    func(context.Context) (string, error)
+	url      string
+	fallback bool
+	priority int
+	weight   int
+
+	failures  int
+	openUntil time.Time
+}
+
+// endpoints chooses the endpoint to connect to.  Endpoints that fail
+// threshold times in a row are avoided (the circuit is open) for the cooldown
+// period, after which one more attempt is allowed.
+//
+// The URL (or FetchURL) endpoint, or the endpoints of a Redirect in its place,
+// are always preferred over the fallback endpoints.
+type endpoints struct {
+	lock       sync.Mutex
+	list       []*endpoint
+	primary    *endpoint
+	redirected []*endpoint
+	fallbacks  []*endpoint
+	threshold  int
+	cooldown   time.Duration
+	intN       func(int) int
+}
+
+func newEndpoints(primary func(context.Context) (string, error), extra []Endpoint, threshold int, cooldown time.Duration) *endpoints {
+	e := endpoints{
+		threshold: threshold,
+		cooldown:  cooldown,
+		intN:      rand.IntN,
+	}
+
+	if primary != nil {
+		e.primary = &endpoint{
+			fetch: primary,
+		}
+	}
+
+	for _, ep := range extra {
+		fallback := newEndpoint(ep)
+		fallback.fallback = true
+		e.fallbacks = append(e.fallbacks, fallback)
+	}
+
+	e.rebuild()
+
+	return &e
+}
+
+func newEndpoint(ep Endpoint) *endpoint {
+	url := ep.URL
+	return &endpoint{
+		fetch: func(context.Context) (string, error) {
+			return url, nil
+		},
+		url:      url,
+		priority: ep.Priority,
+		weight:   ep.Weight,
+	}
+}
+
+// redirect replaces the URL (or FetchURL) endpoint with the endpoints, or
+// restores it if there are none.  Endpoints that are already known keep their
+// health.
+func (e *endpoints) redirect(eps []Endpoint) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	known := make(map[string]*endpoint, len(e.redirected))
+	for _, ep := range e.redirected {
+		known[ep.url] = ep
+	}
+
+	e.redirected = nil
+	for _, ep := range eps {
+		if ep.URL == "" || ep.Weight < 0 {
+			continue
+		}
+
+		existing, found := known[ep.URL]
+		if found {
+			existing.priority = ep.Priority
+			existing.weight = ep.Weight
+			e.redirected = append(e.redirected, existing)
+			continue
+		}
+		e.redirected = append(e.redirected, newEndpoint(ep))
+	}
+
+	e.rebuild()
+}
+
+// rebuild updates the list of endpoints to choose from.  The caller must hold
+// the lock, if the endpoints are shared.
+func (e *endpoints) rebuild() {
+	e.list = e.list[:0]
+	switch {
+	case len(e.redirected) > 0:
+		e.list = append(e.list, e.redirected...)
+	case e.primary != nil:
+		e.list = append(e.list, e.primary)
+	}
+	e.list = append(e.list, e.fallbacks...)
+}
+
+// before returns true if ep is preferred over other.
+func (ep *endpoint) before(other *endpoint) bool {
+	if ep.fallback != other.fallback {
+		return other.fallback
+	}
+	return ep.priority < other.priority
+}
+
+// choose returns the endpoint to try next.  The healthy endpoints with the
+// best priority are chosen from by weight.  If every endpoint is unhealthy,
+// the one that will recover soonest is returned.
+func (e *endpoints) choose(now time.Time) *endpoint {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var (
+		best    []*endpoint
+		total   int
+		soonest *endpoint
+	)
+	for _, ep := range e.list {
+		if ep.open(now) {
+			if soonest == nil || ep.openUntil.Before(soonest.openUntil) {
+				soonest = ep
+			}
+			continue
+		}
+
+		if len(best) > 0 && best[0].before(ep) {
+			continue
+		}
+		if len(best) > 0 && ep.before(best[0]) {
+			best, total = nil, 0
+		}
+		best = append(best, ep)
+		total += ep.share()
+	}
+
+	if len(best) == 0 {
+		return soonest
+	}
+
+	n := e.intN(total)
+	for _, ep := range best {
+		n -= ep.share()
+		if n < 0 {
+			return ep
+		}
+	}
+
+	return best[len(best)-1]
+}
+
+// succeeded records a successful connection to the endpoint.
+func (e *endpoints) succeeded(ep *endpoint) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ep.failures = 0
+	ep.openUntil = time.Time{}
+}
+
+// failed records a failed attempt to connect to the endpoint.
+func (e *endpoints) failed(ep *endpoint, now time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ep.failures++
+	if ep.failures >= e.threshold {
+		ep.openUntil = now.Add(e.cooldown)
+	}
+}
+
+// better returns the most preferred endpoint that is healthy and has a
+// better priority than ep, or nil if there is none.
+func (e *endpoints) better(ep *endpoint, now time.Time) *endpoint {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var best *endpoint
+	for _, other := range e.list {
+		if !other.before(ep) || other.open(now) {
+			continue
+		}
+		if best == nil || other.before(best) {
+			best = other
+		}
+	}
+
+	return best
+}
+
+func (ep *endpoint) open(now time.Time) bool {
+	return now.Before(ep.openUntil)
+}
+
+func (ep *endpoint) share() int {
+	if ep.weight <= 0 {
+		return 1
+	}
+	return ep.weight
+}
+
+// watchEndpoint periodically checks if a better endpoint than the fallback
+// endpoint the connection was made to is healthy again.  The better endpoint
+// is probed with a connection of its own first, and only if that succeeds is
+// the connection closed so the next connection can return to it.  A failed
+// probe counts against the better endpoint like any other failed connection.
+func (ws *Websocket) watchEndpoint(ctx context.Context, conn *nhws.Conn, used dialed) {
+	if ws.returnInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ws.returnInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ws.probe(ctx, used) {
+				ws.closeConn(conn, event.ReasonEndpointChange, nhws.StatusGoingAway, "returning to preferred endpoint")
+				return
+			}
+		}
+	}
+}
+
+// probe returns true if a better endpoint than the one used is healthy and
+// a connection to it can be made.  The probe connection is closed right away.
+func (ws *Websocket) probe(ctx context.Context, used dialed) bool {
+	ep := ws.endpoints.better(used.endpoint, ws.nowFunc())
+	if ep == nil {
+		return false
+	}
+
+	conn, _, _, err := ws.dialEndpoint(ctx, ep, used.mode)
+	if err != nil {
+		return false
+	}
+
+	_ = conn.Close(nhws.StatusNormalClosure, "probe")
+	return true
+}
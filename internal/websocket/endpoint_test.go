@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/retry"
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+)
+
+func endpointURL(t *testing.T, ep *endpoint) string {
+	require.NotNil(t, ep)
+	url, err := ep.fetch(context.Background())
+	require.NoError(t, err)
+	return url
+}
+
+func TestEndpointsChoose(t *testing.T) {
+	primary := func(context.Context) (string, error) {
+		return "ws://primary", nil
+	}
+	extra := []Endpoint{
+		{URL: "ws://a", Priority: 1, Weight: 1},
+		{URL: "ws://b", Priority: 1, Weight: 3},
+		{URL: "ws://c", Priority: 2},
+	}
+
+	tests := []struct {
+		description string
+		primary     func(context.Context) (string, error)
+		fail        []string
+		pick        int
+		expected    string
+	}{
+		{
+			description: "primary is preferred",
+			primary:     primary,
+			expected:    "ws://primary",
+		}, {
+			description: "lowest priority without a primary",
+			expected:    "ws://a",
+		}, {
+			description: "weighted choice",
+			pick:        1,
+			expected:    "ws://b",
+		}, {
+			description: "weighted choice, last share",
+			pick:        3,
+			expected:    "ws://b",
+		}, {
+			description: "skip the open circuit",
+			primary:     primary,
+			fail:        []string{"ws://primary"},
+			expected:    "ws://a",
+		}, {
+			description: "next priority when a priority is unhealthy",
+			primary:     primary,
+			fail:        []string{"ws://primary", "ws://a", "ws://b"},
+			expected:    "ws://c",
+		}, {
+			description: "soonest to recover when all are unhealthy",
+			primary:     primary,
+			fail:        []string{"ws://c", "ws://primary", "ws://a", "ws://b"},
+			expected:    "ws://c",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			now := time.Now()
+			e := newEndpoints(tc.primary, extra, 1, time.Minute)
+			e.intN = func(int) int { return tc.pick }
+
+			for i, url := range tc.fail {
+				for _, ep := range e.list {
+					if endpointURL(t, ep) == url {
+						e.failed(ep, now.Add(time.Duration(i)*time.Second))
+					}
+				}
+			}
+
+			assert.Equal(tc.expected, endpointURL(t, e.choose(now)))
+		})
+	}
+}
+
+func TestEndpointsCircuit(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	e := newEndpoints(
+		func(context.Context) (string, error) {
+			return "ws://primary", nil
+		},
+		[]Endpoint{{URL: "ws://backup"}},
+		2, time.Minute)
+
+	primary, backup := e.list[0], e.list[1]
+
+	// One failure is not enough to open the circuit.
+	e.failed(primary, now)
+	assert.Equal(primary, e.choose(now))
+	assert.NotNil(e.better(backup, now))
+
+	// The second failure opens it.
+	e.failed(primary, now)
+	assert.Equal(backup, e.choose(now))
+	assert.Nil(e.better(backup, now))
+
+	// Once the cooldown passes the primary is tried again.
+	later := now.Add(time.Minute)
+	assert.Equal(primary, e.choose(later))
+	assert.NotNil(e.better(backup, later))
+
+	// Another failure opens the circuit again right away.
+	e.failed(primary, later)
+	assert.Equal(backup, e.choose(later))
+
+	// A success closes the circuit.
+	e.succeeded(primary)
+	assert.Equal(primary, e.choose(later))
+	assert.Nil(e.better(primary, later))
+}
+
+func TestEndpointsRedirect(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	e := newEndpoints(
+		func(context.Context) (string, error) {
+			return "ws://primary", nil
+		},
+		[]Endpoint{{URL: "ws://fallback"}},
+		1, time.Minute)
+	e.intN = func(int) int { return 0 }
+
+	// The redirect endpoints replace the primary, but not the fallbacks.
+	e.redirect([]Endpoint{
+		{URL: "ws://a", Priority: 1},
+		{URL: "ws://b", Priority: 2},
+		{URL: "", Priority: 0},
+	})
+	assert.Len(e.list, 3)
+	assert.Equal("ws://a", endpointURL(t, e.choose(now)))
+
+	// A fallback with a better priority is still only used after them.
+	a := e.choose(now)
+	e.failed(a, now)
+	assert.Equal("ws://b", endpointURL(t, e.choose(now)))
+	b := e.choose(now)
+	e.failed(b, now)
+	assert.Equal("ws://fallback", endpointURL(t, e.choose(now)))
+
+	// The same redirect keeps the health of its endpoints.
+	e.redirect([]Endpoint{
+		{URL: "ws://a", Priority: 1},
+		{URL: "ws://b", Priority: 2},
+	})
+	assert.Equal("ws://fallback", endpointURL(t, e.choose(now)))
+	assert.NotNil(e.better(e.choose(now), now.Add(time.Minute)))
+
+	// Without a redirect the primary is used again.
+	e.redirect(nil)
+	assert.Len(e.list, 2)
+	assert.Equal("ws://primary", endpointURL(t, e.choose(now)))
+}
+
+func TestProbe(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		failing  atomic.Bool
+		attempts atomic.Int32
+	)
+	s := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				if failing.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				c, err := nhws.Accept(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer c.CloseNow()
+
+				_, _, _ = c.Read(context.Background())
+			}))
+	defer s.Close()
+
+	now := time.Now()
+	got, err := New(
+		URL(s.URL),
+		DeviceID("mac:112233445566"),
+		WithIPv4(),
+		NowFunc(func() time.Time { return now }),
+		RetryPolicy(retry.Config{}),
+		CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+		Endpoints(Endpoint{URL: "ws://fallback"}),
+		CircuitBreaker(1, time.Minute),
+	)
+	require.NoError(err)
+
+	ctx := context.Background()
+	primary, fallback := got.endpoints.list[0], got.endpoints.list[1]
+	used := dialed{mode: ipv4, endpoint: fallback}
+
+	// The primary isn't probed while its circuit is open.
+	got.endpoints.failed(primary, now)
+	assert.False(got.probe(ctx, used))
+	assert.Zero(attempts.Load())
+
+	// Once the cooldown passes the primary is probed, but it is still
+	// failing, so the connection to the fallback is kept and the circuit
+	// opens again.
+	failing.Store(true)
+	now = now.Add(time.Minute)
+	assert.False(got.probe(ctx, used))
+	assert.Equal(int32(1), attempts.Load())
+	assert.Nil(got.endpoints.better(fallback, now))
+
+	// After the next cooldown the primary has recovered.
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	assert.True(got.probe(ctx, used))
+	assert.Equal(int32(2), attempts.Load())
+	assert.Zero(primary.failures)
+
+	// Nothing is better than the primary.
+	assert.False(got.probe(ctx, dialed{mode: ipv4, endpoint: primary}))
+	assert.Equal(int32(2), attempts.Load())
+}
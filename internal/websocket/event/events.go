@@ -26,6 +26,58 @@ const (
 	IPv6 IPMode = "IPv6"
 )
 
+// CompressionMode is the permessage-deflate compression mode negotiated with
+// the server.
+type CompressionMode string
+
+const (
+	CompressionDisabled          CompressionMode = "disabled"
+	CompressionContextTakeover   CompressionMode = "context_takeover"
+	CompressionNoContextTakeover CompressionMode = "no_context_takeover"
+)
+
+// DisconnectReason classifies why a connection ended or a connection attempt
+// failed.
+type DisconnectReason string
+
+const (
+	// ReasonInactivityTimeout is used when no PING was received from the
+	// server within the inactivity timeout.
+	ReasonInactivityTimeout DisconnectReason = "inactivity_timeout"
+
+	// ReasonPingFailure is used when the PONG in response to a PING could not
+	// be written.
+	ReasonPingFailure DisconnectReason = "ping_failure"
+
+	// ReasonServerClose is used when the server closed the connection with a
+	// close code.
+	ReasonServerClose DisconnectReason = "server_close"
+
+	// ReasonDecodeError is used when a message from the server could not be
+	// decoded.
+	ReasonDecodeError DisconnectReason = "decode_error"
+
+	// ReasonDNSFailure is used when the server's name could not be resolved.
+	ReasonDNSFailure DisconnectReason = "dns_failure"
+
+	// ReasonTLSFailure is used when the TLS handshake with the server failed.
+	ReasonTLSFailure DisconnectReason = "tls_failure"
+
+	// ReasonInterfaceChange is used when the network interface of the
+	// connection went away, or no interface was available.
+	ReasonInterfaceChange DisconnectReason = "interface_change"
+
+	// ReasonEndpointChange is used when the connection was closed to return
+	// to a preferred endpoint.
+	ReasonEndpointChange DisconnectReason = "endpoint_change"
+
+	// ReasonConnectFailure is used for any other failed connection attempt.
+	ReasonConnectFailure DisconnectReason = "connect_failure"
+
+	// ReasonConnectionError is used for any other error on the connection.
+	ReasonConnectionError DisconnectReason = "connection_error"
+)
+
 // CancelFunc is the interface that provides a method to cancel a listener.
 type CancelFunc func()
 
@@ -36,12 +88,29 @@ type Connect struct {
 	// At holds the time when the connection was made/errored out.
 	At time.Time
 
-	// Mode is the IP mode used to connect.
+	// Mode is the IP mode used to connect.  When both IP modes are raced, it
+	// is the mode of the connection that was made, or the preferred mode if
+	// the attempt failed.
 	Mode IPMode
 
+	// Compression is the compression mode negotiated with the server.
+	Compression CompressionMode
+
+	// Endpoint is the url of the endpoint the connection was made to, or was
+	// attempted to.
+	Endpoint string
+
+	// Interface is the name of the network interface the connection was made
+	// over, or last attempted over if the attempt failed.  It is empty if the
+	// connection is not pinned to an interface.
+	Interface string
+
 	// RetryingAt is the time when the next connection attempt will be made.
 	RetryingAt time.Time
 
+	// Reason classifies why the attempt to connect failed.
+	Reason DisconnectReason
+
 	// Error is the error returned from the attempt to connect.
 	Err error
 }
@@ -52,9 +121,21 @@ func (c Connect) String() string {
 	fmt.Fprintf(&buf, "  Started:    %s\n", c.Started.Format(time.RFC3339Nano))
 	fmt.Fprintf(&buf, "  At:         %s (%s)\n", c.At.Format(time.RFC3339Nano), c.At.Sub(c.Started))
 	fmt.Fprintf(&buf, "  Mode:       %s\n", string(c.Mode))
+	if c.Compression != "" {
+		fmt.Fprintf(&buf, "  Compress:   %s\n", string(c.Compression))
+	}
+	if c.Endpoint != "" {
+		fmt.Fprintf(&buf, "  Endpoint:   %s\n", c.Endpoint)
+	}
+	if c.Interface != "" {
+		fmt.Fprintf(&buf, "  Interface:  %s\n", c.Interface)
+	}
 	if !c.RetryingAt.IsZero() {
 		fmt.Fprintf(&buf, "  RetryingAt: %s\n", c.RetryingAt.Format(time.RFC3339Nano))
 	}
+	if c.Reason != "" {
+		fmt.Fprintf(&buf, "  Reason:     %s\n", string(c.Reason))
+	}
 	if c.Err != nil {
 		fmt.Fprintf(&buf, "  Err:        %s\n", c.Err)
 	}
@@ -108,6 +189,13 @@ type Disconnect struct {
 	// At holds the time when the connection was closed.
 	At time.Time
 
+	// Reason classifies why the connection was closed.
+	Reason DisconnectReason
+
+	// CloseCode is the close code sent by the server when Reason is
+	// ReasonServerClose, otherwise it is zero.
+	CloseCode int
+
 	// Error is the error returned from the disconnection.
 	Err error
 }
@@ -127,6 +215,95 @@ func (f DisconnectListenerFunc) OnDisconnect(d Disconnect) {
 	f(d)
 }
 
+// Stats is a snapshot of the quality of the websocket connection.  The
+// counters are totals since the websocket was created.
+type Stats struct {
+	// At holds the time when the snapshot was taken.
+	At time.Time
+
+	// Connected is true if the websocket is connected.
+	Connected bool
+
+	// ConnectedSince is the time the current connection was made, or the zero
+	// time if the websocket is not connected.
+	ConnectedSince time.Time
+
+	// Uptime is how long the current connection has been up.
+	Uptime time.Duration
+
+	// Connects is the number of connections that have been made.
+	Connects int
+
+	// Reconnects is the number of connections made after the first one.
+	Reconnects int
+
+	// BytesSent and BytesReceived are the number of message bytes written to
+	// and read from the websocket.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// MessagesSent and MessagesReceived are the number of messages written to
+	// and read from the websocket.
+	MessagesSent     uint64
+	MessagesReceived uint64
+
+	// PingsSent is the number of pings sent to measure the round trip time,
+	// and PingFailures is how many of them did not get a pong in time.
+	PingsSent    uint64
+	PingFailures uint64
+
+	// RTT is the most recent round trip time measured.  SmoothedRTT is the
+	// weighted average of the measurements, and MinRTT and MaxRTT are the
+	// extremes.  They are zero until the first measurement.
+	RTT         time.Duration
+	SmoothedRTT time.Duration
+	MinRTT      time.Duration
+	MaxRTT      time.Duration
+}
+
+// StatsListener is the interface that must be implemented by types that want
+// to receive periodic Stats notifications.
+type StatsListener interface {
+	OnStats(Stats)
+}
+
+// StatsListenerFunc is a function type that implements StatsListener.  It can
+// be used as an adapter for functions that need to implement the StatsListener
+// interface.
+type StatsListenerFunc func(Stats)
+
+func (f StatsListenerFunc) OnStats(s Stats) {
+	f(s)
+}
+
+// SendFailure is a message queued by Send that could not be written to the
+// websocket.
+type SendFailure struct {
+	// At holds the time when the write failed.
+	At time.Time
+
+	// Message is the message that was not written.
+	Message wrp.Message
+
+	// Err is the reason the message was not written.
+	Err error
+}
+
+// SendFailureListener is the interface that must be implemented by types that
+// want to receive SendFailure notifications.
+type SendFailureListener interface {
+	OnSendFailure(SendFailure)
+}
+
+// SendFailureListenerFunc is a function type that implements
+// SendFailureListener.  It can be used as an adapter for functions that need
+// to implement the SendFailureListener interface.
+type SendFailureListenerFunc func(SendFailure)
+
+func (f SendFailureListenerFunc) OnSendFailure(s SendFailure) {
+	f(s)
+}
+
 // MsgListener is the interface that must be implemented by types that want
 // to receive wrp.Message notifications from the websocket.
 type MsgListener interface {
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+// defaultInterfaceCheckInterval is how often the interface a connection was
+// made over is checked to see if it is still available.
+const defaultInterfaceCheckInterval = 10 * time.Second
+
+// InterfaceProvider provides the network interfaces the websocket may connect
+// over.  The interfaces are returned in priority order and only running
+// interfaces are included.
+type InterfaceProvider interface {
+	GetInterfaces() ([]net.Interface, error)
+}
+
+// candidateInterfaces returns the interfaces to try connecting over, in
+// priority order.  If no interface provider is configured, a single nil
+// interface is returned, meaning any interface may be used.
+func (ws *Websocket) candidateInterfaces() ([]*net.Interface, error) {
+	if ws.interfaces == nil {
+		return []*net.Interface{nil}, nil
+	}
+
+	ifaces, err := ws.interfaces.GetInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("%w: no running network interfaces", ErrNoInterface)
+	}
+
+	list := make([]*net.Interface, 0, len(ifaces))
+	for i := range ifaces {
+		list = append(list, &ifaces[i])
+	}
+
+	return list, nil
+}
+
+// interfaceAvailable returns false if the named interface is no longer one of
+// the interfaces the websocket may connect over.  If the interfaces can't be
+// determined, the interface is assumed to still be available.
+func (ws *Websocket) interfaceAvailable(name string) bool {
+	ifaces, err := ws.interfaces.GetInterfaces()
+	if err != nil {
+		return true
+	}
+
+	for _, iface := range ifaces {
+		if iface.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchInterface closes the connection if the named interface it was made
+// over goes down, so a new connection can be made over the next interface.
+func (ws *Websocket) watchInterface(ctx context.Context, conn *nhws.Conn, name string) {
+	if name == "" || ws.interfaceCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ws.interfaceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !ws.interfaceAvailable(name) {
+				ws.closeConn(conn, event.ReasonInterfaceChange, nhws.StatusGoingAway, "network interface down")
+				return
+			}
+		}
+	}
+}
+
+// bindDialer returns a dialFunc that binds connections to the interface,
+// either with SO_BINDTODEVICE or by using the interface's address as the
+// source address.
+func (ws *Websocket) bindDialer(dialer *net.Dialer, iface *net.Interface) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		bound := *dialer
+		if ws.bindToDevice {
+			bound.Control = bindToDevice(iface.Name)
+		} else {
+			local, err := sourceAddr(iface, ipMode(network))
+			if err != nil {
+				return nil, err
+			}
+			bound.LocalAddr = local
+		}
+
+		return bound.DialContext(ctx, network, addr)
+	}
+}
+
+// sourceAddr returns the first address of the interface that belongs to the
+// address family of the mode.  Link-local addresses are skipped since they
+// can't reach the server.
+func sourceAddr(iface *net.Interface, mode ipMode) (*net.TCPAddr, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipNet.IP
+		if ip.IsLinkLocalUnicast() || (ip.To4() != nil) != (mode == ipv4) {
+			continue
+		}
+
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	return nil, fmt.Errorf("%w: interface '%s' has no %s address",
+		ErrNoInterface, iface.Name, mode.ToEvent())
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeInterfaces struct {
+	ifaces []net.Interface
+	err    error
+}
+
+func (f fakeInterfaces) GetInterfaces() ([]net.Interface, error) {
+	return f.ifaces, f.err
+}
+
+func loopback(t *testing.T) net.Interface {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface
+		}
+	}
+
+	t.Skip("no loopback interface available")
+	return net.Interface{}
+}
+
+func TestCandidateInterfaces(t *testing.T) {
+	tests := []struct {
+		description string
+		interfaces  InterfaceProvider
+		expected    []string
+		expectedErr error
+	}{
+		{
+			description: "no provider allows any interface",
+			expected:    []string{""},
+		}, {
+			description: "interfaces in priority order",
+			interfaces: fakeInterfaces{
+				ifaces: []net.Interface{{Name: "eth0"}, {Name: "erouter0"}},
+			},
+			expected: []string{"eth0", "erouter0"},
+		}, {
+			description: "no running interfaces",
+			interfaces:  fakeInterfaces{},
+			expectedErr: ErrNoInterface,
+		}, {
+			description: "provider error",
+			interfaces:  fakeInterfaces{err: errUnknown},
+			expectedErr: errUnknown,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ws := Websocket{interfaces: tc.interfaces}
+			got, err := ws.candidateInterfaces()
+			assert.ErrorIs(err, tc.expectedErr)
+
+			var names []string
+			for _, iface := range got {
+				if iface == nil {
+					names = append(names, "")
+					continue
+				}
+				names = append(names, iface.Name)
+			}
+			assert.Equal(tc.expected, names)
+		})
+	}
+}
+
+func TestInterfaceAvailable(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := Websocket{
+		interfaces: fakeInterfaces{
+			ifaces: []net.Interface{{Name: "eth0"}},
+		},
+	}
+	assert.True(ws.interfaceAvailable("eth0"))
+	assert.False(ws.interfaceAvailable("erouter0"))
+
+	// Lookup failures don't tear down the connection.
+	ws.interfaces = fakeInterfaces{err: errUnknown}
+	assert.True(ws.interfaceAvailable("erouter0"))
+}
+
+func TestSourceAddr(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	lo := loopback(t)
+
+	addr, err := sourceAddr(&lo, ipv4)
+	if err != nil {
+		t.Skip("loopback interface has no IPv4 address")
+	}
+	require.NotNil(addr)
+	assert.True(addr.IP.IsLoopback())
+	assert.NotNil(addr.IP.To4())
+
+	bogus := net.Interface{Index: 1 << 20, Name: "bogus0"}
+	_, err = sourceAddr(&bogus, ipv4)
+	assert.Error(err)
+}
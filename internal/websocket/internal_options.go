@@ -20,7 +20,7 @@ func validateDeviceID() Option {
 func validateURL() Option {
 	return optionFunc(
 		func(c *Websocket) error {
-			if c.urlFetcher == nil {
+			if c.urlFetcher == nil && len(c.extraEndpoints) == 0 {
 				return fmt.Errorf("%w: missing URL fetcher", ErrMisconfiguredWS)
 			}
 			return nil
@@ -40,7 +40,7 @@ func validateIPMode() Option {
 func validateFetchURL() Option {
 	return optionFunc(
 		func(ws *Websocket) error {
-			if ws.urlFetcher == nil {
+			if ws.urlFetcher == nil && len(ws.extraEndpoints) == 0 {
 				return fmt.Errorf("%w: nil FetchURL", ErrMisconfiguredWS)
 			}
 			return nil
@@ -5,9 +5,11 @@ package websocket
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/xmidt-org/arrange/arrangehttp"
@@ -62,6 +64,82 @@ func FetchURL(f func(context.Context) (string, error)) Option {
 		})
 }
 
+// FetchRedirect sets the function used to obtain the connection guidance from
+// the XMiDT service before each connection attempt.  If it fails, the last
+// redirect is kept, or the configured values are used if there is none.
+func FetchRedirect(f func(context.Context) (Redirect, error)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if f == nil {
+				return fmt.Errorf("%w: nil FetchRedirect", ErrMisconfiguredWS)
+			}
+
+			ws.redirectFetcher = f
+			return nil
+		})
+}
+
+// Endpoints adds fallback endpoints for the WS connection.  They are used when
+// the URL (or FetchURL) endpoint is unhealthy, preferring the healthy
+// endpoints with the lowest priority and spreading connections by weight.
+func Endpoints(endpoints ...Endpoint) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			for _, ep := range endpoints {
+				if ep.URL == "" {
+					return fmt.Errorf("%w: empty Endpoint URL", ErrMisconfiguredWS)
+				}
+				if ep.Weight < 0 {
+					return fmt.Errorf("%w: negative Endpoint Weight", ErrMisconfiguredWS)
+				}
+			}
+
+			ws.extraEndpoints = append(ws.extraEndpoints, endpoints...)
+			return nil
+		})
+}
+
+// CircuitBreaker sets how many consecutive failures an endpoint may have
+// before it is avoided, and how long it is avoided for.  If this is not set,
+// the default is 3 failures and 1 minute.  A zero threshold or cooldown keeps
+// the default for that value.
+func CircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if threshold < 0 {
+				return fmt.Errorf("%w: negative CircuitBreaker threshold", ErrMisconfiguredWS)
+			}
+			if cooldown < 0 {
+				return fmt.Errorf("%w: negative CircuitBreaker cooldown", ErrMisconfiguredWS)
+			}
+
+			if threshold != 0 {
+				ws.failureThreshold = threshold
+			}
+			if cooldown != 0 {
+				ws.cooldown = cooldown
+			}
+			return nil
+		})
+}
+
+// ReturnToPreferredInterval sets how often a connection to a fallback endpoint
+// checks if a preferred endpoint is healthy.  The preferred endpoint is probed
+// with a connection first, and only if that succeeds is the connection closed
+// so the preferred endpoint is used.  If this is not set, the default is
+// 10 minutes.  Zero disables returning to a preferred endpoint.
+func ReturnToPreferredInterval(d time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if d < 0 {
+				return fmt.Errorf("%w: negative ReturnToPreferredInterval", ErrMisconfiguredWS)
+			}
+
+			ws.returnInterval = d
+			return nil
+		})
+}
+
 // FetchURLTimeout sets the FetchURLTimeout for the WS connection.
 // If this is not set, the default is 30 seconds.
 func FetchURLTimeout(d time.Duration) Option {
@@ -164,6 +242,82 @@ func WithIPv6(with ...bool) Option {
 		})
 }
 
+// Interfaces sets the provider of the network interfaces the WS connection may
+// be made over.  The interfaces are tried in the order provided, and if the
+// interface of an established connection goes down the connection is closed
+// so a new one can be made over the next interface.  If this is not set, any
+// interface may be used.
+func Interfaces(p InterfaceProvider) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if p == nil {
+				return fmt.Errorf("%w: nil InterfaceProvider", ErrMisconfiguredWS)
+			}
+
+			ws.interfaces = p
+			return nil
+		})
+}
+
+// BindToDevice sets whether connections are bound to the interface with
+// SO_BINDTODEVICE (linux only, requires CAP_NET_RAW) instead of using the
+// interface's address as the source address.  It only applies when
+// Interfaces is set.
+func BindToDevice(bind ...bool) Option {
+	bind = append(bind, true)
+	return optionFunc(
+		func(ws *Websocket) error {
+			if bind[0] && !bindToDeviceSupported {
+				return fmt.Errorf("%w: BindToDevice is not supported on this platform", ErrMisconfiguredWS)
+			}
+
+			ws.bindToDevice = bind[0]
+			return nil
+		})
+}
+
+// InterfaceCheckInterval sets how often the interface of the WS connection is
+// checked to see if it is still available.  If this is not set, the default
+// is 10 seconds.  Zero disables the check.
+func InterfaceCheckInterval(d time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if d < 0 {
+				return fmt.Errorf("%w: negative InterfaceCheckInterval", ErrMisconfiguredWS)
+			}
+
+			ws.interfaceCheckInterval = d
+			return nil
+		})
+}
+
+// InterfaceUsed sets a function that is called with the name of the network
+// interface before each connection attempt over it.
+func InterfaceUsed(f func(string)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if f == nil {
+				return fmt.Errorf("%w: nil InterfaceUsed", ErrMisconfiguredWS)
+			}
+
+			ws.interfaceUsed = f
+			return nil
+		})
+}
+
+// FallbackDelay sets how long to wait for a connection over the preferred
+// address family before also trying the other family, when both IPv4 and IPv6
+// are allowed.  The first connection made is used.  If this is not set, the
+// default is 250ms.  A negative value disables racing, and the families are
+// instead tried on alternating connection attempts.
+func FallbackDelay(d time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			ws.fallbackDelay = d
+			return nil
+		})
+}
+
 // SendTimeout sets the send timeout for the WS connection.
 func SendTimeout(d time.Duration) Option {
 	return optionFunc(
@@ -177,6 +331,37 @@ func SendTimeout(d time.Duration) Option {
 		})
 }
 
+// SendQueueSize sets the number of messages that may be waiting to be written
+// to the WS connection.  Sends made while the queue is full fail right away
+// with ErrQueueFull.  If this is not set, the default is 64.
+func SendQueueSize(size int) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if size < 1 {
+				return fmt.Errorf("%w: SendQueueSize must be at least 1", ErrMisconfiguredWS)
+			}
+
+			ws.sendQueueSize = size
+			return nil
+		})
+}
+
+// FlushOnConnect sets the number of messages that may be held while the WS
+// connection is down and written once it is made.  Each message is still
+// bound by its send timeout.  If this is not set, the default is 0 and sends
+// fail with ErrClosed while disconnected.
+func FlushOnConnect(size int) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if size < 0 {
+				return fmt.Errorf("%w: negative FlushOnConnect", ErrMisconfiguredWS)
+			}
+
+			ws.flushSize = size
+			return nil
+		})
+}
+
 // HTTPClient is the configuration for the HTTP client used for connection attempts.
 func HTTPClient(c arrangehttp.ClientConfig) Option {
 	return optionFunc(
@@ -211,6 +396,29 @@ func HTTPClientWithForceSets(c arrangehttp.ClientConfig) Option {
 		})
 }
 
+// Proxy sets the function that chooses the proxy for each connection, in the
+// form used by http.Transport.  By default the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables are used.  A nil function disables proxies.
+func Proxy(f func(*http.Request) (*url.URL, error)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			ws.proxy = f
+			return nil
+		})
+}
+
+// ClientCertificates sets the function that provides the client certificate
+// for each new connection, in place of the certificates in the HTTP client
+// configuration.  This allows renewed certificates to be used without
+// recreating the websocket.
+func ClientCertificates(f func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			ws.getClientCertificate = f
+			return nil
+		})
+}
+
 // AdditionalHeaders sets the additional headers for the WS connection.
 func AdditionalHeaders(headers http.Header) Option {
 	return optionFunc(
@@ -275,6 +483,105 @@ func MaxMessageBytes(bytes int64) Option {
 		})
 }
 
+// Compression sets the permessage-deflate compression mode requested from the
+// server, and the smallest message in bytes that is compressed.  A threshold
+// of zero uses 128 bytes with context takeover and 512 bytes without.
+// Compression is only used if the server agrees to it.  If this is not set,
+// compression is disabled.
+func Compression(mode CompressionMode, threshold int) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if mode == "" {
+				mode = CompressionDisabled
+			}
+			if !mode.valid() {
+				return fmt.Errorf("%w: unknown Compression mode '%s'", ErrMisconfiguredWS, mode)
+			}
+			if threshold < 0 {
+				return fmt.Errorf("%w: negative Compression threshold", ErrMisconfiguredWS)
+			}
+
+			ws.compression = mode
+			ws.compressionThreshold = threshold
+			return nil
+		})
+}
+
+// ReconnectRules replaces the default rules for how to reconnect after the
+// server closes the connection or rejects a connection attempt.  The first
+// rule that matches is used.  By default:
+//   - 401 and 403 responses refresh the credentials before reconnecting.
+//   - StatusTryAgainLater closes and 429 and 503 responses wait as long as the
+//     server suggests.
+//   - StatusPolicyViolation closes wait at least 30 minutes.
+func ReconnectRules(rules ...ReconnectRule) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			for _, rule := range rules {
+				if len(rule.CloseCodes) == 0 && len(rule.StatusCodes) == 0 {
+					return fmt.Errorf("%w: reconnect rule without close or status codes", ErrMisconfiguredWS)
+				}
+				if rule.Delay < 0 {
+					return fmt.Errorf("%w: negative reconnect rule Delay", ErrMisconfiguredWS)
+				}
+			}
+
+			ws.reconnectRules = rules
+			return nil
+		})
+}
+
+// CredentialsRefresher sets the function used to mark the credentials invalid
+// and wait for new ones, when a reconnect rule calls for it.  The context
+// passed to the function has a deadline.
+func CredentialsRefresher(f func(context.Context)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			ws.credRefresher = f
+			return nil
+		})
+}
+
+// ReconnectReason sets a function that is called with the reason the
+// connection ended, or a connection attempt failed, each time it happens.
+// The reason is also available with Websocket.LastReconnectReason().
+func ReconnectReason(f func(string)) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			ws.reconnectReasonUsed = f
+			return nil
+		})
+}
+
+// StatsInterval sets how often the stats listeners are called with a snapshot
+// of the connection stats.  If this is not set or is zero, the listeners are
+// never called, but the stats are still available with Websocket.Stats().
+func StatsInterval(d time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if d < 0 {
+				return fmt.Errorf("%w: negative StatsInterval", ErrMisconfiguredWS)
+			}
+			ws.statsInterval = d
+			return nil
+		})
+}
+
+// RTTInterval sets how often the server is pinged to measure the round trip
+// time of the connection.  A ping that does not get a pong within the interval
+// counts as a failure.  If this is not set or is zero, the round trip time is
+// not measured.
+func RTTInterval(d time.Duration) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			if d < 0 {
+				return fmt.Errorf("%w: negative RTTInterval", ErrMisconfiguredWS)
+			}
+			ws.rttInterval = d
+			return nil
+		})
+}
+
 // AddMessageListener adds a message listener to the WS connection.
 // The listener will be called for every message received from the WS.
 func AddMessageListener(listener event.MsgListener, cancel ...*event.CancelFunc) Option {
@@ -309,6 +616,31 @@ func AddDisconnectListener(listener event.DisconnectListener, cancel ...*event.C
 		})
 }
 
+// AddStatsListener adds a listener for the periodic connection stats.  See
+// StatsInterval.
+func AddStatsListener(listener event.StatsListener, cancel ...*event.CancelFunc) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			var ignored event.CancelFunc
+			cancel = append(cancel, &ignored)
+			*cancel[0] = event.CancelFunc(ws.statsListeners.Add(listener))
+			return nil
+		})
+}
+
+// AddSendFailureListener adds a listener for the messages queued by Send that
+// could not be written.  The listener is called by the writer goroutine, so it
+// must not block.
+func AddSendFailureListener(listener event.SendFailureListener, cancel ...*event.CancelFunc) Option {
+	return optionFunc(
+		func(ws *Websocket) error {
+			var ignored event.CancelFunc
+			cancel = append(cancel, &ignored)
+			*cancel[0] = event.CancelFunc(ws.sendFailureListeners.Add(listener))
+			return nil
+		})
+}
+
 // AddHeartbeatListener adds a heartbeat listener to the WS connection.
 func AddHeartbeatListener(listener event.HeartbeatListener, cancel ...*event.CancelFunc) Option {
 	return optionFunc(
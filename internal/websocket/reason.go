@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+// closeConn closes the connection from this side, remembering the reason so
+// the disconnect is reported correctly.
+func (ws *Websocket) closeConn(conn *nhws.Conn, reason event.DisconnectReason, code nhws.StatusCode, msg string) {
+	ws.localReason.Store(reason)
+	_ = conn.Close(code, msg)
+}
+
+// disconnectReason classifies why a connection ended.  The cause is the cause
+// of the read context, if any.
+func (ws *Websocket) disconnectReason(err, cause error) (event.DisconnectReason, int) {
+	if reason, ok := ws.localReason.Load().(event.DisconnectReason); ok && reason != "" {
+		return reason, 0
+	}
+
+	if errors.Is(cause, context.DeadlineExceeded) {
+		return event.ReasonInactivityTimeout, 0
+	}
+
+	if errors.Is(err, nhws.ErrPongWrite) {
+		return event.ReasonPingFailure, 0
+	}
+
+	if code := nhws.CloseStatus(err); code != -1 {
+		return event.ReasonServerClose, int(code)
+	}
+
+	return event.ReasonConnectionError, 0
+}
+
+// dialReason classifies why a connection attempt failed.
+func dialReason(err error) event.DisconnectReason {
+	var (
+		dnsErr      *net.DNSError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+		verifyErr   *tls.CertificateVerificationError
+		unknownErr  x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return event.ReasonDNSFailure
+	case errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &verifyErr), errors.As(err, &unknownErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return event.ReasonTLSFailure
+	case errors.Is(err, ErrNoInterface):
+		return event.ReasonInterfaceChange
+	}
+
+	return event.ReasonConnectFailure
+}
+
+// reasonText describes the reason, including the close code if there is one.
+func reasonText(reason event.DisconnectReason, code int) string {
+	if code != 0 {
+		return fmt.Sprintf("%s:%d", reason, code)
+	}
+	return string(reason)
+}
+
+// setReconnectReason records the reason for the next connection.
+func (ws *Websocket) setReconnectReason(reason event.DisconnectReason, code int) {
+	text := reasonText(reason, code)
+	ws.m.Lock()
+	ws.reconnectReason = text
+	ws.m.Unlock()
+
+	if ws.reconnectReasonUsed != nil {
+		ws.reconnectReasonUsed(text)
+	}
+}
+
+// LastReconnectReason returns the reason the most recent connection ended or
+// the most recent connection attempt failed.  It is empty until the first
+// connection ends or fails.
+func (ws *Websocket) LastReconnectReason() string {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	return ws.reconnectReason
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	tests := []struct {
+		description string
+		local       event.DisconnectReason
+		err         error
+		cause       error
+		reason      event.DisconnectReason
+		code        int
+	}{
+		{
+			description: "closed locally",
+			local:       event.ReasonInterfaceChange,
+			err:         nhws.CloseError{Code: nhws.StatusGoingAway},
+			reason:      event.ReasonInterfaceChange,
+		}, {
+			description: "inactivity timeout",
+			err:         context.DeadlineExceeded,
+			cause:       context.DeadlineExceeded,
+			reason:      event.ReasonInactivityTimeout,
+		}, {
+			description: "pong write failure",
+			err:         fmt.Errorf("%w: %w", nhws.ErrPongWrite, context.DeadlineExceeded),
+			reason:      event.ReasonPingFailure,
+		}, {
+			description: "server close",
+			err:         fmt.Errorf("received close frame: %w", nhws.CloseError{Code: nhws.StatusPolicyViolation}),
+			reason:      event.ReasonServerClose,
+			code:        int(nhws.StatusPolicyViolation),
+		}, {
+			description: "other error",
+			err:         errors.New("connection reset"),
+			reason:      event.ReasonConnectionError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var ws Websocket
+			ws.localReason.Store(tc.local)
+
+			reason, code := ws.disconnectReason(tc.err, tc.cause)
+			assert.Equal(tc.reason, reason)
+			assert.Equal(tc.code, code)
+		})
+	}
+}
+
+func TestDialReason(t *testing.T) {
+	tests := []struct {
+		description string
+		err         error
+		reason      event.DisconnectReason
+	}{
+		{
+			description: "dns failure",
+			err:         fmt.Errorf("dial: %w", &net.DNSError{Err: "no such host", Name: "example.com"}),
+			reason:      event.ReasonDNSFailure,
+		}, {
+			description: "tls failure",
+			err:         fmt.Errorf("dial: %w", x509.UnknownAuthorityError{}),
+			reason:      event.ReasonTLSFailure,
+		}, {
+			description: "no interface",
+			err:         ErrNoInterface,
+			reason:      event.ReasonInterfaceChange,
+		}, {
+			description: "other failure",
+			err:         errors.New("connection refused"),
+			reason:      event.ReasonConnectFailure,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.reason, dialReason(tc.err))
+		})
+	}
+}
+
+func TestSetReconnectReason(t *testing.T) {
+	assert := assert.New(t)
+
+	var got []string
+	ws := Websocket{
+		reconnectReasonUsed: func(s string) {
+			got = append(got, s)
+		},
+	}
+
+	assert.Empty(ws.LastReconnectReason())
+
+	ws.setReconnectReason(event.ReasonDNSFailure, 0)
+	ws.setReconnectReason(event.ReasonServerClose, int(nhws.StatusTryAgainLater))
+
+	assert.Equal("server_close:1013", ws.LastReconnectReason())
+	assert.Equal([]string{"dns_failure", "server_close:1013"}, got)
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/retryafter"
+)
+
+const (
+	// maxReconnectHint is the longest delay suggested by the server that is
+	// honored.
+	maxReconnectHint = time.Hour
+
+	// credentialsRefreshTimeout is how long to wait for new credentials
+	// before reconnecting anyway.
+	credentialsRefreshTimeout = 30 * time.Second
+)
+
+// ReconnectRule changes how the websocket reconnects after the server closes
+// the connection with one of the close codes, or rejects a connection attempt
+// with one of the HTTP status codes.  Otherwise the retry policy is used.
+type ReconnectRule struct {
+	// CloseCodes are the websocket close codes the rule applies to.
+	CloseCodes []int
+
+	// StatusCodes are the HTTP status codes of failed connection attempts
+	// the rule applies to.
+	StatusCodes []int
+
+	// Delay is the least amount of time to wait before reconnecting.  If the
+	// retry policy gives a longer delay, it is used instead.
+	Delay time.Duration
+
+	// HonorHint uses the delay suggested by the server instead of Delay.
+	// The hint is read from the close reason (such as "30s" or "retry in 30")
+	// or the Retry-After header.
+	HonorHint bool
+
+	// RefreshCredentials marks the credentials invalid and waits for new
+	// ones before reconnecting.  See CredentialsRefresher.
+	RefreshCredentials bool
+}
+
+// defaultReconnectRules are used unless ReconnectRules is set.
+var defaultReconnectRules = []ReconnectRule{
+	{
+		StatusCodes:        []int{http.StatusUnauthorized, http.StatusForbidden},
+		RefreshCredentials: true,
+	},
+	{
+		CloseCodes:  []int{int(nhws.StatusTryAgainLater)},
+		StatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		HonorHint:   true,
+	},
+	{
+		CloseCodes: []int{int(nhws.StatusPolicyViolation)},
+		Delay:      30 * time.Minute,
+	},
+}
+
+// reconnectPlan is how to reconnect after a disconnect or failed attempt.
+type reconnectPlan struct {
+	delay   time.Duration
+	refresh bool
+}
+
+func (r ReconnectRule) plan(hint time.Duration) reconnectPlan {
+	p := reconnectPlan{
+		delay:   r.Delay,
+		refresh: r.RefreshCredentials,
+	}
+	if r.HonorHint && hint > 0 {
+		p.delay = min(hint, maxReconnectHint)
+	}
+	return p
+}
+
+// closedPlan returns the plan for a connection closed by the server.
+func (ws *Websocket) closedPlan(err error) reconnectPlan {
+	var ce nhws.CloseError
+	if !errors.As(err, &ce) {
+		return reconnectPlan{}
+	}
+
+	for _, rule := range ws.reconnectRules {
+		if slices.Contains(rule.CloseCodes, int(ce.Code)) {
+			return rule.plan(parseHint(ce.Reason))
+		}
+	}
+
+	return reconnectPlan{}
+}
+
+// rejectedPlan returns the plan for a connection attempt that got a response
+// other than a websocket upgrade.
+func (ws *Websocket) rejectedPlan(resp *http.Response) reconnectPlan {
+	if resp == nil {
+		return reconnectPlan{}
+	}
+
+	for _, rule := range ws.reconnectRules {
+		if slices.Contains(rule.StatusCodes, resp.StatusCode) {
+			return rule.plan(retryafter.Parse(resp.Header.Get("Retry-After"), ws.nowFunc()))
+		}
+	}
+
+	return reconnectPlan{}
+}
+
+// parseHint finds a delay in a close reason.  The last word of the reason is
+// used, either as a duration like "1m30s" or as a number of seconds.
+func parseHint(reason string) time.Duration {
+	fields := strings.Fields(reason)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	last := fields[len(fields)-1]
+	if d, err := time.ParseDuration(last); err == nil {
+		return max(d, 0)
+	}
+	if secs, err := strconv.Atoi(last); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+
+	return 0
+}
+
+// refreshCredentials marks the credentials invalid and waits a while for new
+// ones.
+func (ws *Websocket) refreshCredentials(ctx context.Context) {
+	if ws.credRefresher == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialsRefreshTimeout)
+	defer cancel()
+
+	ws.credRefresher(ctx)
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+)
+
+func TestParseHint(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   time.Duration
+	}{
+		{reason: "", want: 0},
+		{reason: "busy", want: 0},
+		{reason: "30", want: 30 * time.Second},
+		{reason: "retry in 45", want: 45 * time.Second},
+		{reason: "retry after 1m30s", want: 90 * time.Second},
+		{reason: "retry after -5s", want: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.reason, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseHint(tc.reason))
+		})
+	}
+}
+
+func TestReconnectPlans(t *testing.T) {
+	ws := Websocket{
+		reconnectRules: defaultReconnectRules,
+		nowFunc:        time.Now,
+	}
+
+	closed := func(code nhws.StatusCode, reason string) error {
+		return fmt.Errorf("received close frame: %w", nhws.CloseError{Code: code, Reason: reason})
+	}
+	rejected := func(status int, retryAfter string) *http.Response {
+		resp := http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+		}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return &resp
+	}
+
+	tests := []struct {
+		description string
+		plan        reconnectPlan
+		want        reconnectPlan
+	}{
+		{
+			description: "try again later with a hint",
+			plan:        ws.closedPlan(closed(nhws.StatusTryAgainLater, "retry in 120")),
+			want:        reconnectPlan{delay: 2 * time.Minute},
+		}, {
+			description: "try again later with a huge hint",
+			plan:        ws.closedPlan(closed(nhws.StatusTryAgainLater, "retry in 1000h")),
+			want:        reconnectPlan{delay: maxReconnectHint},
+		}, {
+			description: "policy violation",
+			plan:        ws.closedPlan(closed(nhws.StatusPolicyViolation, "")),
+			want:        reconnectPlan{delay: 30 * time.Minute},
+		}, {
+			description: "other close code",
+			plan:        ws.closedPlan(closed(nhws.StatusGoingAway, "")),
+		}, {
+			description: "not a close",
+			plan:        ws.closedPlan(errors.New("connection reset")),
+		}, {
+			description: "unauthorized",
+			plan:        ws.rejectedPlan(rejected(http.StatusUnauthorized, "")),
+			want:        reconnectPlan{refresh: true},
+		}, {
+			description: "forbidden",
+			plan:        ws.rejectedPlan(rejected(http.StatusForbidden, "")),
+			want:        reconnectPlan{refresh: true},
+		}, {
+			description: "service unavailable with retry after",
+			plan:        ws.rejectedPlan(rejected(http.StatusServiceUnavailable, "60")),
+			want:        reconnectPlan{delay: time.Minute},
+		}, {
+			description: "other status",
+			plan:        ws.rejectedPlan(rejected(http.StatusNotFound, "60")),
+		}, {
+			description: "no response",
+			plan:        ws.rejectedPlan(nil),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.plan)
+		})
+	}
+}
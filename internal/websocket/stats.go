@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+// rttWeight is the weight given to each new round trip time measurement in
+// the smoothed round trip time, the same as TCP (RFC 6298).
+const rttWeight = 0.125
+
+// stats tracks the quality of the websocket connection.
+type stats struct {
+	lock sync.Mutex
+
+	connects       int
+	connectedSince time.Time
+
+	bytesSent        uint64
+	bytesReceived    uint64
+	messagesSent     uint64
+	messagesReceived uint64
+
+	pingsSent    uint64
+	pingFailures uint64
+	rtt          time.Duration
+	smoothedRTT  time.Duration
+	minRTT       time.Duration
+	maxRTT       time.Duration
+}
+
+func (s *stats) connected(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.connects++
+	s.connectedSince = now
+}
+
+func (s *stats) disconnected() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.connectedSince = time.Time{}
+}
+
+func (s *stats) sent(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.messagesSent++
+	s.bytesSent += uint64(n)
+}
+
+func (s *stats) received(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.messagesReceived++
+	s.bytesReceived += uint64(n)
+}
+
+// pinged records the result of a ping sent to measure the round trip time.
+func (s *stats) pinged(rtt time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pingsSent++
+	if err != nil {
+		s.pingFailures++
+		return
+	}
+
+	s.rtt = rtt
+	if s.smoothedRTT == 0 {
+		s.smoothedRTT = rtt
+		s.minRTT = rtt
+		s.maxRTT = rtt
+		return
+	}
+
+	s.smoothedRTT += time.Duration(rttWeight * float64(rtt-s.smoothedRTT))
+	s.minRTT = min(s.minRTT, rtt)
+	s.maxRTT = max(s.maxRTT, rtt)
+}
+
+func (s *stats) snapshot(now time.Time) event.Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := event.Stats{
+		At:               now,
+		Connected:        !s.connectedSince.IsZero(),
+		ConnectedSince:   s.connectedSince,
+		Connects:         s.connects,
+		Reconnects:       max(s.connects-1, 0),
+		BytesSent:        s.bytesSent,
+		BytesReceived:    s.bytesReceived,
+		MessagesSent:     s.messagesSent,
+		MessagesReceived: s.messagesReceived,
+		PingsSent:        s.pingsSent,
+		PingFailures:     s.pingFailures,
+		RTT:              s.rtt,
+		SmoothedRTT:      s.smoothedRTT,
+		MinRTT:           s.minRTT,
+		MaxRTT:           s.maxRTT,
+	}
+	if e.Connected {
+		e.Uptime = now.Sub(s.connectedSince)
+	}
+
+	return e
+}
+
+// Stats returns a snapshot of the connection quality metrics.
+func (ws *Websocket) Stats() event.Stats {
+	return ws.stats.snapshot(ws.nowFunc())
+}
+
+// reportStats sends the stats to the stats listeners every statsInterval
+// until the context is canceled.
+func (ws *Websocket) reportStats(ctx context.Context) {
+	defer ws.wg.Done()
+
+	ticker := time.NewTicker(ws.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := ws.Stats()
+			ws.statsListeners.Visit(func(l event.StatsListener) {
+				l.OnStats(s)
+			})
+		}
+	}
+}
+
+// measureRTT pings the server every rttInterval to measure the round trip
+// time of the connection until the context is canceled.
+func (ws *Websocket) measureRTT(ctx context.Context, conn *nhws.Conn) {
+	if ws.rttInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ws.rttInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, ws.rttInterval)
+			start := time.Now()
+			err := conn.Ping(pingCtx)
+			rtt := time.Since(start)
+			cancel()
+
+			// A ping interrupted by the connection closing is not a failure.
+			if ctx.Err() != nil {
+				return
+			}
+			ws.stats.pinged(rtt, err)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Unix(1000, 0)
+	var s stats
+
+	got := s.snapshot(start)
+	assert.False(got.Connected)
+	assert.Zero(got.Uptime)
+	assert.Zero(got.Reconnects)
+
+	s.connected(start)
+	s.sent(10)
+	s.sent(20)
+	s.received(5)
+	s.pinged(100*time.Millisecond, nil)
+	s.pinged(200*time.Millisecond, nil)
+	s.pinged(time.Second, errors.New("no pong"))
+
+	got = s.snapshot(start.Add(time.Minute))
+	assert.True(got.Connected)
+	assert.Equal(start, got.ConnectedSince)
+	assert.Equal(time.Minute, got.Uptime)
+	assert.Equal(1, got.Connects)
+	assert.Equal(0, got.Reconnects)
+	assert.Equal(uint64(30), got.BytesSent)
+	assert.Equal(uint64(2), got.MessagesSent)
+	assert.Equal(uint64(5), got.BytesReceived)
+	assert.Equal(uint64(1), got.MessagesReceived)
+	assert.Equal(uint64(3), got.PingsSent)
+	assert.Equal(uint64(1), got.PingFailures)
+	assert.Equal(200*time.Millisecond, got.RTT)
+	assert.Equal(112500*time.Microsecond, got.SmoothedRTT)
+	assert.Equal(100*time.Millisecond, got.MinRTT)
+	assert.Equal(200*time.Millisecond, got.MaxRTT)
+
+	s.disconnected()
+	got = s.snapshot(start.Add(2 * time.Minute))
+	assert.False(got.Connected)
+	assert.Zero(got.Uptime)
+
+	s.connected(start.Add(3 * time.Minute))
+	got = s.snapshot(start.Add(4 * time.Minute))
+	assert.Equal(2, got.Connects)
+	assert.Equal(1, got.Reconnects)
+	assert.Equal(time.Minute, got.Uptime)
+	assert.Equal(uint64(30), got.BytesSent, "counters are kept across connections")
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+
+	nhws "github.com/xmidt-org/xmidt-agent/internal/nhooyr.io/websocket"
+)
+
+// defaultSendQueueSize is the number of messages that may be waiting for the
+// writer goroutine.
+const defaultSendQueueSize = 64
+
+// outgoing is a message waiting to be written.  The context carries the
+// message's deadline and done is called with the result of the write.
+type outgoing struct {
+	ctx  context.Context
+	msg  []byte
+	done func(error)
+}
+
+func (o outgoing) finish(err error) {
+	o.done(err)
+}
+
+// writer writes the queued messages to the websocket one at a time until the
+// context is canceled.  Messages queued while disconnected are held, up to
+// flushSize of them, and written once a connection is made.
+func (ws *Websocket) writer(ctx context.Context, queue <-chan outgoing, connected <-chan struct{}, done chan<- struct{}) {
+	defer ws.wg.Done()
+	defer close(done)
+
+	var held []outgoing
+	for {
+		select {
+		case <-ctx.Done():
+			for _, o := range held {
+				o.finish(ErrClosed)
+			}
+			for {
+				select {
+				case o := <-queue:
+					o.finish(ErrClosed)
+				default:
+					return
+				}
+			}
+
+		case <-connected:
+			held = ws.flush(held)
+
+		case o := <-queue:
+			held = ws.flush(held)
+			if len(held) == 0 && ws.write(o) {
+				continue
+			}
+
+			held = expire(held)
+			if len(held) >= ws.flushSize {
+				o.finish(ErrClosed)
+				continue
+			}
+			held = append(held, o)
+		}
+	}
+}
+
+// flush writes the held messages in order, returning the ones that are still
+// waiting for a connection.
+func (ws *Websocket) flush(held []outgoing) []outgoing {
+	for len(held) > 0 {
+		if !ws.write(held[0]) {
+			return held
+		}
+		held = held[1:]
+	}
+	return nil
+}
+
+// write writes the message to the current connection.  It returns false if
+// there is no connection, in which case the message has not been finished.
+func (ws *Websocket) write(o outgoing) bool {
+	if err := o.ctx.Err(); err != nil {
+		o.finish(err)
+		return true
+	}
+
+	ws.m.Lock()
+	conn := ws.conn
+	ws.m.Unlock()
+
+	if conn == nil {
+		return false
+	}
+
+	err := conn.Write(o.ctx, nhws.MessageBinary, o.msg)
+	if err == nil {
+		ws.stats.sent(len(o.msg))
+	}
+	o.finish(err)
+	return true
+}
+
+// expire finishes and removes the held messages whose deadline has passed.
+func expire(held []outgoing) []outgoing {
+	kept := held[:0]
+	for _, o := range held {
+		if err := o.ctx.Err(); err != nil {
+			o.finish(err)
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept
+}
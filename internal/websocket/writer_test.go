@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2024 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/retry"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/websocket/event"
+)
+
+type sendFailureRecorder chan event.SendFailure
+
+func (r sendFailureRecorder) OnSendFailure(e event.SendFailure) {
+	r <- e
+}
+
+func newTestWS(t *testing.T, opts ...Option) *Websocket {
+	opts = append(opts,
+		URL("http://127.0.0.1:1"),
+		DeviceID("mac:112233445566"),
+		WithIPv4(),
+		CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+		NowFunc(time.Now),
+		RetryPolicy(retry.Config{Interval: time.Hour}),
+		SendTimeout(100*time.Millisecond),
+	)
+	got, err := New(opts...)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	return got
+}
+
+func TestSendNotStarted(t *testing.T) {
+	got := newTestWS(t)
+	assert.ErrorIs(t, got.Send(context.Background(), wrp.Message{}), ErrClosed)
+	assert.ErrorIs(t, got.SendAndWait(context.Background(), wrp.Message{}), ErrClosed)
+}
+
+func TestSendDisconnected(t *testing.T) {
+	failures := make(sendFailureRecorder, 1)
+	got := newTestWS(t, AddSendFailureListener(failures))
+	got.Start()
+	defer got.Stop()
+
+	start := time.Now()
+	assert.ErrorIs(t, got.SendAndWait(context.Background(), wrp.Message{}), ErrClosed)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType}
+	assert.NoError(t, got.Send(context.Background(), msg))
+
+	select {
+	case e := <-failures:
+		assert.Equal(t, msg, e.Message)
+		assert.ErrorIs(t, e.Err, ErrClosed)
+	case <-time.After(time.Second):
+		assert.Fail(t, "timed out waiting for the send failure")
+	}
+}
+
+func TestSendDoesNotWait(t *testing.T) {
+	failures := make(sendFailureRecorder, 1)
+	got := newTestWS(t, AddSendFailureListener(failures))
+
+	// Simulate a started websocket whose writer is blocked.
+	got.queue = make(chan outgoing, 1)
+	got.writerDone = make(chan struct{})
+
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType}
+	start := time.Now()
+	assert.NoError(t, got.Send(context.Background(), msg))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// The write failure is reported once the writer gets to the message.
+	errWrite := errors.New("write failed")
+	o := <-got.queue
+	o.finish(errWrite)
+
+	select {
+	case e := <-failures:
+		assert.Equal(t, msg, e.Message)
+		assert.ErrorIs(t, e.Err, errWrite)
+	default:
+		assert.Fail(t, "the send failure was not reported")
+	}
+}
+
+func TestSendHeldUntilDeadline(t *testing.T) {
+	got := newTestWS(t, FlushOnConnect(1))
+	got.Start()
+	defer got.Stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- got.SendAndWait(context.Background(), wrp.Message{})
+	}()
+
+	// Give the first message time to be held by the writer.
+	time.Sleep(20 * time.Millisecond)
+
+	// The buffer only holds one message.
+	assert.ErrorIs(t, got.SendAndWait(context.Background(), wrp.Message{}), ErrClosed)
+
+	// The held message fails once its deadline passes.
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+}
+
+func TestSendQueueFull(t *testing.T) {
+	got := newTestWS(t, SendQueueSize(1))
+
+	// Simulate a started websocket whose writer is busy.
+	got.queue = make(chan outgoing, 1)
+	got.writerDone = make(chan struct{})
+	got.queue <- outgoing{}
+
+	assert.ErrorIs(t, got.Send(context.Background(), wrp.Message{}), ErrQueueFull)
+
+	// Once the writer stops, waiting senders are released.
+	<-got.queue
+	close(got.writerDone)
+	assert.ErrorIs(t, got.SendAndWait(context.Background(), wrp.Message{}), ErrClosed)
+}
+
+func TestSendOptions(t *testing.T) {
+	_, err := New(SendQueueSize(0))
+	assert.ErrorIs(t, err, ErrMisconfiguredWS)
+
+	_, err = New(FlushOnConnect(-1))
+	assert.ErrorIs(t, err, ErrMisconfiguredWS)
+}
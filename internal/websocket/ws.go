@@ -5,10 +5,15 @@ package websocket
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmidt-org/arrange/arrangehttp"
@@ -23,6 +28,8 @@ var (
 	ErrMisconfiguredWS = errors.New("misconfigured WS")
 	ErrClosed          = errors.New("websocket closed")
 	ErrInvalidMsgType  = errors.New("invalid message type")
+	ErrNoInterface     = errors.New("no usable network interface")
+	ErrQueueFull       = errors.New("send queue full")
 )
 
 // Egress interface is the egress route used to handle wrp messages that
@@ -42,6 +49,27 @@ type Websocket struct {
 	// urlFetchingTimeout is the URLFetchingTimeout for the WS connection.
 	urlFetchingTimeout time.Duration
 
+	// redirectFetcher is the FetchRedirect for the WS connection.
+	redirectFetcher func(context.Context) (Redirect, error)
+
+	// extraEndpoints are the fallback endpoints for the WS connection.
+	extraEndpoints []Endpoint
+
+	// failureThreshold is the number of consecutive failures before an
+	// endpoint is avoided.
+	failureThreshold int
+
+	// cooldown is how long an endpoint is avoided once it reaches the
+	// failure threshold.
+	cooldown time.Duration
+
+	// returnInterval is how often a connection to a fallback endpoint checks
+	// if it can return to a preferred endpoint.
+	returnInterval time.Duration
+
+	// endpoints tracks the health of the endpoints and chooses between them.
+	endpoints *endpoints
+
 	// credDecorator is the credentials decorator for the WS connection.
 	credDecorator func(http.Header) error
 
@@ -58,24 +86,66 @@ type Websocket struct {
 	// sendTimeout is the send timeout for the WS connection.
 	sendTimeout time.Duration
 
+	// sendQueueSize is the number of messages that may be waiting to be
+	// written.
+	sendQueueSize int
+
+	// flushSize is the number of messages that may be held while
+	// disconnected and written once connected.
+	flushSize int
+
 	// keepAliveInterval is the keep alive interval for the WS connection.
 	keepAliveInterval time.Duration
 
 	// httpClientConfig is the configuration and factory for the HTTP client.
 	httpClientConfig arrangehttp.ClientConfig
 
+	// proxy chooses the proxy for each connection.
+	proxy func(*http.Request) (*url.URL, error)
+
+	// getClientCertificate provides the client certificate for each new
+	// connection, replacing the certificates in httpClientConfig.
+	getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 	// additionalHeaders are any additional headers for the WS connection.
 	additionalHeaders http.Header
 
 	// maxMessageBytes is the largest allowable message to send or receive.
 	maxMessageBytes int64
 
+	// compression is the compression mode requested from the server.
+	compression CompressionMode
+
+	// compressionThreshold is the smallest message that is compressed.
+	compressionThreshold int
+
 	// withIPv4 is whether or not to allow IPv4 for the WS connection.
 	withIPv4 bool
 
 	// withIPv6 is whether or not to allow IPv6 for the WS connection.
 	withIPv6 bool
 
+	// interfaces provides the network interfaces to connect over, in priority
+	// order.  If nil, any interface may be used.
+	interfaces InterfaceProvider
+
+	// bindToDevice is whether to bind connections to the interface with
+	// SO_BINDTODEVICE instead of using the interface's source address.
+	bindToDevice bool
+
+	// interfaceCheckInterval is how often the interface of the connection is
+	// checked to see if it is still available.
+	interfaceCheckInterval time.Duration
+
+	// interfaceUsed is called with the name of the interface before each
+	// connection attempt over it.
+	interfaceUsed func(string)
+
+	// fallbackDelay is how long to wait for the preferred address family to
+	// connect before racing the other family.  Zero uses the default and a
+	// negative value disables racing.
+	fallbackDelay time.Duration
+
 	// connectListeners are the connect listeners for the WS connection.
 	connectListeners eventor.Eventor[event.ConnectListener]
 
@@ -88,6 +158,23 @@ type Websocket struct {
 	// msgListeners are the message listeners for messages from the WS.
 	msgListeners eventor.Eventor[event.MsgListener]
 
+	// statsListeners are the listeners for the periodic connection stats.
+	statsListeners eventor.Eventor[event.StatsListener]
+
+	// sendFailureListeners are the listeners for messages queued by Send that
+	// could not be written.
+	sendFailureListeners eventor.Eventor[event.SendFailureListener]
+
+	// statsInterval is how often the stats listeners are called.
+	statsInterval time.Duration
+
+	// rttInterval is how often the server is pinged to measure the round
+	// trip time.
+	rttInterval time.Duration
+
+	// stats tracks the quality of the connection.
+	stats stats
+
 	// nowFunc is the now function for the WS connection.
 	nowFunc func() time.Time
 
@@ -97,11 +184,35 @@ type Websocket struct {
 	// once is whether or not to only attempt to connect once.
 	once bool
 
+	// reconnectRules change how to reconnect based on the close code or the
+	// response to a connection attempt.
+	reconnectRules []ReconnectRule
+
+	// credRefresher marks the credentials invalid and waits for new ones.
+	credRefresher func(context.Context)
+
 	m        sync.Mutex
 	wg       sync.WaitGroup
 	shutdown context.CancelFunc
 
 	conn *nhws.Conn
+
+	// localReason is why the current connection was closed from this side,
+	// if it was.
+	localReason atomic.Value
+
+	// reconnectReason describes why the last connection ended or the last
+	// connection attempt failed.
+	reconnectReason string
+
+	// reconnectReasonUsed is called with the reason whenever it changes.
+	reconnectReasonUsed func(string)
+
+	// queue, connected and writerDone are used to communicate with the
+	// writer goroutine while the websocket is started.
+	queue      chan outgoing
+	connected  chan struct{}
+	writerDone chan struct{}
 }
 
 // Option is a functional option type for WS.
@@ -122,9 +233,17 @@ func emptyDecorator(http.Header) error {
 // New creates a new WS connection with the given options.
 func New(opts ...Option) (*Websocket, error) {
 	ws := Websocket{
-		inactivityTimeout: time.Minute,
-		credDecorator:     emptyDecorator,
-		conveyDecorator:   emptyDecorator,
+		inactivityTimeout:      time.Minute,
+		interfaceCheckInterval: defaultInterfaceCheckInterval,
+		failureThreshold:       defaultFailureThreshold,
+		cooldown:               defaultCooldown,
+		returnInterval:         defaultReturnInterval,
+		sendQueueSize:          defaultSendQueueSize,
+		compression:            CompressionDisabled,
+		proxy:                  http.ProxyFromEnvironment,
+		reconnectRules:         defaultReconnectRules,
+		credDecorator:          emptyDecorator,
+		conveyDecorator:        emptyDecorator,
 		// same default as `xmidt-agent/cmd/xmidt-agent/config.go`'s defaultConfig.Websocket.HTTPClient
 		httpClientConfig: arrangehttp.ClientConfig{
 			Timeout: 30 * time.Second,
@@ -155,6 +274,8 @@ func New(opts ...Option) (*Websocket, error) {
 		}
 	}
 
+	ws.endpoints = newEndpoints(ws.urlFetcher, ws.extraEndpoints, ws.failureThreshold, ws.cooldown)
+
 	return &ws, nil
 }
 
@@ -171,7 +292,18 @@ func (ws *Websocket) Start() {
 	var ctx context.Context
 	ctx, ws.shutdown = context.WithCancel(context.Background())
 
+	ws.queue = make(chan outgoing, ws.sendQueueSize)
+	ws.connected = make(chan struct{}, 1)
+	ws.writerDone = make(chan struct{})
+
+	ws.wg.Add(2)
+	go ws.writer(ctx, ws.queue, ws.connected, ws.writerDone)
 	go ws.run(ctx)
+
+	if ws.statsInterval > 0 {
+		ws.wg.Add(1)
+		go ws.reportStats(ctx)
+	}
 }
 
 // Stop stops the websocket connection.
@@ -201,35 +333,141 @@ func (ws *Websocket) AddMessageListener(listener event.MsgListener) event.Cancel
 	return event.CancelFunc(ws.msgListeners.Add(listener))
 }
 
-// Send sends the provided WRP message through the existing websocket.  This
-// call synchronously blocks until the write is complete.
+// Send queues the provided WRP message to be written to the websocket by the
+// writer goroutine and returns without waiting for the write.  If the send
+// queue is full, ErrQueueFull is returned, and if the websocket is not
+// started, ErrClosed is returned.  A queued message that can't be written
+// within the send timeout, or while disconnected and there is no room to hold
+// it until the connection is made (see FlushOnConnect), is reported to the
+// send failure listeners (see AddSendFailureListener).
 func (ws *Websocket) Send(ctx context.Context, msg wrp.Message) error {
-	err := ErrClosed
+	ctx, cancel := context.WithTimeout(ctx, ws.sendTimeout)
+
+	err := ws.enqueue(ctx, msg, func(err error) {
+		cancel()
+		if err == nil {
+			return
+		}
+
+		ws.sendFailureListeners.Visit(func(l event.SendFailureListener) {
+			l.OnSendFailure(event.SendFailure{
+				At:      ws.nowFunc(),
+				Message: msg,
+				Err:     err,
+			})
+		})
+	})
+	if err != nil {
+		cancel()
+	}
+
+	return err
+}
+
+// SendAndWait is the synchronous form of Send.  It queues the provided WRP
+// message the same way, but blocks until the write is complete or the send
+// timeout passes and returns the result of the write instead of reporting it
+// to the send failure listeners.
+func (ws *Websocket) SendAndWait(ctx context.Context, msg wrp.Message) error {
 	ctx, cancel := context.WithTimeout(ctx, ws.sendTimeout)
 	defer cancel()
 
+	done := make(chan error, 1)
+	err := ws.enqueue(ctx, msg, func(err error) {
+		done <- err
+	})
+	if err != nil {
+		return err
+	}
+
 	ws.m.Lock()
-	if ws.conn != nil {
-		err = ws.conn.Write(ctx, nhws.MessageBinary, wrp.MustEncode(&msg, wrp.Msgpack))
+	writerDone := ws.writerDone
+	ws.m.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-writerDone:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
+
+// enqueue queues the message for the writer goroutine, which calls done with
+// the result of the write.  done is not called if an error is returned.
+func (ws *Websocket) enqueue(ctx context.Context, msg wrp.Message, done func(error)) error {
+	ws.m.Lock()
+	queue, writerDone := ws.queue, ws.writerDone
 	ws.m.Unlock()
 
-	return err
+	if queue == nil {
+		return ErrClosed
+	}
+
+	o := outgoing{
+		ctx:  ctx,
+		msg:  wrp.MustEncode(&msg, wrp.Msgpack),
+		done: done,
+	}
+
+	select {
+	case queue <- o:
+		return nil
+	case <-writerDone:
+		return ErrClosed
+	default:
+		return ErrQueueFull
+	}
 }
 
 func (ws *Websocket) run(ctx context.Context) {
-	ws.wg.Add(1)
 	defer ws.wg.Done()
 
 	decoder := wrp.NewDecoder(nil, wrp.Msgpack)
 	mode := ws.nextMode(ipv4)
 
-	policy := ws.retryPolicyFactory.NewPolicy(ctx)
+	policyFactory := ws.retryPolicyFactory
+	policy := policyFactory.NewPolicy(ctx)
+
+	var (
+		retryOverride *retry.Config
+		dialFailed    bool
+		redirect      *Redirect
+	)
 
 	for {
-		var next time.Duration
+		var (
+			next time.Duration
+			plan reconnectPlan
+		)
 
 		mode = ws.nextMode(mode)
+
+		if ws.redirectFetcher != nil {
+			var r Redirect
+			redirect = ws.updateRedirect(ctx, redirect)
+			if redirect != nil {
+				r = *redirect
+			}
+
+			// Only the first attempt uses the preferred family, so the other
+			// family is still tried if it doesn't work.
+			if !dialFailed {
+				mode = ws.preferredMode(r.IPFamily, mode)
+			}
+
+			if !sameRetry(r.RetryPolicy, retryOverride) {
+				retryOverride = r.RetryPolicy
+				policyFactory = ws.retryPolicyFactory
+				if retryOverride != nil {
+					policyFactory = *retryOverride
+				}
+				policy.Cancel()
+				policy = policyFactory.NewPolicy(ctx)
+			}
+		}
+
 		cEvent := event.Connect{
 			Started: ws.nowFunc(),
 			Mode:    mode.ToEvent(),
@@ -238,18 +476,31 @@ func (ws *Websocket) run(ctx context.Context) {
 		// If auth fails, then continue with no credentials.
 		ws.credDecorator(ws.additionalHeaders)
 
-		ws.conveyDecorator(ws.additionalHeaders)
-
-		conn, _, dialErr := ws.dial(ctx, mode) //nolint:bodyclose
+		conn, used, resp, dialErr := ws.dial(ctx, mode) //nolint:bodyclose
+		dialFailed = dialErr != nil
 		cEvent.At = ws.nowFunc()
+		cEvent.Interface = used.iface
+		cEvent.Endpoint = used.url
+
+		if dialErr != nil && ctx.Err() == nil {
+			cEvent.Reason = dialReason(dialErr)
+			ws.setReconnectReason(cEvent.Reason, 0)
+			plan = ws.rejectedPlan(resp)
+		}
 
 		if dialErr == nil {
+			cEvent.Mode = used.mode.ToEvent()
+			cEvent.Compression = used.compression
+			ws.localReason.Store(event.DisconnectReason(""))
+
 			ws.connectListeners.Visit(func(l event.ConnectListener) {
 				l.OnConnect(cEvent)
 			})
 
+			ws.stats.connected(cEvent.At)
+
 			// Reset the retry policy on a successful connection.
-			policy = ws.retryPolicyFactory.NewPolicy(ctx)
+			policy = policyFactory.NewPolicy(ctx)
 
 			// Store the connection so writing can take place.
 			ws.m.Lock()
@@ -285,6 +536,22 @@ func (ws *Websocket) run(ctx context.Context) {
 			})
 			ws.m.Unlock()
 
+			// Let the writer know it can flush any held messages.
+			select {
+			case ws.connected <- struct{}{}:
+			default:
+			}
+
+			// Fail over if the interface the connection was made over goes down.
+			watchCtx, stopWatching := context.WithCancel(ctx)
+			go ws.watchInterface(watchCtx, conn, used.iface)
+
+			// Return to a preferred endpoint once it is healthy again.
+			go ws.watchEndpoint(watchCtx, conn, used)
+
+			// Measure the round trip time with our own pings.
+			go ws.measureRTT(watchCtx, conn)
+
 			// Read loop
 			for {
 				var msg wrp.Message
@@ -317,13 +584,22 @@ func (ws *Websocket) run(ctx context.Context) {
 					break
 				}
 
-				if err == nil {
+				var (
+					reason event.DisconnectReason
+					code   int
+				)
+				if err != nil {
+					reason, code = ws.disconnectReason(err, ctxErr)
+				} else {
 					if typ != nhws.MessageBinary {
 						err = ErrInvalidMsgType
 					} else {
-						decoder.Reset(reader)
+						counter := countingReader{r: reader}
+						decoder.Reset(&counter)
 						err = decoder.Decode(&msg)
+						ws.stats.received(counter.n)
 					}
+					reason = event.ReasonDecodeError
 				}
 
 				// Cancel ws.conn.Reader()'s context after wrp decoding.
@@ -337,9 +613,15 @@ func (ws *Websocket) run(ctx context.Context) {
 					// that could not be decoded.  Close & reconnect.
 					_ = conn.Close(nhws.StatusUnsupportedData, limit(err.Error()))
 
+					ws.setReconnectReason(reason, code)
+					if reason == event.ReasonServerClose {
+						plan = ws.closedPlan(err)
+					}
 					dEvent := event.Disconnect{
-						At:  ws.nowFunc(),
-						Err: err,
+						At:        ws.nowFunc(),
+						Reason:    reason,
+						CloseCode: code,
+						Err:       err,
 					}
 					ws.disconnectListeners.Visit(func(l event.DisconnectListener) {
 						l.OnDisconnect(dEvent)
@@ -352,13 +634,18 @@ func (ws *Websocket) run(ctx context.Context) {
 					l.OnMessage(msg)
 				})
 			}
+
+			stopWatching()
+			ws.stats.disconnected()
 		}
 
 		if ws.once {
 			return
 		}
 
+		// The close code or response may call for a longer wait.
 		next, _ = policy.Next()
+		next = max(next, plan.delay)
 
 		if dialErr != nil {
 			cEvent.Err = dialErr
@@ -368,6 +655,10 @@ func (ws *Websocket) run(ctx context.Context) {
 			})
 		}
 
+		if plan.refresh {
+			ws.refreshCredentials(ctx)
+		}
+
 		select {
 		case <-time.After(next):
 		case <-ctx.Done():
@@ -376,32 +667,111 @@ func (ws *Websocket) run(ctx context.Context) {
 	}
 }
 
-func (ws *Websocket) dial(ctx context.Context, mode ipMode) (*nhws.Conn, *http.Response, error) {
+// dialed describes the connection made by dial.
+type dialed struct {
+	// mode is the address family of the connection.
+	mode ipMode
+
+	// iface is the name of the network interface the connection was made
+	// over, or empty if no interfaces are configured.
+	iface string
+
+	// url is the url of the endpoint that was dialed.
+	url string
+
+	// endpoint is the endpoint that was dialed.
+	endpoint *endpoint
+
+	// compression is the compression mode negotiated with the server.
+	compression event.CompressionMode
+}
+
+// dial connects to the healthiest endpoint using mode as the preferred address
+// family.  If network interfaces are configured, each is tried in priority
+// order until a connection is made.
+func (ws *Websocket) dial(ctx context.Context, mode ipMode) (*nhws.Conn, dialed, *http.Response, error) {
+	return ws.dialEndpoint(ctx, ws.endpoints.choose(ws.nowFunc()), mode)
+}
+
+// dialEndpoint connects to the endpoint and records the outcome in its health.
+func (ws *Websocket) dialEndpoint(ctx context.Context, ep *endpoint, mode ipMode) (*nhws.Conn, dialed, *http.Response, error) {
 	fetchCtx, cancel := context.WithTimeout(ctx, ws.urlFetchingTimeout)
 	defer cancel()
-	url, err := ws.urlFetcher(fetchCtx)
+	url, err := ep.fetch(fetchCtx)
 	if err != nil {
-		return nil, nil, err
+		ws.endpoints.failed(ep, ws.nowFunc())
+		return nil, dialed{mode: mode}, nil, err
 	}
 
-	client, err := ws.newHTTPClient(mode)
+	ifaces, err := ws.candidateInterfaces()
 	if err != nil {
-		return nil, nil, err
+		return nil, dialed{mode: mode, url: url}, nil, err
+	}
+
+	var (
+		errs []error
+		resp *http.Response
+		conn *nhws.Conn
+		d    dialed
+	)
+	for _, iface := range ifaces {
+		conn, d, resp, err = ws.dialOver(ctx, url, mode, iface)
+		d.url, d.endpoint = url, ep
+		if err == nil {
+			ws.endpoints.succeeded(ep)
+			return conn, d, resp, nil
+		}
+
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	ws.endpoints.failed(ep, ws.nowFunc())
+	return nil, d, resp, errors.Join(errs...)
+}
+
+// dialOver connects to the websocket over the interface, or any interface if
+// iface is nil.
+func (ws *Websocket) dialOver(ctx context.Context, url string, mode ipMode, iface *net.Interface) (*nhws.Conn, dialed, *http.Response, error) {
+	d := dialed{mode: mode}
+	if iface != nil {
+		d.iface = iface.Name
+		if ws.interfaceUsed != nil {
+			ws.interfaceUsed(iface.Name)
+		}
+	}
+
+	// Decorate after the interface is chosen so the metadata matches it.
+	ws.conveyDecorator(ws.additionalHeaders)
+
+	var used atomic.Value
+	used.Store(mode)
+	client, err := ws.newHTTPClient(mode, iface, func(m ipMode) {
+		used.Store(m)
+	})
+	if err != nil {
+		return nil, d, nil, err
 	}
 
 	conn, resp, err := nhws.Dial(ctx, url,
 		&nhws.DialOptions{
-			HTTPHeader: ws.additionalHeaders,
-			HTTPClient: client,
+			HTTPHeader:           ws.additionalHeaders,
+			HTTPClient:           client,
+			CompressionMode:      ws.compression.toNHWS(),
+			CompressionThreshold: ws.compressionThreshold,
 		},
 	)
 	if err != nil {
-		return nil, resp, err
+		return nil, d, resp, err
 	}
 
 	conn.SetReadLimit(ws.maxMessageBytes)
 	conn.SetPingWriteTimeout(ws.pingWriteTimeout)
-	return conn, resp, nil
+	d.mode = used.Load().(ipMode)
+	d.compression = negotiatedCompression(conn)
+	return conn, d, resp, nil
 }
 
 type custRT struct {
@@ -412,8 +782,12 @@ func (rt *custRT) RoundTrip(r *http.Request) (*http.Response, error) {
 	return rt.transport.RoundTrip(r)
 }
 
-// newHTTPClient returns a HTTP client using the provided `mode` as its named network.
-func (ws *Websocket) newHTTPClient(mode ipMode) (*http.Client, error) {
+// newHTTPClient returns a HTTP client using the provided `mode` as its named
+// network.  If both address families are allowed, `mode` is the preferred
+// family and the other family is raced against it.  If `iface` is not nil,
+// connections are bound to it.  The `won` function is called with the family
+// of each connection made.
+func (ws *Websocket) newHTTPClient(mode ipMode, iface *net.Interface, won func(ipMode)) (*http.Client, error) {
 	config := ws.httpClientConfig
 	client, err := config.NewClient()
 	if err != nil {
@@ -435,20 +809,86 @@ func (ws *Websocket) newHTTPClient(mode ipMode) (*http.Client, error) {
 		return nil, err
 	}
 
-	transport.Proxy = http.ProxyFromEnvironment
+	if ws.getClientCertificate != nil {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = new(tls.Config)
+		}
+		transport.TLSClientConfig.Certificates = nil
+		transport.TLSClientConfig.GetClientCertificate = ws.getClientCertificate
+	}
+
+	transport.Proxy = ws.proxy
 	dialer := &net.Dialer{
 		Timeout:   client.Timeout,
 		KeepAlive: ws.keepAliveInterval,
 		DualStack: false,
 	}
+	dial := dialer.DialContext
+	if iface != nil {
+		dial = ws.bindDialer(dialer, iface)
+	}
 	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return dialer.DialContext(ctx, string(mode), addr)
+		conn, err := dial(ctx, string(mode), addr)
+		if err == nil {
+			won(mode)
+		}
+		return conn, err
+	}
+	if ws.withIPv4 && ws.withIPv6 && ws.fallbackDelay >= 0 {
+		delay := ws.fallbackDelay
+		if delay == 0 {
+			delay = defaultFallbackDelay
+		}
+		transport.DialContext = happyEyeballs(dial, mode, delay, won)
 	}
 	client.Transport = &custRT{transport: transport}
 
 	return client, nil
 }
 
+// fetchRedirect returns the current redirect.
+func (ws *Websocket) fetchRedirect(ctx context.Context) (Redirect, error) {
+	ctx, cancel := context.WithTimeout(ctx, ws.urlFetchingTimeout)
+	defer cancel()
+
+	return ws.redirectFetcher(ctx)
+}
+
+// updateRedirect fetches the redirect and updates the endpoints if they
+// changed.  The last redirect is kept if the fetch fails, so a transient
+// failure doesn't reset the health of the redirected endpoints.
+func (ws *Websocket) updateRedirect(ctx context.Context, last *Redirect) *Redirect {
+	r, err := ws.fetchRedirect(ctx)
+	if err != nil {
+		return last
+	}
+
+	if last == nil || !slices.Equal(r.Endpoints, last.Endpoints) {
+		ws.endpoints.redirect(r.Endpoints)
+	}
+	return &r
+}
+
+// preferredMode returns the mode for the family if it is allowed, otherwise
+// mode.
+func (ws *Websocket) preferredMode(family string, mode ipMode) ipMode {
+	switch {
+	case strings.EqualFold(family, "ipv4") && ws.withIPv4:
+		return ipv4
+	case strings.EqualFold(family, "ipv6") && ws.withIPv6:
+		return ipv6
+	}
+	return mode
+}
+
+// sameRetry returns true if the retry policies are the same.
+func sameRetry(a, b *retry.Config) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 func (ws *Websocket) nextMode(mode ipMode) ipMode {
 	if mode == ipv4 && ws.withIPv6 {
 		return ipv6
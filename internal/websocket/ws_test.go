@@ -143,6 +143,94 @@ func TestNew(t *testing.T) {
 				PingWriteTimeout(-1),
 			},
 			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "negative stats interval",
+			opts: []Option{
+				StatsInterval(-1),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "nil FetchRedirect",
+			opts: []Option{
+				FetchRedirect(nil),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "circuit breaker threshold only",
+			opts: append(
+				wsDefaults,
+				URL("http://example.com"),
+				DeviceID("mac:112233445566"),
+				CredentialsDecorator(func(h http.Header) error {
+					return nil
+				}),
+				ConveyDecorator(func(h http.Header) error {
+					return nil
+				}),
+				NowFunc(time.Now),
+				RetryPolicy(retry.Config{}),
+				CircuitBreaker(5, 0),
+			),
+			check: func(assert *assert.Assertions, c *Websocket) {
+				assert.Equal(5, c.failureThreshold)
+				assert.Equal(defaultCooldown, c.cooldown)
+			},
+		}, {
+			description: "circuit breaker cooldown only",
+			opts: append(
+				wsDefaults,
+				URL("http://example.com"),
+				DeviceID("mac:112233445566"),
+				CredentialsDecorator(func(h http.Header) error {
+					return nil
+				}),
+				ConveyDecorator(func(h http.Header) error {
+					return nil
+				}),
+				NowFunc(time.Now),
+				RetryPolicy(retry.Config{}),
+				CircuitBreaker(0, time.Hour),
+			),
+			check: func(assert *assert.Assertions, c *Websocket) {
+				assert.Equal(defaultFailureThreshold, c.failureThreshold)
+				assert.Equal(time.Hour, c.cooldown)
+			},
+		}, {
+			description: "negative circuit breaker threshold",
+			opts: []Option{
+				CircuitBreaker(-1, 0),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "negative rtt interval",
+			opts: []Option{
+				RTTInterval(-1),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "reconnect rule without codes",
+			opts: []Option{
+				ReconnectRules(ReconnectRule{Delay: time.Minute}),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "reconnect rule with negative delay",
+			opts: []Option{
+				ReconnectRules(ReconnectRule{CloseCodes: []int{1008}, Delay: -1}),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "unknown compression mode",
+			opts: []Option{
+				Compression("gzip", 0),
+			},
+			expectedErr: ErrMisconfiguredWS,
+		}, {
+			description: "negative compression threshold",
+			opts: []Option{
+				Compression(CompressionContextTakeover, -1),
+			},
+			expectedErr: ErrMisconfiguredWS,
 		},
 
 		// Test the now func option
@@ -397,6 +485,65 @@ func TestNextMode(t *testing.T) {
 	}
 }
 
+func TestPreferredMode(t *testing.T) {
+	tests := []struct {
+		description string
+		family      string
+		ipv4, ipv6  bool
+		mode        ipMode
+		expected    ipMode
+	}{
+		{
+			description: "no preference",
+			ipv4:        true,
+			ipv6:        true,
+			mode:        ipv4,
+			expected:    ipv4,
+		}, {
+			description: "prefer IPv6",
+			family:      "ipv6",
+			ipv4:        true,
+			ipv6:        true,
+			mode:        ipv4,
+			expected:    ipv6,
+		}, {
+			description: "prefer IPv4",
+			family:      "IPv4",
+			ipv4:        true,
+			ipv6:        true,
+			mode:        ipv6,
+			expected:    ipv4,
+		}, {
+			description: "preferred family is not allowed",
+			family:      "ipv6",
+			ipv4:        true,
+			mode:        ipv4,
+			expected:    ipv4,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			ws := Websocket{
+				withIPv4: tc.ipv4,
+				withIPv6: tc.ipv6,
+			}
+			assert.Equal(t, tc.expected, ws.preferredMode(tc.family, tc.mode))
+		})
+	}
+}
+
+func TestSameRetry(t *testing.T) {
+	a := &retry.Config{Interval: time.Second}
+	b := &retry.Config{Interval: time.Second}
+	c := &retry.Config{Interval: time.Minute}
+
+	assert.True(t, sameRetry(nil, nil))
+	assert.True(t, sameRetry(a, b))
+	assert.False(t, sameRetry(a, c))
+	assert.False(t, sameRetry(a, nil))
+	assert.False(t, sameRetry(nil, a))
+}
+
 func TestLimit(t *testing.T) {
 	tests := []struct {
 		description string
@@ -478,3 +625,66 @@ func Test_CancelCtx(t *testing.T) {
 	got.Stop()
 
 }
+
+func TestUpdateRedirect(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var fail bool
+	redirect := Redirect{
+		Endpoints: []Endpoint{{URL: "ws://a"}},
+		IPFamily:  "ipv4",
+	}
+
+	got, err := New(
+		URL("http://example.com"),
+		DeviceID("mac:112233445566"),
+		WithIPv4(),
+		NowFunc(time.Now),
+		RetryPolicy(retry.Config{}),
+		CredentialsDecorator(func(h http.Header) error {
+			return nil
+		}),
+		ConveyDecorator(func(h http.Header) error {
+			return nil
+		}),
+		CircuitBreaker(1, time.Minute),
+		FetchRedirect(func(context.Context) (Redirect, error) {
+			if fail {
+				return Redirect{}, errors.New("no redirect")
+			}
+			return redirect, nil
+		}),
+	)
+	require.NoError(err)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Nothing is used until a redirect is fetched.
+	fail = true
+	assert.Nil(got.updateRedirect(ctx, nil))
+	assert.Equal("http://example.com", endpointURL(t, got.endpoints.choose(now)))
+
+	fail = false
+	last := got.updateRedirect(ctx, nil)
+	require.NotNil(last)
+	assert.Equal(redirect, *last)
+
+	a := got.endpoints.choose(now)
+	assert.Equal("ws://a", endpointURL(t, a))
+	got.endpoints.failed(a, now)
+
+	// A failed fetch keeps the last redirect and the health of its endpoints.
+	fail = true
+	assert.Same(last, got.updateRedirect(ctx, last))
+	assert.Equal([]*endpoint{a}, got.endpoints.list)
+	assert.Equal(1, a.failures)
+
+	// The same redirect doesn't change the endpoints either.
+	fail = false
+	last = got.updateRedirect(ctx, last)
+	require.NotNil(last)
+	assert.Equal([]*endpoint{a}, got.endpoints.list)
+	assert.Equal(1, a.failures)
+}
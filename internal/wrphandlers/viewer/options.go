@@ -36,6 +36,18 @@ func Policies(policies ...string) Option {
 	})
 }
 
+// MaxFileSize is an option that limits the number of bytes read from a file,
+// even if the request asks for more.  0 means there is no limit.  (Optional)
+func MaxFileSize(size int) Option {
+	return optionFunc(func(h *Handler) error {
+		if size < 0 {
+			return errors.New("max file size may not be negative")
+		}
+		h.maxFileSize = size
+		return nil
+	})
+}
+
 //------------------------------------------------------------------------------
 
 func validate() Option {
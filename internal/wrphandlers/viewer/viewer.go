@@ -26,6 +26,7 @@ type Handler struct {
 	root         fs.FS
 	trustedRoots []*x509.Certificate
 	policies     []string
+	maxFileSize  int
 }
 
 type Option interface {
@@ -206,6 +207,9 @@ func (h *Handler) processMsg(in []byte) (map[string]securly.File, error) {
 	if cmd.MaxSize > 0 {
 		size = cmd.MaxSize
 	}
+	if h.maxFileSize > 0 {
+		size = min(size, h.maxFileSize)
+	}
 
 	file, err := h.readFile(path, size)
 	if err != nil {
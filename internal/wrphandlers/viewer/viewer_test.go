@@ -4,6 +4,7 @@
 package viewer
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"io/fs"
 	"os/user"
@@ -109,12 +110,63 @@ func getCurrentGroup() string {
 	return grpInfo.Name
 }
 
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			opts: []Option{
+				Root(testFS),
+				Trust(&x509.Certificate{}),
+				Policies("policy"),
+				MaxFileSize(100),
+			},
+		}, {
+			name:    "no root",
+			opts:    []Option{Trust(&x509.Certificate{})},
+			wantErr: true,
+		}, {
+			name:    "no trusted roots",
+			opts:    []Option{Root(testFS)},
+			wantErr: true,
+		}, {
+			name: "negative max file size",
+			opts: []Option{
+				Root(testFS),
+				Trust(&x509.Certificate{}),
+				MaxFileSize(-1),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			h, err := New(nil, tt.opts...)
+			if tt.wantErr {
+				assert.Error(err)
+				assert.Nil(h)
+				return
+			}
+
+			assert.NoError(err)
+			assert.NotNil(h)
+		})
+	}
+}
+
 func TestProcessMsg(t *testing.T) {
 	tests := []struct {
-		name     string
-		cmd      Command
-		expected map[string]securly.File
-		err      error
+		name        string
+		cmd         Command
+		maxFileSize int
+		expected    map[string]securly.File
+		err         error
 	}{
 		{
 			name: "Valid Directory",
@@ -172,6 +224,21 @@ func TestProcessMsg(t *testing.T) {
 					Data:    testFS["dir/a.txt"].Data,
 				},
 			},
+		}, {
+			name: "File Limited by the Max File Size",
+			cmd: Command{
+				Path:    "/dir/a.txt",
+				MaxSize: 100,
+			},
+			maxFileSize: 4,
+			expected: map[string]securly.File{
+				"/dir/a.txt": {
+					Mode:    testFS["dir/a.txt"].Mode,
+					Size:    int64(len(testFS["dir/a.txt"].Data)),
+					ModTime: testFS["dir/a.txt"].ModTime,
+					Data:    testFS["dir/a.txt"].Data[:4],
+				},
+			},
 		}, {
 			name: "Invalid Path",
 			cmd: Command{
@@ -187,7 +254,8 @@ func TestProcessMsg(t *testing.T) {
 			require := require.New(t)
 
 			h := Handler{
-				root: testFS,
+				root:        testFS,
+				maxFileSize: tt.maxFileSize,
 			}
 
 			cmd, err := json.Marshal(tt.cmd)
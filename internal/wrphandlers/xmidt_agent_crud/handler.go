@@ -4,28 +4,97 @@
 package xmidt_agent_crud
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials"
 	"github.com/xmidt-org/xmidt-agent/internal/loglevel"
 	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
 )
 
 const DefaultLogLevelChangeDuration = 30 * time.Minute
 
+// DefaultMarkInvalidTimeout is how long an UPDATE of the "credentials" path
+// waits for the credentials to accept the request to refetch.
+const DefaultMarkInvalidTimeout = 10 * time.Second
+
 type Handler struct {
-	egress   wrpkit.Handler
-	source   string
-	logLevel loglevel.LogLevel
+	egress       wrpkit.Handler
+	source       string
+	logLevel     loglevel.LogLevel
+	services     Services
+	certificates Certificates
+	credentials  Credentials
+}
+
+// Services is the interface implemented by types that can report the
+// registered libparodus services.
+type Services interface {
+	Services() []libparodus.Service
+}
+
+// Certificates is the interface implemented by types that can reload the
+// client certificates from disk.
+type Certificates interface {
+	Reload() error
+}
+
+// Credentials is the interface implemented by types that can report the
+// state of the credentials and be told to fetch them again.
+type Credentials interface {
+	Status() credentials.Status
+	MarkInvalid(context.Context)
+}
+
+// Option is the interface implemented by types that can be used to
+// configure the handler.
+type Option interface {
+	apply(*Handler) error
+}
+
+type optionFunc func(*Handler) error
+
+func (f optionFunc) apply(h *Handler) error {
+	return f(h)
+}
+
+// WithServices sets the source of the registered services reported by a
+// RETRIEVE of the "services" path.
+func WithServices(services Services) Option {
+	return optionFunc(func(h *Handler) error {
+		h.services = services
+		return nil
+	})
+}
+
+// WithCertificates sets the client certificates that are reloaded by an
+// UPDATE of the "certificates" path.
+func WithCertificates(certificates Certificates) Option {
+	return optionFunc(func(h *Handler) error {
+		h.certificates = certificates
+		return nil
+	})
+}
+
+// WithCredentials sets the credentials reported by a RETRIEVE of the
+// "credentials" path and refetched by an UPDATE of it.
+func WithCredentials(creds Credentials) Option {
+	return optionFunc(func(h *Handler) error {
+		h.credentials = creds
+		return nil
+	})
 }
 
 // New creates a new instance of the Handler struct.  The parameter egress is
 // the handler that will be called to send the response.  The parameter source is the source to use in
 // the response message. This handler handles crud messages specifically for xmdit-agent, only.
-func New(egress wrpkit.Handler, source string, logLevel loglevel.LogLevel) (*Handler, error) {
+func New(egress wrpkit.Handler, source string, logLevel loglevel.LogLevel, opts ...Option) (*Handler, error) {
 
 	h := Handler{
 		egress:   egress,
@@ -33,6 +102,14 @@ func New(egress wrpkit.Handler, source string, logLevel loglevel.LogLevel) (*Han
 		logLevel: logLevel,
 	}
 
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return &h, nil
 }
 
@@ -43,7 +120,11 @@ func (h *Handler) HandleWrp(msg wrp.Message) error {
 	response.ContentType = "application/json"
 	payload := make(map[string]string)
 
-	err := json.Unmarshal(msg.Payload, &payload)
+	// An empty payload is an empty request, not malformed json.
+	var err error
+	if len(msg.Payload) > 0 {
+		err = json.Unmarshal(msg.Payload, &payload)
+	}
 	if err != nil {
 		statusCode := int64(http.StatusInternalServerError)
 		response.Status = &statusCode
@@ -62,6 +143,14 @@ func (h *Handler) HandleWrp(msg wrp.Message) error {
 			payloadResponse = []byte(fmt.Sprintf(`{statusCode: %d, message: "%s"}`, statusCode, err.Error()))
 		}
 
+	case wrp.RetrieveMessageType:
+		var body []byte
+		statusCode, body, err = h.retrieve(msg.Path)
+		payloadResponse = body
+		if err != nil {
+			payloadResponse = []byte(fmt.Sprintf(`{statusCode: %d, message: "%s"}`, statusCode, err.Error()))
+		}
+
 	default:
 
 	}
@@ -87,12 +176,68 @@ func (h *Handler) update(path string, payload map[string]string) (int64, error)
 		}
 		return okStatus, nil
 
+	case "certificates":
+		if h.certificates == nil {
+			return badRequestStatus, errors.New("certificates are not available")
+		}
+
+		if err := h.certificates.Reload(); err != nil {
+			return int64(http.StatusInternalServerError), err
+		}
+		return okStatus, nil
+
+	case "credentials":
+		if h.credentials == nil {
+			return badRequestStatus, errors.New("credentials are not available")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultMarkInvalidTimeout)
+		defer cancel()
+
+		h.credentials.MarkInvalid(ctx)
+		if err := ctx.Err(); err != nil {
+			return int64(http.StatusServiceUnavailable), err
+		}
+		return okStatus, nil
+
 	default:
 		return badRequestStatus, nil
 	}
 
 }
 
+func (h *Handler) retrieve(path string) (int64, []byte, error) {
+	badRequestStatus := int64(http.StatusBadRequest)
+	okStatus := int64(http.StatusOK)
+
+	switch path {
+	case "services":
+		if h.services == nil {
+			return badRequestStatus, nil, errors.New("services are not available")
+		}
+
+		body, err := json.Marshal(h.services.Services())
+		if err != nil {
+			return int64(http.StatusInternalServerError), nil, err
+		}
+		return okStatus, body, nil
+
+	case "credentials":
+		if h.credentials == nil {
+			return badRequestStatus, nil, errors.New("credentials are not available")
+		}
+
+		body, err := json.Marshal(h.credentials.Status())
+		if err != nil {
+			return int64(http.StatusInternalServerError), nil, err
+		}
+		return okStatus, body, nil
+
+	default:
+		return badRequestStatus, []byte(fmt.Sprintf(`{statusCode: %d, message: "%s"}`, badRequestStatus, "")), nil
+	}
+}
+
 func (h *Handler) changeLogLevel(payload map[string]string) error {
 	duration, err := time.ParseDuration(payload["duration"])
 	if err != nil {
@@ -4,6 +4,9 @@
 package xmidt_agent_crud
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"testing"
 	"time"
@@ -12,6 +15,8 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/xmidt-agent/internal/adapters/libparodus"
+	"github.com/xmidt-org/xmidt-agent/internal/credentials"
 	"github.com/xmidt-org/xmidt-agent/internal/wrpkit"
 )
 
@@ -26,7 +31,55 @@ func (m *mockLogLevel) SetLevel(level string, d time.Duration) error {
 	return args.Error(0)
 }
 
+type mockCertificates struct {
+	err     error
+	reloads int
+}
+
+func (m *mockCertificates) Reload() error {
+	m.reloads++
+	return m.err
+}
+
+type mockCredentials struct {
+	status       credentials.Status
+	markInvalids int
+}
+
+func (m *mockCredentials) Status() credentials.Status {
+	return m.status
+}
+
+func (m *mockCredentials) MarkInvalid(context.Context) {
+	m.markInvalids++
+}
+
+type mockServices []libparodus.Service
+
+func (m mockServices) Services() []libparodus.Service {
+	return m
+}
+
 func TestHandler_HandleWrp(t *testing.T) {
+	registeredAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	services := mockServices{
+		{
+			Name:         "config",
+			URL:          "tcp://127.0.0.1:6667",
+			RegisteredAt: registeredAt,
+			LastActivity: registeredAt.Add(time.Minute),
+			Alive:        true,
+		},
+	}
+
+	status := credentials.Status{
+		Origin:         "network",
+		Valid:          true,
+		ExpiresAt:      registeredAt.Add(24 * time.Hour),
+		LastFetch:      registeredAt,
+		LastStatusCode: http.StatusOK,
+	}
+
 	tests := []struct {
 		description     string
 		egressResult    error
@@ -34,6 +87,10 @@ func TestHandler_HandleWrp(t *testing.T) {
 		msg             wrp.Message
 		expectedErr     error
 		logLevelMock    *mockLogLevel
+		services        Services
+		certificates    *mockCertificates
+		credentials     *mockCredentials
+		markInvalids    int
 		mockCalls       func(*mockLogLevel)
 		validate        func(*assert.Assertions, wrp.Message, *mockLogLevel) error
 	}{
@@ -121,6 +178,205 @@ func TestHandler_HandleWrp(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			description:     "reload the certificates",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "certificates",
+				Payload:     []byte("{}"),
+			},
+			logLevelMock: newMockLogLevel(),
+			certificates: &mockCertificates{},
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusOK), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "reload the certificates fails",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "certificates",
+				Payload:     []byte("{}"),
+			},
+			logLevelMock: newMockLogLevel(),
+			certificates: &mockCertificates{err: errors.New("bad key")},
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusInternalServerError), *msg.Status)
+				a.Contains(string(msg.Payload), "bad key")
+				return nil
+			},
+		},
+		{
+			description:     "reload the certificates without a source",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "certificates",
+				Payload:     []byte("{}"),
+			},
+			logLevelMock: newMockLogLevel(),
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusBadRequest), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "retrieve the registered services",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "services",
+			},
+			logLevelMock: newMockLogLevel(),
+			services:     services,
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusOK), *msg.Status)
+
+				var got []libparodus.Service
+				a.NoError(json.Unmarshal(msg.Payload, &got))
+				a.Equal([]libparodus.Service(services), got)
+				return nil
+			},
+		},
+		{
+			description:     "retrieve the services without a source",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "services",
+			},
+			logLevelMock: newMockLogLevel(),
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusBadRequest), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "refetch the credentials",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "credentials",
+				Payload:     []byte("{}"),
+			},
+			logLevelMock: newMockLogLevel(),
+			credentials:  &mockCredentials{},
+			markInvalids: 1,
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusOK), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "refetch the credentials with an empty payload",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "credentials",
+			},
+			logLevelMock: newMockLogLevel(),
+			credentials:  &mockCredentials{},
+			markInvalids: 1,
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusOK), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "refetch the credentials without a source",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "credentials",
+				Payload:     []byte("{}"),
+			},
+			logLevelMock: newMockLogLevel(),
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusBadRequest), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "retrieve the credentials status",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "credentials",
+			},
+			logLevelMock: newMockLogLevel(),
+			credentials:  &mockCredentials{status: status},
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusOK), *msg.Status)
+
+				var got credentials.Status
+				a.NoError(json.Unmarshal(msg.Payload, &got))
+				a.Equal(status, got)
+				return nil
+			},
+		},
+		{
+			description:     "retrieve the credentials status without a source",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "credentials",
+			},
+			logLevelMock: newMockLogLevel(),
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusBadRequest), *msg.Status)
+				return nil
+			},
+		},
+		{
+			description:     "retrieve some nonexistent path",
+			egressCallCount: 1,
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:tr1d1um.example.com/service/ignored",
+				Destination: "xmidt-agent",
+				Path:        "no_such_path",
+			},
+			logLevelMock: newMockLogLevel(),
+			services:     services,
+			mockCalls:    func(*mockLogLevel) {},
+			validate: func(a *assert.Assertions, msg wrp.Message, _ *mockLogLevel) error {
+				a.Equal(int64(http.StatusBadRequest), *msg.Status)
+				return nil
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.description, func(t *testing.T) {
@@ -139,12 +395,27 @@ func TestHandler_HandleWrp(t *testing.T) {
 
 			tc.mockCalls(tc.logLevelMock)
 
-			h, err := New(egress, "some-source", tc.logLevelMock)
+			opts := []Option{WithServices(tc.services)}
+			if tc.certificates != nil {
+				opts = append(opts, WithCertificates(tc.certificates))
+			}
+			if tc.credentials != nil {
+				opts = append(opts, WithCredentials(tc.credentials))
+			}
+
+			h, err := New(egress, "some-source", tc.logLevelMock, opts...)
 			require.NoError(err)
 
 			err = h.HandleWrp(tc.msg)
 			assert.ErrorIs(err, tc.expectedErr)
 
+			if tc.certificates != nil {
+				assert.Equal(1, tc.certificates.reloads)
+			}
+			if tc.credentials != nil {
+				assert.Equal(tc.markInvalids, tc.credentials.markInvalids)
+			}
+
 			assert.Equal(tc.egressCallCount, egressCallCount)
 		})
 	}